@@ -0,0 +1,188 @@
+// Package annotation keeps the highlights readers make in articles, each a
+// quoted passage with an optional note, which are only shown alongside the
+// article once they've been approved by a moderator.
+package annotation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A Mode decides how readers may annotate articles.
+type Mode string
+
+const (
+	// Off doesn't let readers annotate articles, the default
+	Off Mode = "off"
+	// Native lets readers save highlights, with notes, to gournal, which are
+	// shown once approved
+	Native Mode = "native"
+	// Hypothesis embeds the Hypothesis client, keeping annotations on its
+	// service rather than in gournal
+	Hypothesis Mode = "hypothesis"
+)
+
+// Modes lists every Mode a site, or an article, may choose
+var Modes = []Mode{Off, Native, Hypothesis}
+
+// ParseMode returns the Mode named s, treating an empty name as Off
+func ParseMode(s string) (Mode, error) {
+	if s == "" {
+		return Off, nil
+	}
+	for _, m := range Modes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("annotation: unknown mode %q", s)
+}
+
+// the limits, in characters, on the parts of an Annotation
+const (
+	MaxQuoteLength = 1000
+	MaxNoteLength  = 2000
+	MaxNameLength  = 100
+)
+
+// An Annotation highlights a Quote from the article with the ID Article,
+// optionally with a Note about it by the reader Name.
+type Annotation struct {
+	ID       string
+	Article  string
+	Quote    string
+	Note     string `json:",omitempty"`
+	Name     string `json:",omitempty"`
+	Created  time.Time
+	Approved bool `json:",omitempty"`
+}
+
+// A Store is a set of Annotations, persisted as JSON in a file.
+type Store struct {
+	sync.RWMutex
+
+	path string
+	// in the order they were made
+	list []*Annotation
+}
+
+// Open loads the Store kept at path, which need not exist yet
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Add validates a and stores it, awaiting approval, returning it as stored
+func (s *Store) Add(a Annotation) (*Annotation, error) {
+	a.Quote = strings.Join(strings.Fields(a.Quote), " ")
+	a.Note = strings.TrimSpace(a.Note)
+	a.Name = strings.TrimSpace(a.Name)
+	switch {
+	case a.Article == "":
+		return nil, fmt.Errorf("annotation: an article is required")
+	case a.Quote == "":
+		return nil, fmt.Errorf("annotation: select some text to highlight")
+	case utf8.RuneCountInString(a.Quote) > MaxQuoteLength:
+		return nil, fmt.Errorf("annotation: highlights may be at most %d characters", MaxQuoteLength)
+	case utf8.RuneCountInString(a.Note) > MaxNoteLength:
+		return nil, fmt.Errorf("annotation: notes may be at most %d characters", MaxNoteLength)
+	case utf8.RuneCountInString(a.Name) > MaxNameLength:
+		return nil, fmt.Errorf("annotation: names may be at most %d characters", MaxNameLength)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	a.ID = hex.EncodeToString(id)
+	a.Created = time.Now().UTC()
+	a.Approved = false
+
+	s.Lock()
+	defer s.Unlock()
+	s.list = append(s.list, &a)
+	if err := s.save(); err != nil {
+		s.list = s.list[:len(s.list)-1]
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Approved returns the approved Annotations of the article with the ID
+// article, in the order they were made
+func (s *Store) Approved(article string) []Annotation {
+	return s.filter(func(a *Annotation) bool { return a.Approved && a.Article == article })
+}
+
+// Pending returns every Annotation awaiting approval, in the order they were
+// made
+func (s *Store) Pending() []Annotation {
+	return s.filter(func(a *Annotation) bool { return !a.Approved })
+}
+
+// Approve approves the Annotation identified by id, so it is shown
+func (s *Store) Approve(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, a := range s.list {
+		if a.ID == id {
+			a.Approved = true
+			return s.save()
+		}
+	}
+	return fmt.Errorf("annotation: no annotation %q", id)
+}
+
+// Remove deletes the Annotation identified by id, whether or not it was
+// approved
+func (s *Store) Remove(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, a := range s.list {
+		if a.ID == id {
+			s.list = append(s.list[:i], s.list[i+1:]...)
+			return s.save()
+		}
+	}
+	return fmt.Errorf("annotation: no annotation %q", id)
+}
+
+// filter returns copies of the Annotations keep reports true for
+func (s *Store) filter(keep func(*Annotation) bool) []Annotation {
+	s.RLock()
+	defer s.RUnlock()
+	var res []Annotation
+	for _, a := range s.list {
+		if keep(a) {
+			res = append(res, *a)
+		}
+	}
+	return res
+}
+
+// save writes the Store to its path
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, b, 0600)
+}
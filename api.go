@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firegoby/gournal/article"
+	"github.com/firegoby/mux"
+)
+
+// JSON API ===================================================================
+
+// the most articles listed by a single request to the API
+const apiMaxLimit = 100
+
+// apiArticle is an article as represented by the JSON API
+type apiArticle struct {
+	ID          string
+	Slug        string
+	Title       string
+	Body        string
+	HTML        string `json:",omitempty"`
+	Author      article.Author
+	PublishAt   time.Time
+	Updated     time.Time
+	Excerpt     string             `json:",omitempty"`
+	Description string             `json:",omitempty"`
+	Tags        []string           `json:",omitempty"`
+	Visibility  article.Visibility `json:",omitempty"`
+	NoIndex     bool               `json:",omitempty"`
+	NoFollow    bool               `json:",omitempty"`
+	Meta        map[string]string  `json:",omitempty"`
+	Protected   bool               `json:",omitempty"`
+	Permalink   string
+	WordCount   int
+	ReadingTime int
+	Version     string
+}
+
+// apiArticleInput holds the fields of an article sent to the JSON API. Fields
+// left out of an update keep their current values.
+type apiArticleInput struct {
+	Slug        *string
+	Title       *string
+	Body        *string
+	Author      *article.Author
+	PublishAt   *time.Time
+	Excerpt     *string
+	Description *string
+	Tags        *[]string
+	Visibility  *article.Visibility
+	NoIndex     *bool
+	NoFollow    *bool
+	Meta        *map[string]string
+	// Password protects the article, or removes its password if empty
+	Password *string
+	// Summary describes the change, recorded with its revision
+	Summary string
+}
+
+// apiError is the body of the JSON API's error responses, with Fields listing
+// the problems with an article which couldn't be saved
+type apiError struct {
+	Status    int
+	Message   string
+	Fields    article.ValidationErrors `json:",omitempty"`
+	RequestID string                   `json:",omitempty"`
+}
+
+// newAPIArticle represents a for the JSON API in response to r, along with its
+// rendered HTML if withHTML is set. The body of an article protected by a
+// password, and the excerpt and description summarising it, are left out
+// unless r carries the admin's credentials or has unlocked it.
+func newAPIArticle(r *http.Request, a *article.Article, withHTML bool) (*apiArticle, error) {
+	res := &apiArticle{
+		ID:          a.ID,
+		Slug:        a.Slug,
+		Title:       a.Title,
+		Body:        a.Body,
+		Author:      a.Author,
+		PublishAt:   a.PublishAt,
+		Updated:     a.Updated(),
+		Excerpt:     a.Excerpt,
+		Description: a.Description,
+		Tags:        a.Tags,
+		Visibility:  a.Visibility,
+		NoIndex:     a.NoIndex,
+		NoFollow:    a.NoFollow,
+		Meta:        a.Meta,
+		Protected:   a.Protected(),
+		Permalink:   a.Permalink(),
+		WordCount:   a.WordCount(),
+		ReadingTime: a.ReadingTime(),
+		Version:     a.Version(),
+	}
+	if !unlocked(r, a) && !(&requestInfo{r}).IsAdmin() {
+		res.Body, res.Excerpt, res.Description = "", "", ""
+		return res, nil
+	}
+	if withHTML {
+		h, err := a.HTML()
+		if err != nil {
+			return nil, err
+		}
+		res.HTML = string(h)
+	}
+	return res, nil
+}
+
+// APIIndexArticleHandler lists articles for GET /api/v1/articles, newest first
+// or in the order given by the sort parameter, optionally only those with the
+// tag or author parameters, a page at a time selected by offset and limit.
+// The admin may list every article, scheduled or not, with all=true.
+func APIIndexArticleHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	order, err := article.ParseOrder(params.Get("sort"))
+	if err != nil {
+		apiErrorf(w, r, http.StatusBadRequest, "%v", err)
+		return
+	}
+	offset, limit := 0, 20
+	if s := params.Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			apiErrorf(w, r, http.StatusBadRequest, "offset must be a whole number")
+			return
+		}
+	}
+	if s := params.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > apiMaxLimit {
+			apiErrorf(w, r, http.StatusBadRequest, "limit must be between 1 and %d", apiMaxLimit)
+			return
+		}
+	}
+
+	q := article.Query().Sort(order)
+	for _, tag := range params["tag"] {
+		q.Tag(tag)
+	}
+	if author := params.Get("author"); author != "" {
+		q.Author(author)
+	}
+	if params.Get("all") == "true" {
+		if !apiRequireAdmin(w, r) {
+			return
+		}
+		q.Scheduled()
+	}
+	articles, err := q.Offset(offset).Limit(limit).Articles()
+	if err != nil {
+		apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	res := struct {
+		Articles []*apiArticle
+		Offset   int
+		Limit    int
+	}{[]*apiArticle{}, offset, limit}
+	for _, a := range articles {
+		item, err := newAPIArticle(r, a, false)
+		if err != nil {
+			apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		res.Articles = append(res.Articles, item)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// APIShowArticleHandler responds with a published article, rendered to HTML,
+// for GET /api/v1/articles/:slug, or with any article to the admin
+func APIShowArticleHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := apiLoadArticle(w, r)
+	if !ok {
+		return
+	}
+	admin := (&requestInfo{r}).IsAdmin()
+	if !a.Published() && !admin {
+		apiErrorf(w, r, http.StatusNotFound, "there is no article %s", a.Slug)
+		return
+	}
+	apiRespondArticle(w, r, http.StatusOK, a)
+}
+
+// APICreateArticleHandler creates an article from the JSON sent by the admin
+// for POST /api/v1/articles, responding 201 Created with the article, or 422
+// with the problems which stopped it being saved
+func APICreateArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiRequireAdmin(w, r) {
+		return
+	}
+	var in apiArticleInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	var slug string
+	if in.Slug != nil {
+		slug = *in.Slug
+	}
+	a, err := article.New("", "", slug)
+	if err == article.ErrInvalidSlug {
+		// let Validate report the slug alongside any other problems
+		a, _ = article.New("", "", "")
+		a.Slug = slug
+	}
+	if !applyAPIArticle(w, r, a, &in) {
+		return
+	}
+	if slug == "" {
+		a.Slug = a.DerivedSlug()
+	}
+	if errs, ok := a.Validate().(article.ValidationErrors); ok {
+		apiValidationError(w, r, errs)
+		return
+	}
+
+	a.Change = changeFrom(r, nil, a)
+	a.Change.Summary = strings.TrimSpace(in.Summary)
+	err = a.Save()
+	if err == article.ErrSlugExists {
+		apiValidationError(w, r, article.ValidationErrors{{Field: "Slug", Message: "is already used by another article"}})
+		return
+	}
+	if err != nil {
+		apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/articles/"+a.Slug)
+	apiRespondArticle(w, r, http.StatusCreated, a)
+}
+
+// APIUpdateArticleHandler updates an article with the JSON sent by the admin
+// for PUT /api/v1/articles/:slug, changing only the fields sent, renaming it if
+// a new Slug is sent. An If-Match header holding the article's Version, as its
+// ETag, refuses with 412 to overwrite changes saved since.
+func APIUpdateArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiRequireAdmin(w, r) {
+		return
+	}
+	a, ok := apiLoadArticle(w, r)
+	if !ok {
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, `"`) != a.Version() {
+		apiErrorf(w, r, http.StatusPreconditionFailed, "the article has changed since version %s", match)
+		return
+	}
+	var in apiArticleInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	before := *a
+	original := a.Slug
+	if !applyAPIArticle(w, r, a, &in) {
+		return
+	}
+	slug := original
+	if in.Slug != nil && *in.Slug != "" {
+		slug = *in.Slug
+	} else if in.Title != nil && before.SlugDerived() {
+		// keep a slug generated from the title in step with the new title
+		if slug = a.DerivedSlug(); slug != original {
+			slug = article.UniqueSlug(slug)
+		}
+	}
+
+	// validate as though already renamed, as UpdateArticleHandler does
+	a.Slug = slug
+	errs, _ := a.Validate().(article.ValidationErrors)
+	a.Slug = original
+	if errs == nil {
+		err := a.Rename(slug)
+		if err == article.ErrSlugExists {
+			errs = article.ValidationErrors{{Field: "Slug", Message: "is already used by another article"}}
+		} else if err != nil {
+			apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+			return
+		}
+	}
+	if errs != nil {
+		apiValidationError(w, r, errs)
+		return
+	}
+
+	a.Change = changeFrom(r, &before, a)
+	a.Change.Summary = strings.TrimSpace(in.Summary)
+	if err := a.Save(); err != nil {
+		apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	apiRespondArticle(w, r, http.StatusOK, a)
+}
+
+// APIDestroyArticleHandler moves an article to the trash for
+// DELETE /api/v1/articles/:slug, responding 204 No Content
+func APIDestroyArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if !apiRequireAdmin(w, r) {
+		return
+	}
+	a, ok := apiLoadArticle(w, r)
+	if !ok {
+		return
+	}
+	if err := a.Trash(); err != nil {
+		apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyAPIArticle copies the fields sent to the JSON API, other than the slug,
+// into a, responding with an error and reporting false if one can't be applied
+func applyAPIArticle(w http.ResponseWriter, r *http.Request, a *article.Article, in *apiArticleInput) bool {
+	if in.Title != nil {
+		a.Title = *in.Title
+	}
+	if in.Body != nil {
+		a.Body = *in.Body
+	}
+	if in.Author != nil {
+		a.Author = *in.Author
+	}
+	if in.PublishAt != nil {
+		a.PublishAt = *in.PublishAt
+	}
+	if in.Excerpt != nil {
+		a.Excerpt = *in.Excerpt
+	}
+	if in.Description != nil {
+		a.Description = *in.Description
+	}
+	if in.Tags != nil {
+		a.Tags = *in.Tags
+	}
+	if in.Visibility != nil {
+		a.Visibility = *in.Visibility
+	}
+	if in.NoIndex != nil {
+		a.NoIndex = *in.NoIndex
+	}
+	if in.NoFollow != nil {
+		a.NoFollow = *in.NoFollow
+	}
+	if in.Meta != nil {
+		a.Meta = *in.Meta
+	}
+	if in.Password != nil {
+		if err := a.SetPassword(*in.Password); err != nil {
+			apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+			return false
+		}
+	}
+	return true
+}
+
+// apiLoadArticle loads the article named by the request's slug, responding
+// with an error and reporting false if it can't be
+func apiLoadArticle(w http.ResponseWriter, r *http.Request) (*article.Article, bool) {
+	slug := mux.Vars(r)["slug"]
+	a, err := article.Load(slug)
+	if os.IsNotExist(err) {
+		apiErrorf(w, r, http.StatusNotFound, "there is no article %s", slug)
+		return nil, false
+	}
+	if err != nil {
+		apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+		return nil, false
+	}
+	return a, true
+}
+
+// respondArticles responds with the page of articles listed on the home page
+// as JSON, along with the numbers of the pages either side, 0 if there are none
+func respondArticles(w http.ResponseWriter, r *http.Request, articles []*article.Article, page, total int) {
+	res := struct {
+		Articles []*apiArticle
+		Page     int
+		PrevPage int `json:",omitempty"`
+		NextPage int `json:",omitempty"`
+		Total    int
+	}{Articles: []*apiArticle{}, Page: page, Total: total}
+	if page > 1 {
+		res.PrevPage = page - 1
+	}
+	if page*homePageSize < total {
+		res.NextPage = page + 1
+	}
+	for _, a := range articles {
+		item, err := newAPIArticle(r, a, false)
+		if err != nil {
+			apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		res.Articles = append(res.Articles, item)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// apiRespondArticle responds with a, rendered to HTML, and its ETag
+func apiRespondArticle(w http.ResponseWriter, r *http.Request, status int, a *article.Article) {
+	res, err := newAPIArticle(r, a, true)
+	if err != nil {
+		apiErrorf(w, r, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	w.Header().Set("ETag", `"`+res.Version+`"`)
+	writeJSON(w, status, res)
+}
+
+// apiRequireAdmin reports whether r carries the admin's credentials,
+// otherwise responding 401 Unauthorized and asking for them
+func apiRequireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if (&requestInfo{r}).IsAdmin() {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="gournal", charset="UTF-8"`)
+	apiErrorf(w, r, http.StatusUnauthorized, "the admin's username and password are required")
+	return false
+}
+
+// wantsJSON reports whether r asks for a page as JSON rather than HTML, with
+// a format=json parameter or an Accept header preferring application/json
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return acceptQuality(r, "application/json") > acceptQuality(r, "text/html")
+}
+
+// acceptQuality returns the quality, between 0 and 1, the Accept header of r
+// gives the media type, taken from the most specific range matching it
+func acceptQuality(r *http.Request, mediaType string) float64 {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return 1
+	}
+	best, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		rng := strings.ToLower(strings.TrimSpace(params[0]))
+		var s int
+		switch {
+		case rng == mediaType:
+			s = 2
+		case strings.HasSuffix(rng, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(rng, "*")):
+			s = 1
+		case rng == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			if v := strings.TrimSpace(p); strings.HasPrefix(v, "q=") {
+				if f, err := strconv.ParseFloat(v[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if s > specificity {
+			best, specificity = q, s
+		}
+	}
+	return best
+}
+
+// readJSON decodes the request's JSON body into v, responding 400 Bad Request
+// and reporting false if it isn't valid
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*article.MaxBodyLength))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		apiErrorf(w, r, http.StatusBadRequest, "invalid JSON: %v", err)
+		return false
+	}
+	return true
+}
+
+// apiErrorf responds with an apiError, logging server errors like renderError
+func apiErrorf(w http.ResponseWriter, r *http.Request, status int, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if status >= 500 {
+		logger(r).Error("serving an API request failed", "method", r.Method, "path", r.URL.Path, "status", status, "error", msg)
+	}
+	writeJSON(w, status, apiError{Status: status, Message: msg, RequestID: requestID(r)})
+}
+
+// apiValidationError responds 422 Unprocessable Entity with the problems which
+// stopped an article being saved
+func apiValidationError(w http.ResponseWriter, r *http.Request, errs article.ValidationErrors) {
+	writeJSON(w, http.StatusUnprocessableEntity, apiError{
+		Status:    http.StatusUnprocessableEntity,
+		Message:   "the article isn't valid",
+		Fields:    errs,
+		RequestID: requestID(r),
+	})
+}
+
+// writeJSON responds with status and v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
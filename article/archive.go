@@ -0,0 +1,92 @@
+package article
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A Year groups the Months of an Archive falling in it, latest first.
+type Year struct {
+	Year   int
+	Months []*Month
+}
+
+// A Month groups the Entries of an Archive dated in it, latest first.
+type Month struct {
+	Year    int
+	Month   time.Month
+	Entries []*Entry
+}
+
+// Archive returns an Entry for every listed Article in the DefaultStore,
+// grouped by the year and month of its Date, latest first, returning the error
+// if one occurs. Like List, it doesn't load every Article if the DefaultStore
+// is an Indexer.
+func Archive() (res []*Year, err error) {
+	index, err := List()
+	if err != nil {
+		return nil, err
+	}
+	var listed []*Entry
+	for _, e := range index {
+		if e.Listed() {
+			listed = append(listed, e)
+		}
+	}
+	sort.SliceStable(listed, func(i, j int) bool {
+		return listed[i].Date().After(listed[j].Date())
+	})
+
+	var y *Year
+	var m *Month
+	for _, e := range listed {
+		year, month, _ := e.Date().Date()
+		if y == nil || y.Year != year {
+			y = &Year{Year: year}
+			res = append(res, y)
+			m = nil
+		}
+		if m == nil || m.Month != month {
+			m = &Month{Year: year, Month: month}
+			y.Months = append(y.Months, m)
+		}
+		m.Entries = append(m.Entries, e)
+	}
+	return res, nil
+}
+
+// Len returns how many Entries the Year holds
+func (y *Year) Len() (n int) {
+	for _, m := range y.Months {
+		n += len(m.Entries)
+	}
+	return
+}
+
+// Path returns the path the Year's archive is served at, e.g. /2024
+func (y *Year) Path() string {
+	return fmt.Sprintf("/%04d", y.Year)
+}
+
+// Path returns the path the Month's archive is served at, e.g. /2024/06
+func (m *Month) Path() string {
+	return fmt.Sprintf("/%04d/%02d", m.Year, m.Month)
+}
+
+// Date returns when the Article was, or is to be, published: its PublishAt
+// time or, if it was published as soon as it was saved, when it was created
+func (a *Article) Date() time.Time {
+	if !a.PublishAt.IsZero() {
+		return a.PublishAt
+	}
+	return a.Created()
+}
+
+// Date returns when the Article the Entry lists was, or is to be, published
+func (e *Entry) Date() time.Time {
+	if !e.PublishAt.IsZero() {
+		return e.PublishAt
+	}
+	return e.Created()
+}
@@ -2,63 +2,253 @@
 package article
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"html/template"
+	"net/url"
 	"os"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/firegoby/gournal/truncate"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/parser"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
 )
 
-// An Article contains a title, body and slug (used as a permalink).
+// An Article contains a title, body, author and slug (used as a permalink),
+// plus an optional excerpt, meta description and tags. Its ID never changes, so
+// unlike the slug it can be used to refer to the Article across renames. An
+// Article with a PublishAt time in the future is scheduled, and hidden from
+// listings until that time passes. An Unlisted Article is published but only
+// reachable by its URL. NoIndex and NoFollow ask search engines not to index
+// the Article or follow its links. An Article with a PasswordHash is only shown
+// to visitors who know its password. Meta holds any custom data, such as a
+// cover image URL or series name, for templates to use. HeadHTML and FooterHTML
+// are injected into the Article's page, subject to SnippetPolicy. Change
+// records the edit which produced this version of the Article, if it was
+// recorded.
 type Article struct {
-	Title string
-	Body  string
-	Slug  string
+	ID           string `json:",omitempty"`
+	Title        string
+	Body         string
+	Slug         string
+	Author       Author
+	PublishAt    time.Time
+	Excerpt      string            `json:",omitempty"`
+	Description  string            `json:",omitempty"`
+	Tags         []string          `json:",omitempty"`
+	Visibility   Visibility        `json:",omitempty"`
+	NoIndex      bool              `json:",omitempty"`
+	NoFollow     bool              `json:",omitempty"`
+	Meta         map[string]string `json:",omitempty"`
+	HeadHTML     string            `json:",omitempty"`
+	FooterHTML   string            `json:",omitempty"`
+	PasswordHash []byte            `json:",omitempty"`
+	Change       *Change           `json:",omitempty"`
+
+	// set by New until the Article is first saved, customSlug records whether
+	// the slug was chosen rather than derived from the title
+	isNew      bool
+	customSlug bool
+
+	// the Format the Article was loaded from and will be saved in
+	format Format
+
+	// whether the Article was loaded from a bundle, see bundleIndex
+	bundle bool
+
+	// when the Article was last saved, or published if it was scheduled
+	updated time.Time
+}
+
+// An Author identifies who wrote an Article. Email, URL and Bio, a sentence or
+// two about them which themes may show alongside the Article, are optional.
+type Author struct {
+	Name  string
+	Email string `json:",omitempty"`
+	URL   string `json:",omitempty"`
+	Bio   string `json:",omitempty"`
+}
+
+// Visibility controls where a published Article appears
+type Visibility string
+
+const (
+	// Public Articles appear in every listing, the default
+	Public Visibility = "public"
+	// Unlisted Articles are reachable by URL but left out of listings, for
+	// sharing with people who are sent the link
+	Unlisted Visibility = "unlisted"
+)
+
+// Visibilities lists every Visibility an Article may have
+var Visibilities = []Visibility{Public, Unlisted}
+
+// Dir is the location on disk to store Articles, in any of the Formats, and
+// the site's data files. It ends in a slash.
+var Dir = "./articles/"
+
+// the number of words of the Body used as the summary of an Article which has
+// no Excerpt
+const ExcerptWords = 40
+
+// the most characters of a meta description search engines show, to which
+// MetaDescription cuts summaries
+const DescriptionLength = 160
+
+// the average reading speed, in words per minute, used by ReadingTime
+const WordsPerMinute = 200
+
+// the layout of a Revision ID
+const revisionLayout = "20060102T150405.000000000Z"
+
+// A Revision identifies a prior version of an Article, saved when the Article
+// was overwritten at Time. Change records the edit which produced it, once
+// filled in by Revisions.
+type Revision struct {
+	ID     string
+	Time   time.Time
+	Change *Change
 }
 
-// the location on disk to store Articles in JSON representation
-const Dir = "./articles/"
+// ErrInvalidSlug is returned when a custom slug isn't URL-safe
+var ErrInvalidSlug = errors.New("article: slugs may only contain lowercase letters, digits and single hyphens")
+
+// ErrSlugExists is returned when saving a new Article, or renaming one, would
+// overwrite another Article with the same slug
+var ErrSlugExists = errors.New("article: an article with that slug already exists")
+
+// validSlug matches URL-safe slugs such as "hello-2024"
+var validSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// datedSlug matches slugs scoped to a month, such as "2024-05-weeknotes"
+var datedSlug = regexp.MustCompile(`^([0-9]{4})-([0-9]{2})-(.+)$`)
+
+// DatedSlugs scopes the slugs derived from titles to the month an Article is
+// published in, e.g. "2024-05-weeknotes", so regular posts sharing a title
+// don't need numeric suffixes, and gives Articles date-based permalinks such
+// as /2024/05/weeknotes
+var DatedSlugs bool
+
+// Renderer converts the Markdown Bodies and Excerpts of Articles to HTML. It
+// may be extended before any are rendered, e.g. with images.Library.Extend.
+var Renderer = goldmark.New()
+
+// Sanitizer cleans the HTML Renderer makes of Articles, as well as goldmark
+// leaving out raw HTML, so script can't reach a page even through an
+// extension. It allows what Markdown and images.Library produce, and may be
+// extended to allow the markup of other extensions.
+var Sanitizer = newSanitizer()
 
-// byLatestDate implements the sort.Interface
-type byLatestDate []os.FileInfo
+// newSanitizer returns the default Sanitizer, bluemonday's policy for user
+// generated content plus the attributes of responsive images and code blocks'
+// languages, leaving links as they were written
+func newSanitizer() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("srcset", "sizes", "loading").OnElements("img")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	p.RequireNoFollowOnLinks(false)
+	return p
+}
+
+// bySoonestPublish implements the sort.Interface
+type bySoonestPublish []*Article
 
-func (f byLatestDate) Len() int           { return len(f) }
-func (f byLatestDate) Less(i, j int) bool { return f[i].ModTime().After(f[j].ModTime()) }
-func (f byLatestDate) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (a bySoonestPublish) Len() int           { return len(a) }
+func (a bySoonestPublish) Less(i, j int) bool { return a[i].PublishAt.Before(a[j].PublishAt) }
+func (a bySoonestPublish) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
 // Article Creation/Aquisition Functions ======================================
 
-// New returns a new Article with Title and Body
-func New(title string, body string) *Article {
-	return &Article{Title: title, Body: body, Slug: Slugify(title)}
+// New returns a new Article with Title and Body, using slug as its permalink
+// or, if slug is empty, one derived from the title. It returns ErrInvalidSlug
+// if a custom slug isn't URL-safe.
+func New(title string, body string, slug string) (*Article, error) {
+	if slug == "" {
+		return &Article{Title: title, Body: body, Slug: Slugify(title), isNew: true, format: DefaultFormat}, nil
+	}
+	if !ValidSlug(slug) {
+		return nil, ErrInvalidSlug
+	}
+	return &Article{Title: title, Body: body, Slug: slug, isNew: true, customSlug: true, format: DefaultFormat}, nil
 }
 
-// Load attempts to load an Article from Dir identified by slug, returning the
-// error if one occurs
+// Load attempts to load an Article identified by slug from the DefaultStore,
+// returning the error if one occurs
 func Load(slug string) (a *Article, err error) {
-	b, err := ioutil.ReadFile(Dir + slug + ".json")
+	return DefaultStore.Load(slug)
+}
+
+// All returns a slice of all published Articles in the DefaultStore, sorted by
+// latest date, returning the error if one occurs
+func All() (res []*Article, err error) {
+	articles, err := DefaultStore.List()
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(b, &a)
+	for _, a := range articles {
+		if a.Published() {
+			res = append(res, a)
+		}
+	}
+	return
+}
+
+// Listed returns a slice of all published Articles in the DefaultStore which appear
+// in listings, i.e. aren't Unlisted, sorted by latest date, returning the error
+// if one occurs
+func Listed() (res []*Article, err error) {
+	articles, err := All()
 	if err != nil {
 		return nil, err
 	}
-	return a, nil
+	for _, a := range articles {
+		if a.Listed() {
+			res = append(res, a)
+		}
+	}
+	return
 }
 
-// All returns a slice of all Articles located in Dir, sorted by latest date,
-// returning the error if one occurs
-func All() (res []*Article, err error) {
-	files, err := ioutil.ReadDir(Dir)
+// Scheduled returns a slice of all Articles in the DefaultStore which are not
+// yet published, soonest first, returning the error if one occurs
+func Scheduled() (res []*Article, err error) {
+	articles, err := DefaultStore.List()
 	if err != nil {
-		return
+		return nil, err
+	}
+	for _, a := range articles {
+		if !a.Published() {
+			res = append(res, a)
+		}
 	}
-	sort.Sort(byLatestDate(files))
-	for _, f := range files {
-		a, err := Load(f.Name()[:len(f.Name())-len(".json")])
+	sort.Sort(bySoonestPublish(res))
+	return
+}
+
+// PublishDue marks every scheduled Article whose PublishAt time has passed as
+// freshly published, by moving its updated time to PublishAt so it sorts
+// amongst the latest Articles. It returns the Articles which went live.
+func PublishDue() (res []*Article, err error) {
+	articles, err := DefaultStore.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range articles {
+		if !a.Published() || !a.updated.Before(a.PublishAt) {
+			continue
+		}
+		err = DefaultStore.Touch(a.Slug, a.PublishAt)
 		if err != nil {
 			return nil, err
 		}
@@ -67,15 +257,366 @@ func All() (res []*Article, err error) {
 	return
 }
 
+// Search returns a slice of all listed Articles whose title, body or tags
+// contain query, ignoring case, sorted by latest date, returning the error if
+// one occurs. If the DefaultStore is fronted by a BleveStore they instead
+// contain any word of query, most relevant first.
+func Search(query string) (res []*Article, err error) {
+	articles, err := DefaultStore.Search(query)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range articles {
+		if a.Listed() {
+			res = append(res, a)
+		}
+	}
+	return
+}
+
+// Trashed returns a slice of all Articles in the trash, most recently trashed
+// first, returning the error if one occurs
+func Trashed() (res []*Article, err error) {
+	return DefaultStore.Trashed()
+}
+
+// Restore moves the Article identified by slug out of the trash, refusing to
+// overwrite an Article which has since been saved with the same slug
+func Restore(slug string) error {
+	return DefaultStore.Restore(slug)
+}
+
+// Redirect returns the current slug of an Article which used to be found at
+// slug before being renamed, reporting false if there is no such Article
+func Redirect(slug string) (string, bool) {
+	return DefaultStore.Redirect(slug)
+}
+
+// UniqueSlug returns base, or base with the smallest numeric suffix (-2, -3,
+// ...) which no stored Article is using
+func UniqueSlug(base string) string {
+	slug := base
+	for n := 2; stored(slug); n++ {
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	return slug
+}
+
+// ByAuthor returns all listed Articles written by the author name, sorted by
+// latest date, returning the error if one occurs
+func ByAuthor(name string) (res []*Article, err error) {
+	return Query().Author(name).Articles()
+}
+
+// ByTag returns all listed Articles tagged tag, ignoring case, sorted by
+// latest date, returning the error if one occurs
+func ByTag(tag string) (res []*Article, err error) {
+	return Query().Tag(tag).Articles()
+}
+
+// Revisions returns the prior versions of the Article identified by slug,
+// newest first, along with the Change which produced each, returning the error
+// if one occurs
+func Revisions(slug string) (res []Revision, err error) {
+	res, err = DefaultStore.Revisions(slug)
+	if err != nil {
+		return nil, err
+	}
+	for i, rev := range res {
+		a, err := DefaultStore.LoadRevision(slug, rev.ID)
+		if err != nil {
+			return nil, err
+		}
+		res[i].Change = a.Change
+	}
+	return res, nil
+}
+
+// LoadRevision attempts to load the prior version id of the Article identified
+// by slug, returning the error if one occurs
+func LoadRevision(slug, id string) (a *Article, err error) {
+	if _, err = time.Parse(revisionLayout, id); err != nil {
+		return nil, fmt.Errorf("article: invalid revision %q", id)
+	}
+	return DefaultStore.LoadRevision(slug, id)
+}
+
 // Article Methods ============================================================
 
-// Save stores a JSON representation of an Article in the Dir directory
+// Save stores an Article in the DefaultStore, which keeps any previously
+// saved version as a Revision. A FileStore saves it in the Format it was
+// loaded from, or DefaultFormat if it is new.
+//
+// An Article without an ID is given one, see NewID.
+//
+// A new Article never overwrites an existing one: if its slug was derived from
+// the title a numeric suffix (-2, -3, ...) is appended until it is unique, and
+// if the slug was chosen by hand ErrSlugExists is returned instead. Invalid
+// Articles are never saved, see Validate.
 func (a *Article) Save() error {
-	b, err := json.Marshal(a)
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	if a.ID == "" {
+		a.ID = NewID(time.Now())
+	}
+	if a.isNew {
+		return a.create()
+	}
+	return DefaultStore.Save(a)
+}
+
+// create stores a new Article, de-duplicating its slug as described by Save
+func (a *Article) create() error {
+	base := a.Slug
+	for n := 2; ; n++ {
+		err := DefaultStore.Create(a)
+		if err == ErrSlugExists && !a.customSlug {
+			a.Slug = fmt.Sprintf("%s-%d", base, n)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		a.isNew = false
+		return nil
+	}
+}
+
+// Published reports whether an Article is visible to readers, i.e. it has no
+// PublishAt time or that time has passed
+func (a *Article) Published() bool {
+	return !a.PublishAt.After(time.Now())
+}
+
+// Listed reports whether a published Article appears in listings, i.e. it
+// isn't Unlisted
+func (a *Article) Listed() bool {
+	return a.Published() && a.Visibility != Unlisted
+}
+
+// Robots returns the content of the robots meta tag for the Article, e.g.
+// "noindex, nofollow", or an empty string if search engines may index it
+func (a *Article) Robots() string {
+	var directives []string
+	if a.NoIndex {
+		directives = append(directives, "noindex")
+	}
+	if a.NoFollow {
+		directives = append(directives, "nofollow")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Protected reports whether the Article requires a password to be read
+func (a *Article) Protected() bool {
+	return len(a.PasswordHash) > 0
+}
+
+// SetPassword protects the Article with a bcrypt hash of password, or removes
+// the protection if password is empty
+func (a *Article) SetPassword(password string) (err error) {
+	if password == "" {
+		a.PasswordHash = nil
+		return nil
+	}
+	a.PasswordHash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return
+}
+
+// CheckPassword reports whether password unlocks the Article
+func (a *Article) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword(a.PasswordHash, []byte(password)) == nil
+}
+
+// HTML renders the Article's Markdown Body as HTML, after expanding any
+// include shortcodes, see ExpandIncludes. Raw HTML within the Markdown is
+// omitted, and the result cleaned by Sanitizer.
+func (a *Article) HTML() (template.HTML, error) {
+	body, _ := a.ExpandIncludes()
+	return markdown(body, a.bundleBase())
+}
+
+// bundleBase returns the URL the relative links of the Article are resolved
+// against, or nil unless it was loaded from a bundle
+func (a *Article) bundleBase() *url.URL {
+	if !a.bundle {
+		return nil
+	}
+	return bundleURL(a.Slug)
+}
+
+// markdown renders the Markdown s as HTML cleaned by Sanitizer, resolving its
+// relative links against base, if not nil
+func markdown(s string, base *url.URL) (template.HTML, error) {
+	var buf bytes.Buffer
+	pc := parser.NewContext()
+	if base != nil {
+		pc.Set(bundleBase, base)
+	}
+	if err := Renderer.Convert([]byte(s), &buf, parser.WithContext(pc)); err != nil {
+		return "", err
+	}
+	return template.HTML(Sanitizer.SanitizeBytes(buf.Bytes())), nil
+}
+
+// Summary returns the Article's Excerpt or, when that was left empty, the first
+// ExcerptWords words of its Body, less any include shortcodes, followed by an
+// ellipsis
+func (a *Article) Summary() string {
+	if strings.TrimSpace(a.Excerpt) != "" {
+		return a.Excerpt
+	}
+	words := strings.Fields(includeShortcode.ReplaceAllString(a.Body, ""))
+	if len(words) <= ExcerptWords {
+		return strings.Join(words, " ")
+	}
+	return strings.Join(words[:ExcerptWords], " ") + "…"
+}
+
+// SummaryHTML renders the Article's Excerpt or, when that was left empty, its
+// Body cut short after ExcerptWords words without breaking its tags, see
+// truncate.HTML
+func (a *Article) SummaryHTML() (template.HTML, error) {
+	if strings.TrimSpace(a.Excerpt) != "" {
+		return markdown(a.Excerpt, a.bundleBase())
+	}
+	body, err := a.HTML()
+	if err != nil {
+		return "", err
+	}
+	summary, _ := truncate.HTML(string(body), ExcerptWords)
+	return template.HTML(summary), nil
+}
+
+// MetaDescription returns the Article's Description or, when that was left
+// empty, the text of its summary cut to DescriptionLength characters
+func (a *Article) MetaDescription() string {
+	if d := strings.TrimSpace(a.Description); d != "" {
+		return d
+	}
+	summary, err := a.SummaryHTML()
+	if err != nil {
+		return ""
+	}
+	return truncate.Text(string(summary), DescriptionLength)
+}
+
+// WordCount returns the number of words in the Article's Body
+func (a *Article) WordCount() int {
+	return len(strings.Fields(a.Body))
+}
+
+// ReadingTime returns the whole number of minutes, at least one, an average
+// reader takes to read the Article's Body
+func (a *Article) ReadingTime() int {
+	minutes := (a.WordCount() + WordsPerMinute - 1) / WordsPerMinute
+	if minutes < 1 {
+		return 1
+	}
+	return minutes
+}
+
+// Rename changes an Article's slug, moving its stored representation and any
+// revisions to the new permalink, and recording a redirect from the old one
+func (a *Article) Rename(slug string) error {
+	if !ValidSlug(slug) {
+		return ErrInvalidSlug
+	}
+	if slug == a.Slug {
+		return nil
+	}
+	err := DefaultStore.Rename(a.Slug, slug)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(Dir+a.Slug+".json", b, 0600)
+	a.Slug = slug
+	return nil
+}
+
+// SlugDerived reports whether the Article's slug was generated from its title,
+// possibly with a numeric suffix, rather than chosen by hand
+func (a *Article) SlugDerived() bool {
+	for _, base := range []string{Slugify(a.Title), a.DerivedSlug()} {
+		if a.Slug == base {
+			return true
+		}
+		suffix := strings.TrimPrefix(a.Slug, base+"-")
+		if suffix == a.Slug || suffix == "" || strings.Trim(suffix, "0123456789") != "" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// DerivedSlug returns the slug generated from the Article's title. When
+// DatedSlugs is set it is scoped to the month the Article already has in its
+// slug or, failing that, the month it is published in.
+func (a *Article) DerivedSlug() string {
+	slug := Slugify(a.Title)
+	if !DatedSlugs {
+		return slug
+	}
+	if m := datedSlug.FindStringSubmatch(a.Slug); m != nil {
+		return m[1] + "-" + m[2] + "-" + slug
+	}
+	t := a.PublishAt
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.Format("2006-01-") + slug
+}
+
+// Permalink returns the path the Article is read at
+func (a *Article) Permalink() string {
+	return Permalink(a.Slug)
+}
+
+// Trash moves an Article into the trash, from where it can be brought back
+// with Restore
+func (a *Article) Trash() error {
+	return DefaultStore.Delete(a.Slug)
+}
+
+// Updated returns when the Article was last saved, or published if it was
+// scheduled
+func (a *Article) Updated() time.Time {
+	return a.updated
+}
+
+// Version identifies the saved content of the Article, changing whenever it
+// is edited, so a change made to an out of date copy can be detected
+func (a *Article) Version() string {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return ""
+	}
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// matches reports whether the Article's title, body or tags contain query,
+// ignoring case
+func (a *Article) matches(query string) bool {
+	query = strings.ToLower(query)
+	if strings.Contains(strings.ToLower(a.Title), query) || strings.Contains(strings.ToLower(a.Body), query) {
+		return true
+	}
+	for _, t := range a.Tags {
+		if strings.Contains(strings.ToLower(t), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileFormat returns the Format the Article is stored in
+func (a *Article) fileFormat() Format {
+	if a.format == "" {
+		return DefaultFormat
+	}
+	return a.format
 }
 
 // String returns a simple single line representation of an Article,
@@ -84,14 +625,73 @@ func (a *Article) String() string {
 	return fmt.Sprintf("%s (%s)", a.Title, a.Slug)
 }
 
+// String returns the Author's name, implementing the fmt.Stringer interface
+func (au Author) String() string {
+	return au.Name
+}
+
 // Utilities ==================================================================
 
-// Slugify converts a title string into a url-friendly slug string
+// stored reports whether the DefaultStore holds an Article identified by slug
+func stored(slug string) bool {
+	_, err := DefaultStore.Load(slug)
+	return !os.IsNotExist(err)
+}
+
+// Permalink returns the path the Article identified by slug is read at, e.g.
+// /articles/hello-world, or /2024/05/weeknotes for a slug scoped to a month
+// when DatedSlugs is set
+func Permalink(slug string) string {
+	if m := datedSlug.FindStringSubmatch(slug); DatedSlugs && m != nil {
+		return "/" + m[1] + "/" + m[2] + "/" + m[3]
+	}
+	return "/articles/" + slug
+}
+
+// ValidSlug reports whether slug is safe to use as a permalink
+func ValidSlug(slug string) bool {
+	return validSlug.MatchString(slug)
+}
+
+// Slugify converts a title string into a url-friendly slug string. Accented
+// Latin letters are transliterated to ASCII, and if nothing usable remains
+// (e.g. a title written entirely in Japanese) a short hash of the title is
+// used instead so the permalink still works.
 func Slugify(title string) (slug string) {
-	slug = strings.ToLower(title)
+	slug = transliterate(strings.ToLower(title))
 	slug = regexp.MustCompile("[^a-z0-9 -]").ReplaceAllString(slug, "")
 	slug = regexp.MustCompile(" +").ReplaceAllString(slug, "-")
 	slug = regexp.MustCompile("-+").ReplaceAllString(slug, "-")
 	slug = strings.Trim(slug, " -")
+	if slug == "" && strings.TrimSpace(title) != "" {
+		sum := sha1.Sum([]byte(title))
+		slug = hex.EncodeToString(sum[:4])
+	}
 	return
 }
+
+// transliterations covers Latin letters which don't decompose into an ASCII
+// letter plus combining marks
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d",
+	'þ': "th", 'ı': "i", 'ħ': "h", 'ŋ': "ng",
+}
+
+// transliterate folds s towards ASCII: letters are decomposed and stripped of
+// their accents, and dashes and spaces of any script become plain spaces
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// drop combining accents left behind by the decomposition
+		case unicode.IsSpace(r) || unicode.Is(unicode.Pd, r):
+			b.WriteRune(' ')
+		case transliterations[r] != "":
+			b.WriteString(transliterations[r])
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,280 @@
+package article
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// backupVersion identifies the layout of the archives Backup writes
+const backupVersion = 1
+
+// ErrNotBackup is returned by RestoreBackup when given something other than
+// an archive written by Backup
+var ErrNotBackup = errors.New("article: not a gournal backup")
+
+// manifest describes a backup, leading its archive
+type manifest struct {
+	Version int
+	Created time.Time
+}
+
+// Backup writes a gzipped tar archive of every Article in the DefaultStore,
+// scheduled or not, each in its Format under articles/ and dated when it was
+// last updated, along with the site's data files kept in Dir, such as its
+// glossary and, for a FileStore, its redirects, under data/, and the files in
+// its bundles under bundles/. Revisions and the trash aren't included.
+func Backup(w io.Writer) error {
+	data, err := dataFiles()
+	if err != nil {
+		return err
+	}
+	assets, err := bundleAssets()
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	m, err := json.Marshal(manifest{Version: backupVersion, Created: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, "manifest.json", m, time.Now()); err != nil {
+		return err
+	}
+	// ahead of the Articles, so theirs are restored into their bundles
+	for _, fi := range assets {
+		b, err := ioutil.ReadFile(Dir + fi.Name())
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, "bundles/"+fi.Name(), b, fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	err = Each(func(a *Article) error {
+		b, err := encode(a, a.fileFormat())
+		if err != nil {
+			return err
+		}
+		return writeEntry(tw, "articles/"+a.Slug+a.fileFormat().Ext(), b, a.updated)
+	})
+	if err != nil {
+		return err
+	}
+	for _, fi := range data {
+		b, err := ioutil.ReadFile(Dir + fi.Name())
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, "data/"+fi.Name(), b, fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// A RestoreReport counts the Articles in a backup by what restoring it did
+// with them: created them, saved them over stored Articles which differed, or
+// skipped them as they were already stored just as they are in the backup
+type RestoreReport struct {
+	Created int
+	Updated int
+	Skipped int
+}
+
+// Restored returns the number of Articles in the backup
+func (r RestoreReport) Restored() int {
+	return r.Created + r.Updated + r.Skipped
+}
+
+// RestoreBackup loads an archive written by Backup, creating the Articles it
+// holds in the DefaultStore, or saving over those already stored, identified
+// by their IDs or else their slugs, which keeps their current versions as
+// Revisions, and replacing the site's data files, so a site can be moved to
+// another machine or Store. Articles already stored as they are in the backup
+// are left alone, so restoring the same backup again changes nothing. The data
+// files are only read when gournal starts. It reports what it did with the
+// Articles restored, even if it fails part way.
+func RestoreBackup(r io.Reader) (rep RestoreReport, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return rep, ErrNotBackup
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "manifest.json" {
+		return rep, ErrNotBackup
+	}
+	var m manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil || m.Version == 0 {
+		return rep, ErrNotBackup
+	}
+	if m.Version > backupVersion {
+		return rep, fmt.Errorf("article: the backup is from a newer version of gournal (%d)", m.Version)
+	}
+
+	// the slugs of the stored Articles by ID, to find those renamed since the
+	// backup was made
+	stored, err := All()
+	if err != nil {
+		return rep, err
+	}
+	slugs := map[string]string{}
+	for _, a := range stored {
+		if a.ID != "" {
+			slugs[a.ID] = a.Slug
+		}
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return rep, nil
+		}
+		if err != nil {
+			return rep, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		dir, name := path.Split(hdr.Name)
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return rep, err
+		}
+		switch {
+		case dir == "articles/":
+			if err := restoreArticle(name, b, hdr.ModTime, slugs, &rep); err != nil {
+				return rep, err
+			}
+		case strings.HasPrefix(dir, "bundles/"):
+			slug := strings.TrimSuffix(strings.TrimPrefix(dir, "bundles/"), "/")
+			if err := restoreAsset(slug, name, b); err != nil {
+				return rep, err
+			}
+		case dir == "data/":
+			if !isDataFile(name) {
+				return rep, fmt.Errorf("article: the backup holds an unexpected data file %q", name)
+			}
+			if err := writeFile(Dir+name, b); err != nil {
+				return rep, err
+			}
+		}
+	}
+}
+
+// restoreArticle stores the Article backed up as the file name, holding b,
+// and dates it updated, counting it in rep. It is saved over the stored
+// Article with its ID, found in slugs, keeping that Article's slug, or else the
+// one with its slug, unless that is the same as it.
+func restoreArticle(name string, b []byte, updated time.Time, slugs map[string]string, rep *RestoreReport) error {
+	f, ok := formatOf(name)
+	slug := strings.TrimSuffix(name, path.Ext(name))
+	if !ok || !ValidSlug(slug) {
+		return fmt.Errorf("article: the backup holds an unexpected article %q", name)
+	}
+	a, err := decode(b, f)
+	if err != nil {
+		return fmt.Errorf("article: %s in the backup: %v", name, err)
+	}
+	a.Slug = slug
+	if s, ok := slugs[a.ID]; ok && a.ID != "" {
+		a.Slug = s
+	}
+
+	existing, err := DefaultStore.Load(a.Slug)
+	switch {
+	case err == nil:
+		// keep it in the Format it is stored in
+		a.format = existing.format
+		if sameArticle(a, existing) {
+			rep.Skipped++
+			// archives only date files to the second
+			if existing.updated.Truncate(time.Second).Equal(updated.Truncate(time.Second)) {
+				return nil
+			}
+			return DefaultStore.Touch(a.Slug, updated)
+		}
+		if err := DefaultStore.Save(a); err != nil {
+			return err
+		}
+		rep.Updated++
+	case os.IsNotExist(err):
+		a.format = f
+		if err := DefaultStore.Create(a); err != nil {
+			return err
+		}
+		if a.ID != "" {
+			slugs[a.ID] = a.Slug
+		}
+		rep.Created++
+	default:
+		return err
+	}
+	return DefaultStore.Touch(a.Slug, updated)
+}
+
+// sameArticle reports whether a and b would be stored the same, in a's Format
+func sameArticle(a, b *Article) bool {
+	ea, err := encode(a, a.fileFormat())
+	if err != nil {
+		return false
+	}
+	eb, err := encode(b, a.fileFormat())
+	return err == nil && bytes.Equal(ea, eb)
+}
+
+// dataFiles returns the site's data files kept in Dir
+func dataFiles() (res []os.FileInfo, err error) {
+	files, err := ioutil.ReadDir(Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), ".") && fi.Mode().IsRegular() && fi.Name() != ".index.json" && fi.Name() != coldFile && !strings.HasPrefix(fi.Name(), ".tmp-") {
+			res = append(res, fi)
+		}
+	}
+	return res, nil
+}
+
+// isDataFile reports whether the file name in Dir holds site data: it is
+// hidden, and neither the index, which is rebuilt when missing, nor the cold
+// storage archive, whose Articles are backed up with the rest, nor temporary
+func isDataFile(name string) bool {
+	return strings.HasPrefix(name, ".") && name != ".index.json" && name != coldFile && !strings.HasPrefix(name, ".tmp-")
+}
+
+// writeEntry adds a file called name, holding b and modified at modTime, to
+// tw
+func writeEntry(tw *tar.Writer, name string, b []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(b)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(b)
+	return err
+}
@@ -0,0 +1,207 @@
+package article
+
+import (
+	"os"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// how much more a match in an Article's title or tags counts than one in its
+// body when ranking search results
+const (
+	titleBoost = 3
+	tagsBoost  = 2
+)
+
+// A BleveStore is a Store keeping a Bleve full-text index of the title, body
+// and tags of the Articles in another Store, so Search returns its matches
+// ranked by relevance, with English words matched regardless of their endings.
+// The index is updated by every change made through the BleveStore; changes
+// made to the underlying Store by other means are only seen after Reindex.
+// Like a Cache, it shouldn't front a Store shared by several gournal instances.
+type BleveStore struct {
+	Store
+
+	index bleve.Index
+}
+
+// OpenBleve returns a BleveStore in front of s, keeping its index at path,
+// which is created and filled from s if it doesn't exist yet. The index is
+// also rebuilt if it doesn't hold as many Articles as s.
+func OpenBleve(path string, s Store) (*BleveStore, error) {
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		m := bleve.NewIndexMapping()
+		m.DefaultAnalyzer = en.AnalyzerName
+		index, err = bleve.New(path, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bs := &BleveStore{Store: s, index: index}
+
+	indexed, err := index.DocCount()
+	if err == nil {
+		var stored []*Entry
+		if stored, err = bs.Index(); err == nil && uint64(len(stored)) != indexed {
+			err = bs.Reindex()
+		}
+	}
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	return bs, nil
+}
+
+// Reindex rebuilds the index from scratch from the underlying Store
+func (s *BleveStore) Reindex() error {
+	// forget Articles no longer stored, as well as re-indexing those which are
+	n, err := s.index.DocCount()
+	if err != nil {
+		return err
+	}
+	all, err := s.index.Search(bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(n), 0, false))
+	if err != nil {
+		return err
+	}
+	batch := s.index.NewBatch()
+	for _, hit := range all.Hits {
+		batch.Delete(hit.ID)
+	}
+	err = each(s.Store, func(a *Article) error {
+		return batch.Index(a.Slug, searchDocument(a))
+	})
+	if err != nil {
+		return err
+	}
+	return s.index.Batch(batch)
+}
+
+// Close closes the index, and the underlying Store if it needs closing
+func (s *BleveStore) Close() error {
+	err := s.index.Close()
+	if c, ok := s.Store.(interface{ Close() error }); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Search implements Store, returning the Articles matching any word of query
+// in their title, body or tags, most relevant first
+func (s *BleveStore) Search(q string) (res []*Article, err error) {
+	n, err := s.index.DocCount()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	fields := []struct {
+		name  string
+		boost float64
+	}{{"title", titleBoost}, {"tags", tagsBoost}, {"body", 1}}
+	var matches []query.Query
+	for _, f := range fields {
+		m := bleve.NewMatchQuery(q)
+		m.SetField(f.name)
+		m.SetBoost(f.boost)
+		matches = append(matches, m)
+	}
+	found, err := s.index.Search(bleve.NewSearchRequestOptions(bleve.NewDisjunctionQuery(matches...), int(n), 0, false))
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range found.Hits {
+		a, err := s.Store.Load(hit.ID)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
+
+// Index implements Indexer, using the underlying Store's Index if it is an
+// Indexer, or else listing every Article
+func (s *BleveStore) Index() ([]*Entry, error) {
+	if ix, ok := s.Store.(Indexer); ok {
+		return ix.Index()
+	}
+	articles, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	return entries(articles), nil
+}
+
+// Each implements Iterator
+func (s *BleveStore) Each(fn func(*Article) error) error {
+	return each(s.Store, fn)
+}
+
+// Create implements Store
+func (s *BleveStore) Create(a *Article) error {
+	if err := s.Store.Create(a); err != nil {
+		return err
+	}
+	return s.index.Index(a.Slug, searchDocument(a))
+}
+
+// Save implements Store
+func (s *BleveStore) Save(a *Article) error {
+	if err := s.Store.Save(a); err != nil {
+		return err
+	}
+	return s.index.Index(a.Slug, searchDocument(a))
+}
+
+// Delete implements Store
+func (s *BleveStore) Delete(slug string) error {
+	if err := s.Store.Delete(slug); err != nil {
+		return err
+	}
+	return s.index.Delete(slug)
+}
+
+// Rename implements Store
+func (s *BleveStore) Rename(from, to string) error {
+	if err := s.Store.Rename(from, to); err != nil {
+		return err
+	}
+	if err := s.index.Delete(from); err != nil {
+		return err
+	}
+	return s.reindex(to)
+}
+
+// Restore implements Store
+func (s *BleveStore) Restore(slug string) error {
+	if err := s.Store.Restore(slug); err != nil {
+		return err
+	}
+	return s.reindex(slug)
+}
+
+// reindex indexes the stored Article identified by slug
+func (s *BleveStore) reindex(slug string) error {
+	a, err := s.Store.Load(slug)
+	if err != nil {
+		return err
+	}
+	// a FileStore leaves a renamed Article's old slug in it until it's saved
+	return s.index.Index(slug, searchDocument(a))
+}
+
+// searchDocument returns the fields of a which are indexed
+func searchDocument(a *Article) map[string]interface{} {
+	return map[string]interface{}{
+		"title": a.Title,
+		"body":  a.Body,
+		"tags":  a.Tags,
+	}
+}
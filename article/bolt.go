@@ -0,0 +1,472 @@
+package article
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A BoltStore keeps Articles in a single bbolt database file, for deployments
+// which want nothing but the gournal binary and its data. Articles are keyed
+// by slug, with a second bucket indexing them by updated time so listings
+// don't have to sort. Open one with OpenBolt.
+type BoltStore struct {
+	DB *bolt.DB
+}
+
+// the buckets of a BoltStore. Revisions holds a nested bucket per slug, keyed
+// by revision ID, and byUpdated is keyed by the big-endian updated time
+// followed by the slug.
+var (
+	boltArticles  = []byte("articles")
+	boltByUpdated = []byte("by_updated")
+	boltRevisions = []byte("revisions")
+	boltTrash     = []byte("trash")
+	boltRedirects = []byte("redirects")
+)
+
+// a boltRecord is the value stored for each Article and trashed Article
+type boltRecord struct {
+	Updated time.Time
+	Article json.RawMessage
+}
+
+// OpenBolt opens the bbolt database at path, creating it and its buckets if
+// they don't exist yet
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltArticles, boltByUpdated, boltRevisions, boltTrash, boltRedirects} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{DB: db}, nil
+}
+
+// Load implements Store
+func (s *BoltStore) Load(slug string) (a *Article, err error) {
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		a, err = boltGet(tx.Bucket(boltArticles), slug)
+		return err
+	})
+	return
+}
+
+// List implements Store
+func (s *BoltStore) List() (res []*Article, err error) {
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		articles := tx.Bucket(boltArticles)
+		c := tx.Bucket(boltByUpdated).Cursor()
+		for k, slug := c.Last(); k != nil; k, slug = c.Prev() {
+			a, err := boltGet(articles, string(slug))
+			if err != nil {
+				return err
+			}
+			res = append(res, a)
+		}
+		return nil
+	})
+	return
+}
+
+// Each implements Iterator, loading each Article in its own transaction once
+// their slugs are listed, so fn may change the database
+func (s *BoltStore) Each(fn func(*Article) error) error {
+	var slugs []string
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltByUpdated).Cursor()
+		for k, slug := c.Last(); k != nil; k, slug = c.Prev() {
+			slugs = append(slugs, string(slug))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return eachSlug(s, slugs, fn)
+}
+
+// Search implements Store
+func (s *BoltStore) Search(query string) (res []*Article, err error) {
+	articles, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range articles {
+		if a.matches(query) {
+			res = append(res, a)
+		}
+	}
+	return
+}
+
+// Create implements Store
+func (s *BoltStore) Create(a *Article) error {
+	now := time.Now()
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltArticles).Get([]byte(a.Slug)) != nil {
+			return ErrSlugExists
+		}
+		return boltPut(tx, a, now)
+	})
+	if err == nil {
+		a.updated = now
+	}
+	return err
+}
+
+// Save implements Store
+func (s *BoltStore) Save(a *Article) error {
+	now := time.Now()
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		if prev := tx.Bucket(boltArticles).Get([]byte(a.Slug)); prev != nil {
+			var rec boltRecord
+			if err := json.Unmarshal(prev, &rec); err != nil {
+				return err
+			}
+			revs, err := tx.Bucket(boltRevisions).CreateBucketIfNotExists([]byte(a.Slug))
+			if err != nil {
+				return err
+			}
+			err = revs.Put([]byte(now.UTC().Format(revisionLayout)), rec.Article)
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(boltByUpdated).Delete(boltIndexKey(rec.Updated, a.Slug))
+			if err != nil {
+				return err
+			}
+		}
+		return boltPut(tx, a, now)
+	})
+	if err == nil {
+		a.updated = now
+	}
+	return err
+}
+
+// Delete implements Store
+func (s *BoltStore) Delete(slug string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		a, err := boltGet(tx.Bucket(boltArticles), slug)
+		if err != nil {
+			return err
+		}
+		if err := boltRemove(tx, a); err != nil {
+			return err
+		}
+		return boltPutRecord(tx.Bucket(boltTrash), a, time.Now())
+	})
+}
+
+// Touch implements Store
+func (s *BoltStore) Touch(slug string, t time.Time) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		a, err := boltGet(tx.Bucket(boltArticles), slug)
+		if err != nil {
+			return err
+		}
+		if err := boltRemove(tx, a); err != nil {
+			return err
+		}
+		return boltPut(tx, a, t)
+	})
+}
+
+// Rename implements Store
+func (s *BoltStore) Rename(from, to string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		articles := tx.Bucket(boltArticles)
+		if articles.Get([]byte(to)) != nil {
+			return ErrSlugExists
+		}
+
+		a, err := boltGet(articles, from)
+		if err == nil {
+			if err := boltRemove(tx, a); err != nil {
+				return err
+			}
+			a.Slug = to
+			err = boltPut(tx, a, a.updated)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		revisions := tx.Bucket(boltRevisions)
+		if old := revisions.Bucket([]byte(from)); old != nil {
+			renamed, err := revisions.CreateBucketIfNotExists([]byte(to))
+			if err != nil {
+				return err
+			}
+			err = old.ForEach(func(id, data []byte) error { return renamed.Put(id, data) })
+			if err != nil {
+				return err
+			}
+			if err := revisions.DeleteBucket([]byte(from)); err != nil {
+				return err
+			}
+		}
+
+		redirects := tx.Bucket(boltRedirects)
+		var chained [][]byte
+		err = redirects.ForEach(func(old, current []byte) error {
+			if string(current) == from {
+				chained = append(chained, append([]byte(nil), old...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// lead older redirects straight to the new slug, not along a chain
+		for _, old := range append(chained, []byte(from)) {
+			if err := redirects.Put(old, []byte(to)); err != nil {
+				return err
+			}
+		}
+		// the new slug is a real Article again, so it must not redirect
+		return redirects.Delete([]byte(to))
+	})
+}
+
+// Redirect implements Store
+func (s *BoltStore) Redirect(slug string) (to string, ok bool) {
+	s.DB.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltRedirects).Get([]byte(slug)); v != nil {
+			to, ok = string(v), true
+		}
+		return nil
+	})
+	return
+}
+
+// Redirects implements Store
+func (s *BoltStore) Redirects() (map[string]string, error) {
+	redirects := map[string]string{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRedirects).ForEach(func(from, to []byte) error {
+			redirects[string(from)] = string(to)
+			return nil
+		})
+	})
+	return redirects, err
+}
+
+// Trashed implements Store
+func (s *BoltStore) Trashed() (res []*Article, err error) {
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		trash := tx.Bucket(boltTrash)
+		return trash.ForEach(func(slug, _ []byte) error {
+			a, err := boltGet(trash, string(slug))
+			if err != nil {
+				return err
+			}
+			res = append(res, a)
+			return nil
+		})
+	})
+	sort.SliceStable(res, func(i, j int) bool { return res[i].updated.After(res[j].updated) })
+	return
+}
+
+// Restore implements Store
+func (s *BoltStore) Restore(slug string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltArticles).Get([]byte(slug)) != nil {
+			return fmt.Errorf("article: cannot restore %q, an article with that slug already exists", slug)
+		}
+		trash := tx.Bucket(boltTrash)
+		a, err := boltGet(trash, slug)
+		if err != nil {
+			return err
+		}
+		if err := trash.Delete([]byte(slug)); err != nil {
+			return err
+		}
+		return boltPut(tx, a, a.updated)
+	})
+}
+
+// Revisions implements Store
+func (s *BoltStore) Revisions(slug string) (res []Revision, err error) {
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		revs := tx.Bucket(boltRevisions).Bucket([]byte(slug))
+		if revs == nil {
+			return nil
+		}
+		c := revs.Cursor()
+		for id, _ := c.Last(); id != nil; id, _ = c.Prev() {
+			t, err := time.Parse(revisionLayout, string(id))
+			if err != nil {
+				continue
+			}
+			res = append(res, Revision{ID: string(id), Time: t})
+		}
+		return nil
+	})
+	return
+}
+
+// LoadRevision implements Store
+func (s *BoltStore) LoadRevision(slug, id string) (a *Article, err error) {
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		var data []byte
+		if revs := tx.Bucket(boltRevisions).Bucket([]byte(slug)); revs != nil {
+			data = revs.Get([]byte(id))
+		}
+		if data == nil {
+			return notFound(slug + "/" + id)
+		}
+		a, err = decode(data, JSON)
+		return err
+	})
+	return
+}
+
+// Import implements Importer. It is used to migrate an existing site, e.g.
+// from a FileStore, and to bring it up to date again.
+func (s *BoltStore) Import(src Store) (*ImportReport, error) {
+	p, err := planImport(s, src)
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := src.Trashed()
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := src.Redirects()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.DB.Update(func(tx *bolt.Tx) error {
+		for _, it := range p.create {
+			if tx.Bucket(boltArticles).Get([]byte(it.Slug)) != nil {
+				continue
+			}
+			if err := boltPut(tx, it.Article, it.updated); err != nil {
+				return err
+			}
+		}
+		for _, it := range append(p.create, p.update...) {
+			revs, err := tx.Bucket(boltRevisions).CreateBucketIfNotExists([]byte(it.Slug))
+			if err != nil {
+				return err
+			}
+			err = importRevisions(src, it.from, func(id string, old *Article, _ time.Time) error {
+				if revs.Get([]byte(id)) != nil {
+					return nil
+				}
+				data, err := encode(old, JSON)
+				if err != nil {
+					return err
+				}
+				return revs.Put([]byte(id), data)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		trash := tx.Bucket(boltTrash)
+		for _, a := range trashed {
+			if trash.Get([]byte(a.Slug)) != nil {
+				continue
+			}
+			if err := boltPutRecord(trash, a, a.updated); err != nil {
+				return err
+			}
+		}
+		for from, to := range redirects {
+			if tx.Bucket(boltRedirects).Get([]byte(from)) != nil {
+				continue
+			}
+			if err := tx.Bucket(boltRedirects).Put([]byte(from), []byte(to)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.report(), p.saveUpdates(s)
+}
+
+// Close closes the database
+func (s *BoltStore) Close() error {
+	return s.DB.Close()
+}
+
+// boltGet decodes the Article stored under slug in the articles or trash
+// bucket b
+func boltGet(b *bolt.Bucket, slug string) (*Article, error) {
+	v := b.Get([]byte(slug))
+	if v == nil {
+		return nil, notFound(slug)
+	}
+	var rec boltRecord
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return nil, err
+	}
+	a, err := decode(rec.Article, JSON)
+	if err != nil {
+		return nil, err
+	}
+	a.updated = rec.Updated
+	return a, nil
+}
+
+// boltPut stores a in the articles bucket as updated at t, and indexes it
+func boltPut(tx *bolt.Tx, a *Article, t time.Time) error {
+	if err := boltPutRecord(tx.Bucket(boltArticles), a, t); err != nil {
+		return err
+	}
+	return tx.Bucket(boltByUpdated).Put(boltIndexKey(t, a.Slug), []byte(a.Slug))
+}
+
+// boltPutRecord stores a, updated at t, in the bucket b
+func boltPutRecord(b *bolt.Bucket, a *Article, t time.Time) error {
+	data, err := encode(a, JSON)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(boltRecord{Updated: t, Article: data})
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(a.Slug), v)
+}
+
+// boltRemove deletes the stored Article a, as loaded, and its index entry
+func boltRemove(tx *bolt.Tx, a *Article) error {
+	if err := tx.Bucket(boltByUpdated).Delete(boltIndexKey(a.updated, a.Slug)); err != nil {
+		return err
+	}
+	return tx.Bucket(boltArticles).Delete([]byte(a.Slug))
+}
+
+// boltIndexKey returns the key of the Article slug updated at t in the
+// byUpdated bucket, which sorts by time and then slug
+func boltIndexKey(t time.Time, slug string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint64(t.UnixNano()))
+	buf.WriteString(slug)
+	return buf.Bytes()
+}
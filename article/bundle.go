@@ -0,0 +1,200 @@
+package article
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// A FileStore also keeps Articles as bundles: a directory in Dir named for the
+// Article's slug, holding its file, named index with the extension of its
+// Format, alongside the images and other files it links to. Relative links in
+// the Article's Body and Excerpt are to those files, served under its URL, so
+// the Article and its assets are moved, trashed and restored together.
+const bundleIndex = "index"
+
+// bundleBase is the key under which markdown passes the URL the relative links
+// of a bundle's Article are resolved against to bundleLinks
+var bundleBase = parser.NewContextKey()
+
+func init() {
+	Renderer.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(bundleLinks{}, 600)))
+}
+
+// bundleLinks is a parser.ASTTransformer pointing the relative links and
+// images of a bundle's Article to the files alongside it
+type bundleLinks struct{}
+
+// Transform implements parser.ASTTransformer
+func (bundleLinks) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	base, ok := pc.Get(bundleBase).(*url.URL)
+	if !ok {
+		return
+	}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Image:
+			n.Destination = resolveBundleLink(base, n.Destination)
+		case *ast.Link:
+			n.Destination = resolveBundleLink(base, n.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// resolveBundleLink resolves dest against base if it is relative to the page,
+// rather than to the site or another host, or only a fragment
+func resolveBundleLink(base *url.URL, dest []byte) []byte {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return dest
+	}
+	return []byte(base.ResolveReference(u).String())
+}
+
+// bundleURL returns the URL the files in the bundle of the Article identified
+// by slug are served under
+func bundleURL(slug string) *url.URL {
+	return &url.URL{Path: "/articles/" + slug + "/"}
+}
+
+// BundleFile returns the path of the file name in the bundle of the Article
+// identified by slug, as kept by a FileStore in Dir, or false if there's no
+// such file. The Article's own file, and hidden files, aren't given out.
+func BundleFile(slug, name string) (string, bool) {
+	if !isAsset(slug, name) {
+		return "", false
+	}
+	path := Dir + slug + "/" + name
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", false
+	}
+	return path, true
+}
+
+// isAsset reports whether name may be the name of a file in the bundle of the
+// Article identified by slug alongside it
+func isAsset(slug, name string) bool {
+	if !ValidSlug(slug) || name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return false
+	}
+	_, ok := formatOf(name)
+	return !ok || strings.TrimSuffix(name, filepath.Ext(name)) != bundleIndex
+}
+
+// bundleAssets lists the files alongside the Articles in the bundles in Dir,
+// named for their bundle's directory and the file, e.g. "hello/cat.jpg"
+func bundleAssets() (res []os.FileInfo, err error) {
+	files, err := readDir(Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if _, ok := f.(bundleFile); !ok {
+			continue
+		}
+		slug := slugOf(f.Name())
+		assets, err := ioutil.ReadDir(Dir + slug)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range assets {
+			if fi.Mode().IsRegular() && isAsset(slug, fi.Name()) {
+				res = append(res, bundleFile{fi, slug + "/" + fi.Name()})
+			}
+		}
+	}
+	return res, nil
+}
+
+// restoreAsset writes b, backed up as the file name in the bundle of the
+// Article identified by slug, into the bundle
+func restoreAsset(slug, name string, b []byte) error {
+	if !isAsset(slug, name) {
+		return fmt.Errorf("article: the backup holds an unexpected file %q in the bundle %q", name, slug)
+	}
+	if err := os.MkdirAll(Dir+slug, 0700); err != nil {
+		return err
+	}
+	return writeFile(Dir+slug+"/"+name, b)
+}
+
+// readDir lists the files in dir like ioutil.ReadDir, except each bundle is
+// listed as its Article's file, named for the directory and file, e.g.
+// "hello/index.md", and dated when that file was last modified
+func readDir(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, fi := range files {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		for _, format := range Formats {
+			if index, err := os.Stat(dir + fi.Name() + "/" + bundleIndex + format.Ext()); err == nil {
+				files[i] = bundleFile{index, fi.Name() + "/" + index.Name()}
+				break
+			}
+		}
+	}
+	return files, nil
+}
+
+// a bundleFile is the file of a bundle's Article, as listed by readDir
+type bundleFile struct {
+	os.FileInfo
+	name string
+}
+
+func (f bundleFile) Name() string {
+	return f.name
+}
+
+// loadIn loads the Article in the file name within dir, as found by find or
+// listed by readDir. The slug of a bundle's Article is always that of its
+// directory.
+func loadIn(dir, name string) (*Article, error) {
+	a, err := loadFile(dir + name)
+	if err != nil {
+		return nil, err
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		a.Slug, a.bundle = name[:i], true
+	}
+	return a, nil
+}
+
+// slugOf returns the slug of the Article in the file name, as listed by
+// readDir, by its name
+func slugOf(name string) string {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// entryOf returns the file in dir holding the Article identified by slug, at
+// path as found by find, or the directory if it is a bundle, which is what's
+// moved when the Article is
+func entryOf(dir, slug, path string) string {
+	if strings.HasPrefix(path, dir+slug+"/") {
+		return dir + slug
+	}
+	return path
+}
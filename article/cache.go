@@ -0,0 +1,322 @@
+package article
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// A Cache is a Store keeping the Articles listed and loaded from another Store
+// in memory, so pages don't re-read and re-parse every Article on each
+// request. Every change made through the Cache flushes it, while changes made
+// to the underlying Store by other means, such as editing files by hand, are
+// only seen after Flush. Stores shared by several gournal instances shouldn't
+// be cached, as one instance can't flush another's Cache.
+type Cache struct {
+	Store
+	// Stale has List and Index, once flushed, go on returning what they last
+	// did, stale, while the Articles are listed again in the background, so a
+	// burst of requests after a change doesn't have each of them relist every
+	// Article. Articles loaded by slug are always fresh.
+	Stale bool
+	// Logger records failures to list the Articles again in the background,
+	// when Stale, or slog's default Logger if nil
+	Logger *slog.Logger
+
+	mu     sync.RWMutex
+	listed bool
+	list   []*Article
+	bySlug map[string]*Article
+	// the Entries listing the Articles, once indexed
+	indexed bool
+	index   []*Entry
+	// whether the list and index are out of date, and being brought up to
+	// date, when Stale
+	stale, revalidating bool
+	// incremented by Flush, so results loaded before a change aren't cached
+	// after it
+	gen int
+	// coalesces the concurrent misses of the same generation, so a burst of
+	// requests for what isn't cached loads it just the once
+	misses singleflight.Group
+}
+
+// NewCache returns a Cache in front of s
+func NewCache(s Store) *Cache {
+	return &Cache{Store: s, bySlug: map[string]*Article{}}
+}
+
+// Flush empties the Cache, so Articles are next loaded from the underlying
+// Store
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Stale {
+		c.stale = c.listed || c.indexed
+	} else {
+		c.listed, c.list = false, nil
+		c.indexed, c.index = false, nil
+	}
+	c.bySlug = map[string]*Article{}
+	c.gen++
+}
+
+// revalidate lists the Articles again in the background, unless that's
+// already under way, replacing the stale list and index once it's done. If it
+// fails, which is logged, or the Articles change meanwhile, they stay stale
+// until the next call.
+func (c *Cache) revalidate() {
+	c.mu.Lock()
+	if c.revalidating {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating = true
+	gen := c.gen
+	c.mu.Unlock()
+
+	go func() {
+		list, err := c.Store.List()
+		index := entries(list)
+		if ix, ok := c.Store.(Indexer); ok && err == nil {
+			index, err = ix.Index()
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.revalidating = false
+		if err != nil {
+			logTo(c.Logger).Error("listing stale articles again", "error", err)
+			return
+		}
+		if c.gen != gen {
+			return
+		}
+		c.listed, c.list = true, cloneAll(list)
+		c.indexed, c.index = true, copyEntries(index)
+		c.stale = false
+		for _, a := range c.list {
+			c.bySlug[a.Slug] = a
+		}
+	}()
+}
+
+// Load implements Store
+func (c *Cache) Load(slug string) (*Article, error) {
+	c.mu.RLock()
+	a, ok := c.bySlug[slug]
+	gen := c.gen
+	c.mu.RUnlock()
+	if ok {
+		return a.clone(), nil
+	}
+
+	v, err, _ := c.misses.Do(missKey("load", gen)+" "+slug, func() (interface{}, error) {
+		a, err := c.Store.Load(slug)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if c.gen == gen {
+			c.bySlug[slug] = a.clone()
+		}
+		c.mu.Unlock()
+		return a, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Article).clone(), nil
+}
+
+// List implements Store
+func (c *Cache) List() ([]*Article, error) {
+	c.mu.RLock()
+	listed, list, stale := c.listed, c.list, c.stale
+	gen := c.gen
+	c.mu.RUnlock()
+	if listed {
+		if stale {
+			c.revalidate()
+		}
+		return cloneAll(list), nil
+	}
+
+	v, err, _ := c.misses.Do(missKey("list", gen), func() (interface{}, error) {
+		list, err := c.Store.List()
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if c.gen == gen {
+			c.listed, c.list = true, cloneAll(list)
+			for _, a := range c.list {
+				c.bySlug[a.Slug] = a
+			}
+		}
+		c.mu.Unlock()
+		return list, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneAll(v.([]*Article)), nil
+}
+
+// Index implements Indexer, using the underlying Store's Index if it is an
+// Indexer, or else listing every Article
+func (c *Cache) Index() ([]*Entry, error) {
+	c.mu.RLock()
+	indexed, index, stale := c.indexed, c.index, c.stale
+	gen := c.gen
+	c.mu.RUnlock()
+	if indexed {
+		if stale {
+			c.revalidate()
+		}
+		return copyEntries(index), nil
+	}
+
+	v, err, _ := c.misses.Do(missKey("index", gen), func() (interface{}, error) {
+		var index []*Entry
+		if ix, ok := c.Store.(Indexer); ok {
+			var err error
+			if index, err = ix.Index(); err != nil {
+				return nil, err
+			}
+		} else {
+			articles, err := c.List()
+			if err != nil {
+				return nil, err
+			}
+			index = entries(articles)
+		}
+		c.mu.Lock()
+		if c.gen == gen {
+			c.indexed, c.index = true, copyEntries(index)
+		}
+		c.mu.Unlock()
+		return index, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyEntries(v.([]*Entry)), nil
+}
+
+// missKey returns the key coalescing the misses of kind in generation gen
+func missKey(kind string, gen int) string {
+	return kind + " " + strconv.Itoa(gen)
+}
+
+// Each implements Iterator, streaming the Articles from the underlying Store
+// without caching them, unless they are already cached and not stale
+func (c *Cache) Each(fn func(*Article) error) error {
+	c.mu.RLock()
+	listed, list, stale := c.listed, c.list, c.stale
+	c.mu.RUnlock()
+	if !listed || stale {
+		return each(c.Store, fn)
+	}
+	for _, a := range list {
+		if err := fn(a.clone()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create implements Store
+func (c *Cache) Create(a *Article) error {
+	defer c.Flush()
+	return c.Store.Create(a)
+}
+
+// Save implements Store
+func (c *Cache) Save(a *Article) error {
+	defer c.Flush()
+	return c.Store.Save(a)
+}
+
+// Delete implements Store
+func (c *Cache) Delete(slug string) error {
+	defer c.Flush()
+	return c.Store.Delete(slug)
+}
+
+// Touch implements Store
+func (c *Cache) Touch(slug string, t time.Time) error {
+	defer c.Flush()
+	return c.Store.Touch(slug, t)
+}
+
+// Rename implements Store
+func (c *Cache) Rename(from, to string) error {
+	defer c.Flush()
+	return c.Store.Rename(from, to)
+}
+
+// Restore implements Store
+func (c *Cache) Restore(slug string) error {
+	defer c.Flush()
+	return c.Store.Restore(slug)
+}
+
+// Close empties the Cache and closes the underlying Store, if it needs
+// closing, e.g. to write out a search index
+func (c *Cache) Close() error {
+	c.Flush()
+	if s, ok := c.Store.(interface{ Close() error }); ok {
+		return s.Close()
+	}
+	return nil
+}
+
+// Flush empties the Cache in front of the DefaultStore, if there is one, e.g.
+// after tests change the Articles on disk
+func Flush() {
+	if c, ok := DefaultStore.(*Cache); ok {
+		c.Flush()
+	}
+}
+
+// clone returns a copy of the Article which can be changed without changing
+// the original
+func (a *Article) clone() *Article {
+	c := *a
+	c.Tags = append([]string(nil), a.Tags...)
+	c.PasswordHash = append([]byte(nil), a.PasswordHash...)
+	if a.Change != nil {
+		change := *a.Change
+		change.Fields = append([]string(nil), a.Change.Fields...)
+		c.Change = &change
+	}
+	if a.Meta != nil {
+		c.Meta = make(map[string]string, len(a.Meta))
+		for k, v := range a.Meta {
+			c.Meta[k] = v
+		}
+	}
+	return &c
+}
+
+// cloneAll returns a copy of each of articles
+func cloneAll(articles []*Article) []*Article {
+	res := make([]*Article, len(articles))
+	for i, a := range articles {
+		res[i] = a.clone()
+	}
+	return res
+}
+
+// copyEntries returns a copy of each of index
+func copyEntries(index []*Entry) []*Entry {
+	res := make([]*Entry, len(index))
+	for i, e := range index {
+		c := *e
+		res[i] = &c
+	}
+	return res
+}
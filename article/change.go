@@ -0,0 +1,42 @@
+package article
+
+import (
+	"reflect"
+	"time"
+)
+
+// A Change records who made the edit producing a version of an Article, from
+// which IP address and when, along with the Fields it changed and an optional
+// Summary of it written by its author. By is empty if they weren't signed in.
+type Change struct {
+	By      string    `json:",omitempty" yaml:"by,omitempty" toml:"by"`
+	IP      string    `json:",omitempty" yaml:"ip,omitempty" toml:"ip"`
+	Time    time.Time `yaml:"time" toml:"time"`
+	Summary string    `json:",omitempty" yaml:"summary,omitempty" toml:"summary"`
+	Fields  []string  `json:",omitempty" yaml:"fields,omitempty" toml:"fields"`
+}
+
+// ChangedFields returns the names of the fields of an Article which differ
+// between before and after an edit, e.g. ["Title", "Body"], treating nil and
+// empty tags and meta alike
+func ChangedFields(before, after *Article) (res []string) {
+	b, a := reflect.ValueOf(before).Elem(), reflect.ValueOf(after).Elem()
+	t := b.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Name == "Change" {
+			continue
+		}
+		x, y := b.Field(i), a.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Slice, reflect.Map:
+			if x.Len() == 0 && y.Len() == 0 {
+				continue
+			}
+		}
+		if !reflect.DeepEqual(x.Interface(), y.Interface()) {
+			res = append(res, f.Name)
+		}
+	}
+	return
+}
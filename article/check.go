@@ -0,0 +1,133 @@
+package article
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The kinds of Problem Check finds
+const (
+	// Unparseable files can't be loaded as an Article at all
+	Unparseable = "unparseable"
+	// InvalidSlug files are named for a slug which isn't ValidSlug
+	InvalidSlug = "invalid-slug"
+	// SlugMismatch files hold an Article whose slug differs from their name
+	SlugMismatch = "slug-mismatch"
+	// DuplicateSlug files share their slug with another file, so which of
+	// them is served is down to chance
+	DuplicateSlug = "duplicate-slug"
+	// MissingField files hold an Article without a title or body
+	MissingField = "missing-field"
+)
+
+// A Problem is something wrong with a file in Dir, of one of the kinds above.
+type Problem struct {
+	File    string `json:"file"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s (%s)", p.File, p.Message, p.Kind)
+}
+
+// Check scans the Article files in Dir, as kept by a FileStore, for
+// Problems, returning them sorted by file name, or the error if Dir can't be
+// read. It only reads the files, so it's safe to run while gournal serves them.
+func Check() (res []Problem, err error) {
+	files, err := readDir(Dir)
+	if err != nil {
+		return nil, err
+	}
+	// the files claiming each slug, by name or content
+	claims := map[string][]string{}
+	for _, f := range files {
+		if !IsArticleFile(f) {
+			continue
+		}
+		name := f.Name()
+		slug := slugOf(name)
+		if !ValidSlug(slug) {
+			res = append(res, Problem{name, InvalidSlug, fmt.Sprintf("%q isn't a valid slug", slug)})
+		}
+		claims[slug] = append(claims[slug], name)
+
+		a, err := loadIn(Dir, name)
+		if err != nil {
+			res = append(res, Problem{name, Unparseable, strings.TrimPrefix(err.Error(), Dir+name+": ")})
+			continue
+		}
+		if a.Slug != slug {
+			res = append(res, Problem{name, SlugMismatch, fmt.Sprintf("holds the slug %q", a.Slug)})
+			claims[a.Slug] = append(claims[a.Slug], name)
+		}
+		if strings.TrimSpace(a.Title) == "" {
+			res = append(res, Problem{name, MissingField, "has no title"})
+		}
+		if strings.TrimSpace(a.Body) == "" {
+			res = append(res, Problem{name, MissingField, "has no body"})
+		}
+	}
+	for slug, names := range claims {
+		if len(names) < 2 {
+			continue
+		}
+		for i, name := range names {
+			others := append(append([]string(nil), names[:i]...), names[i+1:]...)
+			res = append(res, Problem{name, DuplicateSlug, fmt.Sprintf("shares the slug %q with %s", slug, strings.Join(others, ", "))})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].File < res[j].File })
+	return res, nil
+}
+
+// EachFile calls fn with the slug of every Article file in Dir, as kept by a
+// FileStore, bundles and those in cold storage included, along with the
+// Article it holds or else the error loading it, so one damaged file doesn't
+// stop the rest being checked. It stops at the first error fn returns, which
+// EachFile returns unless it is Stop. Like Check, it only reads the files.
+func EachFile(fn func(slug string, a *Article, err error) error) error {
+	err := eachFile(Dir, fn)
+	if err == Stop {
+		return nil
+	}
+	return err
+}
+
+// eachFile implements EachFile for the FileStore kept in dir
+func eachFile(dir string, fn func(slug string, a *Article, err error) error) error {
+	s := &FileStore{Dir: dir}
+	files, err := readDir(s.dir())
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !IsArticleFile(f) {
+			continue
+		}
+		a, err := loadIn(s.dir(), f.Name())
+		if err := fn(slugOf(f.Name()), a, err); err != nil {
+			return err
+		}
+	}
+
+	pack, err := s.coldPack()
+	if err != nil {
+		return err
+	}
+	slugs := make([]string, 0, len(pack.files))
+	for slug := range pack.files {
+		if !exists(s.dir(), slug) {
+			slugs = append(slugs, slug)
+		}
+	}
+	sort.Strings(slugs)
+	for _, slug := range slugs {
+		a, err := s.loadColdArticle(pack.files[slug])
+		if err := fn(slug, a, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
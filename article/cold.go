@@ -0,0 +1,269 @@
+package article
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrCold is returned when changing an Article a FileStore keeps in cold
+// storage, see Compact
+var ErrCold = errors.New("article: the article is in cold storage, so can't be changed")
+
+// the name of the archive in a FileStore's Dir holding the Articles in cold
+// storage
+const coldFile = ".cold.tar"
+
+// A coldPack indexes the Article files in a FileStore's cold storage archive,
+// as it was when last modified at modTime, by slug
+type coldPack struct {
+	modTime time.Time
+	size    int64
+	files   map[string]coldEntry
+}
+
+// A coldEntry locates an Article's file within the cold storage archive
+type coldEntry struct {
+	name    string
+	offset  int64
+	size    int64
+	modTime time.Time
+}
+
+// Compact packs the Articles which haven't been updated since before into
+// cold storage, a single archive in Dir, returning how many it packed. They
+// are still loaded, listed and searched as before, but can't be changed, and
+// huge sites are left with far fewer files in Dir to scan. Their files are
+// only removed once the archive holding them has been written in full.
+// Bundles are left where they are, as their assets must still be served.
+func (s *FileStore) Compact(before time.Time) (int, error) {
+	files, err := ioutil.ReadDir(s.dir())
+	if err != nil {
+		return 0, err
+	}
+	var old []os.FileInfo
+	var slugs []string
+	for _, f := range files {
+		if IsArticleFile(f) && f.ModTime().Before(before) {
+			old = append(old, f)
+			slugs = append(slugs, strings.TrimSuffix(f.Name(), filepath.Ext(f.Name())))
+		}
+	}
+	if len(old) == 0 {
+		return 0, nil
+	}
+	defer s.lock(slugs...)()
+	// taken after the Articles' locks, as changing them takes it to check
+	// they aren't in cold storage
+	s.coldMu.Lock()
+	defer s.coldMu.Unlock()
+
+	pack, err := s.loadCold()
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(s.dir(), ".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	tw := tar.NewWriter(tmp)
+	for slug, e := range pack.files {
+		if exists(s.dir(), slug) {
+			// about to be packed afresh, or superseded by an Article since
+			// created with its slug
+			continue
+		}
+		b, err := s.readCold(e)
+		if err == nil {
+			err = writeEntry(tw, e.name, b, e.modTime)
+		}
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	for _, f := range old {
+		b, err := ioutil.ReadFile(s.dir() + f.Name())
+		if err == nil {
+			err = writeEntry(tw, f.Name(), b, f.ModTime())
+		}
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	err = tw.Close()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.dir()+coldFile)
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.cold = nil
+
+	for _, f := range old {
+		if err := os.Remove(s.dir() + f.Name()); err != nil {
+			return 0, err
+		}
+	}
+	return len(old), syncDir(s.dir())
+}
+
+// coldPack returns the index of the cold storage archive, reading it again if
+// it has changed since it was last read
+func (s *FileStore) coldPack() (*coldPack, error) {
+	s.coldMu.Lock()
+	defer s.coldMu.Unlock()
+	return s.loadCold()
+}
+
+// loadCold implements coldPack, with coldMu held
+func (s *FileStore) loadCold() (*coldPack, error) {
+	fi, err := os.Stat(s.dir() + coldFile)
+	if os.IsNotExist(err) {
+		return &coldPack{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.cold != nil && s.cold.modTime.Equal(fi.ModTime()) && s.cold.size == fi.Size() {
+		return s.cold, nil
+	}
+
+	f, err := os.Open(s.dir() + coldFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pack := &coldPack{modTime: fi.ModTime(), size: fi.Size(), files: map[string]coldEntry{}}
+	// counting what's been read places each file's contents, which the
+	// tar.Reader has read up to as Next returns
+	cr := &countingReader{r: f}
+	tr := tar.NewReader(cr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := formatOf(h.Name); !ok || h.Typeflag != tar.TypeReg {
+			continue
+		}
+		slug := strings.TrimSuffix(h.Name, filepath.Ext(h.Name))
+		pack.files[slug] = coldEntry{name: h.Name, offset: cr.n, size: h.Size, modTime: h.ModTime}
+	}
+	s.cold = pack
+	return pack, nil
+}
+
+// readCold returns the contents of the file e in the cold storage archive
+func (s *FileStore) readCold(e coldEntry) ([]byte, error) {
+	f, err := os.Open(s.dir() + coldFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(io.NewSectionReader(f, e.offset, e.size))
+}
+
+// loadColdArticle returns the Article in the file e in the cold storage
+// archive
+func (s *FileStore) loadColdArticle(e coldEntry) (*Article, error) {
+	b, err := s.readCold(e)
+	if err != nil {
+		return nil, err
+	}
+	f, _ := formatOf(e.name)
+	a, err := decode(b, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s%s: %s: %v", s.dir(), coldFile, e.name, err)
+	}
+	if a.Slug == "" {
+		a.Slug = strings.TrimSuffix(e.name, filepath.Ext(e.name))
+	}
+	a.format = f
+	a.updated = e.modTime
+	return a, nil
+}
+
+// loadColdSlug returns the Article identified by slug from cold storage, or an
+// error satisfying os.IsNotExist if it isn't there
+func (s *FileStore) loadColdSlug(slug string) (*Article, error) {
+	pack, err := s.coldPack()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := pack.files[slug]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: s.dir() + slug + DefaultFormat.Ext(), Err: os.ErrNotExist}
+	}
+	return s.loadColdArticle(e)
+}
+
+// inCold reports whether the Article identified by slug is only in cold
+// storage
+func (s *FileStore) inCold(slug string) bool {
+	if exists(s.dir(), slug) {
+		return false
+	}
+	pack, err := s.coldPack()
+	if err != nil {
+		return false
+	}
+	_, ok := pack.files[slug]
+	return ok
+}
+
+// addCold adds the Articles in cold storage which aren't in hot, those loaded
+// from Dir, to it, keeping the result sorted by latest date
+func (s *FileStore) addCold(hot []*Article) ([]*Article, error) {
+	pack, err := s.coldPack()
+	if err != nil || len(pack.files) == 0 {
+		return hot, err
+	}
+	seen := map[string]bool{}
+	for _, a := range hot {
+		seen[a.Slug] = true
+	}
+	res := hot
+	for slug, e := range pack.files {
+		if seen[slug] || exists(s.dir(), slug) {
+			continue
+		}
+		a, err := s.loadColdArticle(e)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].updated.After(res[j].updated) })
+	return res, nil
+}
+
+// countingReader counts the bytes read through it, in n
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,79 @@
+package article
+
+import (
+	"errors"
+	"os"
+)
+
+// An Iterator is a Store able to stream its Articles, loading one at a time,
+// rather than holding every Article in memory at once as List does.
+type Iterator interface {
+	// Each calls fn with every stored Article, most recently updated first,
+	// stopping at the first error fn returns, which Each returns. fn may use
+	// the Store.
+	Each(fn func(*Article) error) error
+}
+
+// Stop may be returned by the function passed to Each to stop early without
+// Each returning an error
+var Stop = errors.New("article: stop iterating")
+
+// Each calls fn with every Article in the DefaultStore, whether published or
+// not, sorted by latest date, stopping at the first error fn returns, which
+// Each returns unless it is Stop. Unlike All, it only holds one Article in
+// memory at a time if the DefaultStore is an Iterator or an Indexer.
+func Each(fn func(*Article) error) error {
+	err := each(DefaultStore, fn)
+	if err == Stop {
+		return nil
+	}
+	return err
+}
+
+// each calls fn with every Article in s, one at a time where s allows
+func each(s Store, fn func(*Article) error) error {
+	if it, ok := s.(Iterator); ok {
+		return it.Each(fn)
+	}
+	if ix, ok := s.(Indexer); ok {
+		index, err := ix.Index()
+		if err != nil {
+			return err
+		}
+		slugs := make([]string, len(index))
+		for i, e := range index {
+			slugs[i] = e.Slug
+		}
+		return eachSlug(s, slugs, fn)
+	}
+
+	articles, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, a := range articles {
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachSlug loads each of the Articles identified by slugs from s in turn and
+// calls fn with it, skipping those no longer stored
+func eachSlug(s Store, slugs []string, fn func(*Article) error) error {
+	for _, slug := range slugs {
+		a, err := s.Load(slug)
+		if os.IsNotExist(err) {
+			// trashed or renamed since it was listed
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,568 @@
+package article
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A FileStore keeps each Article as a file in Dir, in any of the Formats, and
+// orders them by modification time. Revisions, the trash and redirects are
+// kept in hidden files and directories alongside, as is an index of Entries
+// and the archive of Articles in cold storage, see Compact. It is safe for
+// concurrent use: changes to an Article are serialised, and files are replaced
+// atomically so they're never read half written.
+type FileStore struct {
+	Dir string
+	// Logger records what the FileStore works around rather than fails, such
+	// as rebuilding a damaged index, or slog's default Logger if nil
+	Logger *slog.Logger
+
+	// serialise changes to each Article, and its revisions, spread across a
+	// fixed number of locks by slug
+	locks [lockStripes]sync.RWMutex
+	// serialises changes to the redirects
+	redirectsMu sync.Mutex
+	// serialises updates to the index of Entries
+	indexMu sync.Mutex
+	// guards the index of the cold storage archive, cold, and compaction
+	coldMu sync.Mutex
+	cold   *coldPack
+}
+
+// the number of locks a FileStore spreads its Articles' slugs across
+const lockStripes = 64
+
+// byLatestDate implements the sort.Interface
+type byLatestDate []os.FileInfo
+
+func (f byLatestDate) Len() int           { return len(f) }
+func (f byLatestDate) Less(i, j int) bool { return f[i].ModTime().After(f[j].ModTime()) }
+func (f byLatestDate) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// Load implements Store
+func (s *FileStore) Load(slug string) (*Article, error) {
+	defer s.rlock(slug)()
+	path, err := find(s.dir(), slug)
+	if os.IsNotExist(err) {
+		return s.loadColdSlug(slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loadIn(s.dir(), strings.TrimPrefix(path, s.dir()))
+}
+
+// List implements Store
+func (s *FileStore) List() ([]*Article, error) {
+	articles, err := loadDir(s.dir())
+	if err != nil {
+		return nil, err
+	}
+	return s.addCold(articles)
+}
+
+// Search implements Store
+func (s *FileStore) Search(query string) (res []*Article, err error) {
+	articles, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range articles {
+		if a.matches(query) {
+			res = append(res, a)
+		}
+	}
+	return
+}
+
+// Create implements Store. The file is written in full before being linked
+// into place, which fails if the file already exists, so two Articles created
+// at once with the same slug can't overwrite one another.
+func (s *FileStore) Create(a *Article) error {
+	defer s.lock(a.Slug)()
+	if exists(s.dir(), a.Slug) || s.inCold(a.Slug) {
+		return ErrSlugExists
+	}
+	b, err := encode(a, a.fileFormat())
+	if err != nil {
+		return err
+	}
+	tmp, err := writeTemp(s.dir(), b)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	err = os.Link(tmp, s.path(a))
+	if os.IsExist(err) {
+		return ErrSlugExists
+	}
+	if err != nil {
+		return err
+	}
+	if exists(s.dir(), a.Slug, a.fileFormat()) {
+		// another Format of the Article was created with this slug meanwhile
+		os.Remove(s.path(a))
+		return ErrSlugExists
+	}
+	if err := syncDir(s.dir()); err != nil {
+		return err
+	}
+	a.updated = time.Now()
+	return nil
+}
+
+// Save implements Store
+func (s *FileStore) Save(a *Article) error {
+	defer s.lock(a.Slug)()
+	if s.inCold(a.Slug) {
+		return ErrCold
+	}
+	b, err := encode(a, a.fileFormat())
+	if err != nil {
+		return err
+	}
+	err = s.saveRevision(a)
+	if err != nil {
+		return err
+	}
+	err = writeFile(s.path(a), b)
+	if err != nil {
+		return err
+	}
+	a.updated = time.Now()
+	return nil
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(slug string) error {
+	defer s.lock(slug)()
+	path, err := find(s.dir(), slug)
+	if os.IsNotExist(err) && s.inCold(slug) {
+		return ErrCold
+	}
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(s.trashDir(), 0700)
+	if err != nil {
+		return err
+	}
+	entry := entryOf(s.dir(), slug, path)
+	trashed := s.trashDir() + filepath.Base(entry)
+	err = os.Rename(entry, trashed)
+	if err != nil {
+		return err
+	}
+	// record when the Article was trashed so Trashed can list newest first
+	now := time.Now()
+	return os.Chtimes(trashed+strings.TrimPrefix(path, entry), now, now)
+}
+
+// Touch implements Store
+func (s *FileStore) Touch(slug string, t time.Time) error {
+	defer s.lock(slug)()
+	path, err := find(s.dir(), slug)
+	if os.IsNotExist(err) && s.inCold(slug) {
+		return ErrCold
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(path, t, t)
+}
+
+// Rename implements Store
+func (s *FileStore) Rename(from, to string) error {
+	defer s.lock(from, to)()
+	if exists(s.dir(), to) || s.inCold(to) {
+		return ErrSlugExists
+	}
+	if s.inCold(from) {
+		return ErrCold
+	}
+	path, err := find(s.dir(), from)
+	if entry := entryOf(s.dir(), from, path); err == nil && entry != path {
+		err = os.Rename(entry, s.dir()+to)
+	} else if err == nil {
+		err = os.Rename(path, s.dir()+to+filepath.Ext(path))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Rename(s.revisionsDir()+from, s.revisionsDir()+to)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.addRedirect(from, to)
+}
+
+// Redirect implements Store
+func (s *FileStore) Redirect(slug string) (string, bool) {
+	redirects, err := s.loadRedirects()
+	if err != nil {
+		return "", false
+	}
+	to, ok := redirects[slug]
+	return to, ok
+}
+
+// Redirects implements Store
+func (s *FileStore) Redirects() (map[string]string, error) {
+	return s.loadRedirects()
+}
+
+// Trashed implements Store
+func (s *FileStore) Trashed() ([]*Article, error) {
+	res, err := loadDir(s.trashDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return res, err
+}
+
+// Restore implements Store
+func (s *FileStore) Restore(slug string) error {
+	defer s.lock(slug)()
+	if exists(s.dir(), slug) || s.inCold(slug) {
+		return fmt.Errorf("article: cannot restore %q, an article with that slug already exists", slug)
+	}
+	path, err := find(s.trashDir(), slug)
+	if err != nil {
+		return err
+	}
+	entry := entryOf(s.trashDir(), slug, path)
+	return os.Rename(entry, s.dir()+filepath.Base(entry))
+}
+
+// Revisions implements Store
+func (s *FileStore) Revisions(slug string) (res []Revision, err error) {
+	defer s.rlock(slug)()
+	files, err := ioutil.ReadDir(s.revisionsDir() + slug)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		name := files[i].Name()
+		id := strings.TrimSuffix(name, filepath.Ext(name))
+		t, err := time.Parse(revisionLayout, id)
+		if err != nil {
+			continue
+		}
+		res = append(res, Revision{ID: id, Time: t})
+	}
+	return
+}
+
+// LoadRevision implements Store
+func (s *FileStore) LoadRevision(slug, id string) (*Article, error) {
+	defer s.rlock(slug)()
+	path, err := find(s.revisionsDir()+slug+"/", id)
+	if err != nil {
+		return nil, err
+	}
+	return loadFile(path)
+}
+
+// saveRevision copies the currently stored version of an Article, if there is
+// one, into the revisions directory
+func (s *FileStore) saveRevision(a *Article) error {
+	prev, err := ioutil.ReadFile(s.path(a))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dir := s.revisionsDir() + a.Slug
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	id := time.Now().UTC().Format(revisionLayout)
+	return writeFile(dir+"/"+id+a.fileFormat().Ext(), prev)
+}
+
+// loadRedirects reads the map of renamed slugs to their replacements
+func (s *FileStore) loadRedirects() (map[string]string, error) {
+	redirects := map[string]string{}
+	b, err := ioutil.ReadFile(s.redirectsFile())
+	if os.IsNotExist(err) {
+		return redirects, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &redirects)
+	return redirects, err
+}
+
+// addRedirect records that the Article at from has moved to to, updating any
+// older redirects so they lead straight to to rather than along a chain
+func (s *FileStore) addRedirect(from, to string) error {
+	s.redirectsMu.Lock()
+	defer s.redirectsMu.Unlock()
+	redirects, err := s.loadRedirects()
+	if err != nil {
+		return err
+	}
+	for old, current := range redirects {
+		if current == from {
+			redirects[old] = to
+		}
+	}
+	redirects[from] = to
+	// the new slug is a real Article again, so it must not redirect anywhere
+	delete(redirects, to)
+	b, err := json.Marshal(redirects)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.redirectsFile(), b)
+}
+
+// lock locks the Articles identified by slugs for changing, returning the
+// function which unlocks them. Locks are always taken in the same order, so
+// two calls locking the same slugs can't deadlock.
+func (s *FileStore) lock(slugs ...string) (unlock func()) {
+	stripes := map[int]bool{}
+	for _, slug := range slugs {
+		stripes[stripe(slug)] = true
+	}
+	var locked []int
+	for i := range s.locks {
+		if stripes[i] {
+			s.locks[i].Lock()
+			locked = append(locked, i)
+		}
+	}
+	return func() {
+		for _, i := range locked {
+			s.locks[i].Unlock()
+		}
+	}
+}
+
+// rlock locks the Article identified by slug for reading, returning the
+// function which unlocks it
+func (s *FileStore) rlock(slug string) (unlock func()) {
+	l := &s.locks[stripe(slug)]
+	l.RLock()
+	return l.RUnlock
+}
+
+// stripe returns which of a FileStore's locks guards the Article identified
+// by slug
+func stripe(slug string) int {
+	h := fnv.New32a()
+	h.Write([]byte(slug))
+	return int(h.Sum32() % lockStripes)
+}
+
+// dir returns the Dir of the FileStore, with a trailing slash
+func (s *FileStore) dir() string {
+	return strings.TrimSuffix(s.Dir, "/") + "/"
+}
+
+// revisionsDir returns the location prior versions of Articles are kept, one
+// directory per slug
+func (s *FileStore) revisionsDir() string {
+	return s.dir() + ".revisions/"
+}
+
+// trashDir returns the location Articles are moved to when trashed
+func (s *FileStore) trashDir() string {
+	return s.dir() + ".trash/"
+}
+
+// redirectsFile returns the location of the map of renamed slugs to their
+// replacements
+func (s *FileStore) redirectsFile() string {
+	return s.dir() + ".redirects.json"
+}
+
+// path returns the location the Article a is stored at, within its bundle if
+// there's a directory for it and no file of its own
+func (s *FileStore) path(a *Article) string {
+	path := s.dir() + a.Slug + a.fileFormat().Ext()
+	if fi, err := os.Stat(s.dir() + a.Slug); err == nil && fi.IsDir() {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return s.dir() + a.Slug + "/" + bundleIndex + a.fileFormat().Ext()
+		}
+	}
+	return path
+}
+
+// A MissingDirError is returned when a file can't be written because the
+// directory it belongs in, Dir, doesn't exist.
+type MissingDirError struct {
+	Dir string
+}
+
+func (e *MissingDirError) Error() string {
+	return fmt.Sprintf("article: the directory %s doesn't exist", e.Dir)
+}
+
+// writeFile replaces the file at path with b atomically and durably: b is
+// written to a temporary file alongside, synced to disk and then renamed into
+// place, so a crash leaves either the old file or the new one, never a mix
+func writeFile(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := writeTemp(dir, b)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// writeTemp writes b to a new hidden temporary file in dir, synced to disk,
+// returning its path
+func writeTemp(dir string, b []byte) (string, error) {
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if os.IsNotExist(err) {
+		return "", &MissingDirError{Dir: dir}
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// syncDir syncs the directory dir to disk, so the files just renamed or linked
+// into it survive a crash
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// directories can't be opened to be synced there
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// IsArticleFile reports whether f, found in a FileStore's Dir, holds an
+// Article. Hidden files such as indexes kept alongside the Articles are
+// excluded.
+func IsArticleFile(f os.FileInfo) bool {
+	_, ok := formatOf(f.Name())
+	return ok && !f.IsDir() && !strings.HasPrefix(f.Name(), ".")
+}
+
+// find returns the path of the file in dir holding the Article identified by
+// slug, in whichever Format it is stored, whether on its own or in a bundle
+func find(dir, slug string) (string, error) {
+	for _, f := range Formats {
+		if _, err := os.Stat(dir + slug + f.Ext()); err == nil {
+			return dir + slug + f.Ext(), nil
+		}
+	}
+	for _, f := range Formats {
+		if _, err := os.Stat(dir + slug + "/" + bundleIndex + f.Ext()); err == nil {
+			return dir + slug + "/" + bundleIndex + f.Ext(), nil
+		}
+	}
+	return "", &os.PathError{Op: "open", Path: dir + slug + DefaultFormat.Ext(), Err: os.ErrNotExist}
+}
+
+// exists reports whether an Article identified by slug is stored in dir, on
+// its own or in a bundle, in any Format other than those in except
+func exists(dir, slug string, except ...Format) bool {
+	for _, f := range Formats {
+		skip := false
+		for _, e := range except {
+			skip = skip || e == f
+		}
+		if skip {
+			continue
+		}
+		if _, err := os.Stat(dir + slug + f.Ext()); err == nil {
+			return true
+		}
+		if _, err := os.Stat(dir + slug + "/" + bundleIndex + f.Ext()); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFile attempts to load an Article from the file at path, in the Format
+// given by its extension, returning the error if one occurs
+func loadFile(path string) (a *Article, err error) {
+	f, ok := formatOf(path)
+	if !ok {
+		return nil, fmt.Errorf("article: unknown format of %s", path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a, err = decode(b, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if a.Slug == "" {
+		name := filepath.Base(path)
+		a.Slug = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	a.format = f
+	a.updated = fi.ModTime()
+	return a, nil
+}
+
+// loadDir loads every Article in dir, sorted by latest date, returning the
+// error if one occurs
+func loadDir(dir string) (res []*Article, err error) {
+	files, err := readDir(dir)
+	if err != nil {
+		return
+	}
+	sort.Sort(byLatestDate(files))
+	for _, f := range files {
+		if !IsArticleFile(f) {
+			continue
+		}
+		a, err := loadIn(dir, f.Name())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return
+}
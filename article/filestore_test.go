@@ -0,0 +1,171 @@
+package article
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+// The FileStore tests must pass under the race detector, go test -race, as
+// the store is shared by every request the site serves.
+
+// newTestFileStore returns a FileStore in a temporary directory removed once
+// the test finishes, holding n Articles, "article-0" to "article-<n-1>"
+func newTestFileStore(t *testing.T, n int) *FileStore {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s := &FileStore{Dir: dir}
+	for i := 0; i < n; i++ {
+		a := &Article{Title: fmt.Sprintf("Article %d", i), Body: "First draft", Slug: fmt.Sprintf("article-%d", i)}
+		if err := s.Create(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestFileStoreConcurrentSaveLoad(t *testing.T) {
+	const articles, saves = 4, 50
+	s := newTestFileStore(t, articles)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, articles*saves*2)
+	for i := 0; i < articles; i++ {
+		slug := fmt.Sprintf("article-%d", i)
+		wg.Add(2)
+		go func(slug string) {
+			defer wg.Done()
+			for j := 0; j < saves; j++ {
+				a := &Article{Title: slug, Body: fmt.Sprintf("Draft %d", j), Slug: slug}
+				if err := s.Save(a); err != nil {
+					errs <- fmt.Errorf("saving %s: %v", slug, err)
+				}
+			}
+		}(slug)
+		go func(slug string) {
+			defer wg.Done()
+			for j := 0; j < saves; j++ {
+				// a file replaced while it's read must never be read half written
+				a, err := s.Load(slug)
+				if err != nil {
+					errs <- fmt.Errorf("loading %s: %v", slug, err)
+				} else if a.Slug != slug || a.Body == "" {
+					errs <- fmt.Errorf("loading %s: got %q with body %q", slug, a.Slug, a.Body)
+				}
+			}
+		}(slug)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for i := 0; i < articles; i++ {
+		slug := fmt.Sprintf("article-%d", i)
+		a, err := s.Load(slug)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("Draft %d", saves-1); a.Body != want {
+			t.Errorf("%s: got body %q after the saves, want %q", slug, a.Body, want)
+		}
+	}
+}
+
+func TestFileStoreConcurrentRename(t *testing.T) {
+	const articles = 8
+	s := newTestFileStore(t, articles)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, articles*3)
+	for i := 0; i < articles; i++ {
+		wg.Add(3)
+		go func(i int) {
+			defer wg.Done()
+			from, to := fmt.Sprintf("article-%d", i), fmt.Sprintf("renamed-%d", i)
+			if err := s.Rename(from, to); err != nil {
+				errs <- fmt.Errorf("renaming %s: %v", from, err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			// the Article is found by one slug or the other, whichever it had
+			from, to := fmt.Sprintf("article-%d", i), fmt.Sprintf("renamed-%d", i)
+			a, err := s.Load(from)
+			if os.IsNotExist(err) {
+				a, err = s.Load(to)
+			}
+			if err != nil {
+				errs <- fmt.Errorf("loading %s while it's renamed: %v", from, err)
+			} else if a.Title != fmt.Sprintf("Article %d", i) {
+				errs <- fmt.Errorf("loading %s while it's renamed: got %q", from, a.Title)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if _, err := s.List(); err != nil {
+				errs <- fmt.Errorf("listing while renaming: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for i := 0; i < articles; i++ {
+		if _, err := s.Load(fmt.Sprintf("renamed-%d", i)); err != nil {
+			t.Errorf("loading renamed-%d: %v", i, err)
+		}
+		if to, ok := s.Redirect(fmt.Sprintf("article-%d", i)); !ok || to != fmt.Sprintf("renamed-%d", i) {
+			t.Errorf("article-%d redirects to %q, %v, want renamed-%d", i, to, ok, i)
+		}
+	}
+}
+
+func TestFileStoreConcurrentRenameToSameSlug(t *testing.T) {
+	const articles = 8
+	s := newTestFileStore(t, articles)
+
+	var wg sync.WaitGroup
+	results := make(chan error, articles)
+	for i := 0; i < articles; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results <- s.Rename(fmt.Sprintf("article-%d", i), "taken")
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	// only one may win, the rest finding the slug taken
+	var renamed int
+	for err := range results {
+		switch err {
+		case nil:
+			renamed++
+		case ErrSlugExists:
+		default:
+			t.Errorf("renaming: %v", err)
+		}
+	}
+	if renamed != 1 {
+		t.Errorf("%d articles were renamed to the same slug, want 1", renamed)
+	}
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != articles {
+		t.Errorf("got %d articles after the renames, want %d", len(list), articles)
+	}
+}
@@ -0,0 +1,194 @@
+package article
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// A Format is a representation an Article can be stored on disk in, named by
+// its file extension.
+type Format string
+
+const (
+	// JSON stores the whole Article as a single JSON object
+	JSON Format = "json"
+	// Markdown stores the Body as Markdown after a block of front matter
+	// holding the other fields, as used by most static site generators
+	Markdown Format = "md"
+)
+
+// Formats lists every Format Articles are loaded from
+var Formats = []Format{JSON, Markdown}
+
+// DefaultFormat is the Format new Articles are saved in. Existing Articles are
+// always saved back in the Format they were loaded from.
+var DefaultFormat = JSON
+
+// ParseFormat returns the Format named s, e.g. "json" or "md"
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("article: unknown format %q", s)
+}
+
+// Ext returns the file extension, including the dot, used by the Format
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+// frontMatter holds the fields of an Article stored as YAML or TOML front
+// matter in the Markdown Format
+type frontMatter struct {
+	ID          string            `yaml:"id,omitempty" toml:"id"`
+	Title       string            `yaml:"title" toml:"title"`
+	Slug        string            `yaml:"slug,omitempty" toml:"slug"`
+	Date        time.Time         `yaml:"date,omitempty" toml:"date"`
+	Author      string            `yaml:"author,omitempty" toml:"author"`
+	AuthorEmail string            `yaml:"author_email,omitempty" toml:"author_email"`
+	AuthorURL   string            `yaml:"author_url,omitempty" toml:"author_url"`
+	AuthorBio   string            `yaml:"author_bio,omitempty" toml:"author_bio"`
+	Excerpt     string            `yaml:"excerpt,omitempty" toml:"excerpt"`
+	Description string            `yaml:"description,omitempty" toml:"description"`
+	Tags        []string          `yaml:"tags,omitempty" toml:"tags"`
+	Visibility  string            `yaml:"visibility,omitempty" toml:"visibility"`
+	NoIndex     bool              `yaml:"noindex,omitempty" toml:"noindex"`
+	NoFollow    bool              `yaml:"nofollow,omitempty" toml:"nofollow"`
+	Meta        map[string]string `yaml:"meta,omitempty" toml:"meta"`
+	HeadHTML    string            `yaml:"head_html,omitempty" toml:"head_html"`
+	FooterHTML  string            `yaml:"footer_html,omitempty" toml:"footer_html"`
+	Password    string            `yaml:"password_hash,omitempty" toml:"password_hash"`
+	Change      *Change           `yaml:"change,omitempty" toml:"change"`
+}
+
+// formatOf returns the Format of the file name, reporting false if it isn't
+// one Articles are stored in
+func formatOf(name string) (Format, bool) {
+	ext := filepath.Ext(name)
+	for _, f := range Formats {
+		if f.Ext() == ext {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// encode returns the representation of a in Format f
+func encode(a *Article, f Format) ([]byte, error) {
+	switch f {
+	case JSON:
+		return encodeJSON(a)
+	case Markdown:
+		fm := frontMatter{
+			ID:          a.ID,
+			Title:       a.Title,
+			Slug:        a.Slug,
+			Date:        a.PublishAt,
+			Author:      a.Author.Name,
+			AuthorEmail: a.Author.Email,
+			AuthorURL:   a.Author.URL,
+			AuthorBio:   a.Author.Bio,
+			Excerpt:     a.Excerpt,
+			Description: a.Description,
+			Tags:        a.Tags,
+			Visibility:  string(a.Visibility),
+			NoIndex:     a.NoIndex,
+			NoFollow:    a.NoFollow,
+			Meta:        a.Meta,
+			HeadHTML:    a.HeadHTML,
+			FooterHTML:  a.FooterHTML,
+			Password:    string(a.PasswordHash),
+			Change:      a.Change,
+		}
+		b, err := yaml.Marshal(fm)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		buf.WriteString("---\n")
+		buf.Write(b)
+		buf.WriteString("---\n\n")
+		buf.WriteString(a.Body)
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("article: unknown format %q", f)
+}
+
+// decode parses an Article from its representation b in Format f. Markdown
+// front matter may be YAML, delimited by ---, or TOML, delimited by +++.
+func decode(b []byte, f Format) (a *Article, err error) {
+	switch f {
+	case JSON:
+		return decodeJSON(b)
+	case Markdown:
+		var fm frontMatter
+		body := string(b)
+		switch {
+		case strings.HasPrefix(body, "---\n") || strings.HasPrefix(body, "---\r\n"):
+			var head string
+			head, body, err = splitFrontMatter(body, "---")
+			if err == nil {
+				err = yaml.Unmarshal([]byte(head), &fm)
+			}
+		case strings.HasPrefix(body, "+++\n") || strings.HasPrefix(body, "+++\r\n"):
+			var head string
+			head, body, err = splitFrontMatter(body, "+++")
+			if err == nil {
+				_, err = toml.Decode(head, &fm)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Article{
+			ID:           fm.ID,
+			Title:        fm.Title,
+			Body:         strings.TrimLeft(body, "\r\n"),
+			Slug:         fm.Slug,
+			Author:       Author{Name: fm.Author, Email: fm.AuthorEmail, URL: fm.AuthorURL, Bio: fm.AuthorBio},
+			PublishAt:    fm.Date,
+			Excerpt:      fm.Excerpt,
+			Description:  fm.Description,
+			Tags:         fm.Tags,
+			Visibility:   Visibility(fm.Visibility),
+			NoIndex:      fm.NoIndex,
+			NoFollow:     fm.NoFollow,
+			Meta:         fm.Meta,
+			HeadHTML:     fm.HeadHTML,
+			FooterHTML:   fm.FooterHTML,
+			PasswordHash: []byte(fm.Password),
+			Change:       fm.Change,
+		}, nil
+	}
+	return nil, fmt.Errorf("article: unknown format %q", f)
+}
+
+// splitFrontMatter separates the front matter between the delim lines at the
+// start of s from the body which follows
+func splitFrontMatter(s, delim string) (head, body string, err error) {
+	s = s[strings.Index(s, "\n")+1:]
+	for i := 0; i < len(s); {
+		end := strings.Index(s[i:], "\n")
+		if end < 0 {
+			end = len(s) - i
+		}
+		if strings.TrimRight(s[i:i+end], "\r") == delim {
+			rest := i + end + 1
+			if rest > len(s) {
+				rest = len(s)
+			}
+			return s[:i], s[rest:], nil
+		}
+		i += end + 1
+	}
+	return "", "", errors.New("article: unterminated front matter")
+}
@@ -0,0 +1,122 @@
+package article
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/firegoby/gournal/truncate"
+)
+
+// The Fuzz tests are run with go test -fuzz FuzzSlugify, etc., and otherwise
+// run their seeds as ordinary tests. The seeds are the articles in the
+// source's articles/, as they were written.
+
+// seedArticles adds each of the files in the source's articles/ to f's corpus,
+// passed through field to pick what's added, e.g. its Title
+func seedArticles(f *testing.F, field func(b []byte) []byte) {
+	f.Helper()
+	files, err := filepath.Glob(filepath.Join("..", "articles", "*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		f.Add(field(b))
+	}
+}
+
+// decoded returns the Article in the file b, in whichever Format decodes it
+func decoded(b []byte) *Article {
+	for _, format := range Formats {
+		if a, err := decode(b, format); err == nil {
+			return a
+		}
+	}
+	return &Article{}
+}
+
+// FuzzSlugify checks every slug derived from a title is valid, and that
+// deriving a slug from it again changes nothing
+func FuzzSlugify(f *testing.F) {
+	seedArticles(f, func(b []byte) []byte { return []byte(decoded(b).Title) })
+	f.Add([]byte("Ünïcödé & <Entities>"))
+	f.Add([]byte("日本語のタイトル"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		slug := Slugify(string(data))
+		if slug == "" {
+			return
+		}
+		if !ValidSlug(slug) {
+			t.Fatalf("Slugify(%q) = %q, which isn't a valid slug", data, slug)
+		}
+		if again := Slugify(slug); again != slug {
+			t.Fatalf("Slugify(%q) = %q, not the slug itself", slug, again)
+		}
+	})
+}
+
+// FuzzDecode checks Articles are decoded from any file without panicking, and
+// that those which decode are encoded again and decoded to the same Body
+func FuzzDecode(f *testing.F) {
+	seedArticles(f, func(b []byte) []byte { return b })
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, format := range Formats {
+			a, err := decode(data, format)
+			if err != nil {
+				continue
+			}
+			b, err := encode(a, format)
+			if err != nil {
+				t.Fatalf("decoded %q as %s but can't encode it again: %v", data, format, err)
+			}
+			again, err := decode(b, format)
+			if err != nil {
+				t.Fatalf("can't decode %q, as %s, after encoding it: %v", b, format, err)
+			}
+			if again.Body != a.Body {
+				t.Fatalf("Body %q, as %s, became %q after encoding it", a.Body, format, again.Body)
+			}
+		}
+	})
+}
+
+// unsafeHTML matches markup from a Body which must never reach a page: raw
+// tags which could run script, and links to javascript: URLs
+var unsafeHTML = regexp.MustCompile(`(?i)<(script|iframe|object|embed|style)|<[^>]*\s(on[a-z]+\s*=|(href|src)\s*=\s*"\s*(javascript|vbscript):)`)
+
+// FuzzHTML checks any Markdown Body renders, and is summarised, without
+// panicking, and that neither its HTML, straight from Renderer or cleaned by
+// Sanitizer, nor its summary carries unsafe markup
+func FuzzHTML(f *testing.F) {
+	seedArticles(f, func(b []byte) []byte { return []byte(decoded(b).Body) })
+	f.Add([]byte("<script>alert(1)</script>"))
+	f.Add([]byte("[x](javascript:alert(1)) ![y](javascript:alert(1))"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var buf bytes.Buffer
+		if err := Renderer.Convert(data, &buf); err != nil {
+			return
+		}
+		if unsafeHTML.MatchString(buf.String()) {
+			t.Fatalf("Body %q rendered unsafe HTML %q", data, buf.String())
+		}
+		html, err := markdown(string(data), nil)
+		if err != nil {
+			t.Fatalf("Body %q rendered before it was cleaned, but not after: %v", data, err)
+		}
+		body := string(html)
+		if unsafeHTML.MatchString(body) {
+			t.Fatalf("Body %q was cleaned to unsafe HTML %q", data, body)
+		}
+		summary, _ := truncate.HTML(body, ExcerptWords)
+		if unsafeHTML.MatchString(summary) {
+			t.Fatalf("Body %q was summarised as unsafe HTML %q", data, summary)
+		}
+		truncate.Text(summary, DescriptionLength)
+	})
+}
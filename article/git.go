@@ -0,0 +1,214 @@
+package article
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A GitStore is a FileStore whose Dir is a git repository, committing every
+// change to the Articles so their full history, blame and backups come from
+// git. Edits are committed with the summaries their authors gave of them, see
+// Change. Each commit is pushed to Remote, if set, in the background. Git
+// doesn't record modification times, so a fresh clone lists Articles in the
+// order they were checked out until they're next saved. Open one with OpenGit.
+type GitStore struct {
+	*FileStore
+	Remote string
+
+	// serialises changes, so each commit holds exactly one
+	mu sync.Mutex
+	// serialises pushes, which happen outside mu
+	pushing sync.Mutex
+}
+
+// OpenGit opens the git repository at dir, initialising it and committing any
+// Articles already there if need be. The git command must be installed.
+func OpenGit(dir, remote string) (*GitStore, error) {
+	s := &GitStore{FileStore: &FileStore{Dir: dir}, Remote: remote}
+	err := os.MkdirAll(s.dir(), 0700)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(s.dir() + ".git"); os.IsNotExist(err) {
+		_, err = s.git("init", "-q")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the index of Entries is rebuilt from the Articles, and temporary files
+	// are renamed into place, so neither is committed
+	for _, pattern := range []string{".index*", ".tmp-*"} {
+		if err = s.exclude(pattern); err != nil {
+			return nil, err
+		}
+	}
+	// commits need an identity, so give gournal one if git has none
+	if email, _ := s.git("config", "user.email"); email == "" {
+		if _, err = s.git("config", "user.name", "gournal"); err != nil {
+			return nil, err
+		}
+		if _, err = s.git("config", "user.email", "gournal@localhost"); err != nil {
+			return nil, err
+		}
+	}
+	return s, s.commit("Commit existing articles")
+}
+
+// Create implements Store
+func (s *GitStore) Create(a *Article) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.FileStore.Create(a); err != nil {
+		return err
+	}
+	return s.commitAs(a, changeMessage("Create "+a.Slug, a))
+}
+
+// Save implements Store
+func (s *GitStore) Save(a *Article) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.FileStore.Save(a); err != nil {
+		return err
+	}
+	return s.commitAs(a, changeMessage("Update "+a.Slug, a))
+}
+
+// Delete implements Store
+func (s *GitStore) Delete(slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.FileStore.Delete(slug); err != nil {
+		return err
+	}
+	return s.commit("Trash " + slug)
+}
+
+// Touch implements Store. Git doesn't record modification times, so nothing is
+// committed.
+func (s *GitStore) Touch(slug string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.FileStore.Touch(slug, t)
+}
+
+// Rename implements Store
+func (s *GitStore) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.FileStore.Rename(from, to); err != nil {
+		return err
+	}
+	return s.commit("Rename " + from + " to " + to)
+}
+
+// Restore implements Store
+func (s *GitStore) Restore(slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.FileStore.Restore(slug); err != nil {
+		return err
+	}
+	return s.commit("Restore " + slug)
+}
+
+// commitAs commits every change in the repository, attributed to the author of
+// a if they have an email address
+func (s *GitStore) commitAs(a *Article, msg string) error {
+	if a.Author.Name == "" || a.Author.Email == "" {
+		return s.commit(msg)
+	}
+	return s.commit(msg, "--author", fmt.Sprintf("%s <%s>", a.Author.Name, a.Author.Email))
+}
+
+// changeMessage returns the message committing the edit to a described by
+// action, e.g. "Update hello-world", followed by the summary of its Change, if
+// its author wrote one, with the fields it changed in the body
+func changeMessage(action string, a *Article) string {
+	c := a.Change
+	if c == nil {
+		return action
+	}
+	msg := action
+	if summary := strings.Join(strings.Fields(c.Summary), " "); summary != "" {
+		msg += ": " + summary
+	}
+	if len(c.Fields) > 0 {
+		msg += "\n\nChanged " + strings.Join(c.Fields, ", ")
+	}
+	return msg
+}
+
+// commit commits every change in the repository with the message msg, if
+// there are any, and starts pushing it
+func (s *GitStore) commit(msg string, args ...string) error {
+	if _, err := s.git("add", "-A"); err != nil {
+		return err
+	}
+	// exits successfully only when nothing is staged
+	if _, err := s.git("diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	if _, err := s.git(append([]string{"commit", "-q", "-m", msg}, args...)...); err != nil {
+		return err
+	}
+	if s.Remote != "" {
+		go s.push()
+	}
+	return nil
+}
+
+// push pushes the current branch to Remote, logging any failure to the
+// FileStore's Logger rather than failing the change which was committed
+func (s *GitStore) push() {
+	s.pushing.Lock()
+	defer s.pushing.Unlock()
+	if _, err := s.git("push", "-q", s.Remote, "HEAD"); err != nil {
+		logTo(s.Logger).Error("pushing articles", "remote", s.Remote, "error", err)
+	}
+}
+
+// exclude stops git from committing the files in the repository matching
+// pattern, without a .gitignore which would itself be committed
+func (s *GitStore) exclude(pattern string) error {
+	path := s.dir() + ".git/info/exclude"
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b, '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, pattern+"\n"...), 0600)
+}
+
+// git runs the git command with args in the repository, returning its trimmed
+// output, or its error output as an error if it fails
+func (s *GitStore) git(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.dir()
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
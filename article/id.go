@@ -0,0 +1,96 @@
+package article
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet ULIDs are written in, which
+// leaves out I, L, O and U to avoid confusion
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// validID matches the 26 character ULIDs returned by NewID
+var validID = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+// NewID returns a new ULID (https://github.com/ulid/spec) for an Article
+// created at t: 48 bits of milliseconds since the Unix epoch followed by 80
+// random bits, so IDs sort by creation time
+func NewID(t time.Time) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixNano()/int64(time.Millisecond))<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(fmt.Sprintf("article: cannot generate ID: %v", err))
+	}
+
+	// encode the 128 bits 5 at a time, the first character holding just 3
+	var id [26]byte
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	for i := 25; i >= 0; i-- {
+		id[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id[:])
+}
+
+// ValidID reports whether id is an Article ID, as returned by NewID
+func ValidID(id string) bool {
+	return validID.MatchString(id)
+}
+
+// LoadByID attempts to load the Article with the given ID, returning an error
+// satisfying os.IsNotExist if there is no such Article
+func LoadByID(id string) (*Article, error) {
+	articles, err := All()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range articles {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return nil, notFound(id)
+}
+
+// AssignIDs gives every stored Article without an ID, saved before IDs were
+// introduced, one derived from when it was last updated, keeping its place in
+// listings. It returns the number of Articles updated.
+func AssignIDs() (n int, err error) {
+	articles, err := All()
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range articles {
+		if a.ID != "" {
+			continue
+		}
+		updated := a.Updated()
+		a.ID = NewID(updated)
+		if err = DefaultStore.Save(a); err != nil {
+			return n, err
+		}
+		if err = DefaultStore.Touch(a.Slug, updated); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// idTime returns the creation time encoded in the first 10 characters of the
+// ULID id, reporting false if id isn't one
+func idTime(id string) (time.Time, bool) {
+	if !ValidID(id) {
+		return time.Time{}, false
+	}
+	var ms int64
+	for _, c := range id[:10] {
+		ms = ms<<5 | int64(strings.IndexRune(crockford, c))
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
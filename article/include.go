@@ -0,0 +1,160 @@
+package article
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// includeShortcode matches the shortcodes which embed another Article in a
+// Body, by slug or ID: {{< include "slug" >}} embeds its Summary, while
+// {{< include "slug#section" >}} embeds the section beneath the heading
+// "section" is the slug of, e.g. "#disclaimer" for "## Disclaimer"
+var includeShortcode = regexp.MustCompile(`\{\{<\s*include\s+"([^"#]+)(?:#([^"]*))?"\s*>\}\}`)
+
+// atxHeading matches a Markdown heading such as "## Disclaimer ##"
+var atxHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+
+// the number of Articles which may be included within one another
+const MaxIncludeDepth = 10
+
+// An IncludeError describes an include shortcode on Line of a Body which
+// couldn't be expanded
+type IncludeError struct {
+	Line   int
+	Ref    string
+	Reason string
+}
+
+func (e *IncludeError) Error() string {
+	return fmt.Sprintf("can't include %q: %s", e.Ref, e.Reason)
+}
+
+// ExpandIncludes returns the Article's Body with each include shortcode
+// replaced by the summary or section of Markdown it refers to, expanding
+// shortcodes within those in turn. A shortcode which can't be expanded, e.g.
+// because the Article it refers to doesn't exist, isn't published or would
+// include itself, is replaced by a note of the problem and reported.
+func (a *Article) ExpandIncludes() (string, []*IncludeError) {
+	return expandIncludes(a.Body, []string{a.includeKey()})
+}
+
+// expandIncludes expands the include shortcodes in body outside of fenced code
+// blocks, refusing to include any Article in chain, those already being
+// expanded
+func expandIncludes(body string, chain []string) (string, []*IncludeError) {
+	var errs []*IncludeError
+	lines := strings.Split(body, "\n")
+	fenced := false
+	for n, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+		}
+		if fenced || !strings.Contains(line, "{{<") {
+			continue
+		}
+		lines[n] = includeShortcode.ReplaceAllStringFunc(line, func(shortcode string) string {
+			m := includeShortcode.FindStringSubmatch(shortcode)
+			text, nested := include(m[1], m[2], chain)
+			for _, err := range nested {
+				// report problems at the shortcode which led to them
+				err.Line = n + 1
+			}
+			errs = append(errs, nested...)
+			return text
+		})
+	}
+	return strings.Join(lines, "\n"), errs
+}
+
+// include returns the expanded summary, or named section, of the Article ref
+// identifies, or a note of why it can't be included
+func include(ref, section string, chain []string) (string, []*IncludeError) {
+	fail := func(format string, args ...interface{}) (string, []*IncludeError) {
+		err := &IncludeError{Ref: ref, Reason: fmt.Sprintf(format, args...)}
+		if section != "" {
+			err.Ref += "#" + section
+		}
+		return "*(" + err.Error() + ")*", []*IncludeError{err}
+	}
+
+	a, err := loadIncluded(ref)
+	switch {
+	case os.IsNotExist(err):
+		return fail("no such article")
+	case err != nil:
+		return fail("%v", err)
+	case !a.Published():
+		return fail("it isn't published")
+	case a.Protected():
+		return fail("it is password protected")
+	case len(chain) >= MaxIncludeDepth:
+		return fail("includes are nested more than %d deep", MaxIncludeDepth)
+	}
+	for _, key := range chain {
+		if key == a.includeKey() {
+			return fail("it includes this article")
+		}
+	}
+
+	text := a.Summary()
+	if section != "" {
+		var ok bool
+		if text, ok = a.section(section); !ok {
+			return fail("no section %q", section)
+		}
+	}
+	return expandIncludes(text, append(chain[:len(chain):len(chain)], a.includeKey()))
+}
+
+// loadIncluded loads the Article ref identifies by ID or slug, following the
+// redirects of renamed slugs
+func loadIncluded(ref string) (*Article, error) {
+	if ValidID(ref) {
+		return LoadByID(ref)
+	}
+	a, err := Load(ref)
+	if os.IsNotExist(err) {
+		if to, ok := Redirect(ref); ok {
+			return Load(to)
+		}
+	}
+	return a, err
+}
+
+// includeKey identifies the Article when detecting include cycles
+func (a *Article) includeKey() string {
+	if a.ID != "" {
+		return a.ID
+	}
+	return a.Slug
+}
+
+// section returns the Markdown beneath the heading of the Body whose slug is
+// name, up to the next heading of the same or a higher level, reporting false
+// if there is no such heading
+func (a *Article) section(name string) (string, bool) {
+	lines := strings.Split(a.Body, "\n")
+	start, level := -1, 0
+	fenced := false
+	for n, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+		}
+		m := atxHeading.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if fenced || m == nil {
+			continue
+		}
+		if start >= 0 && len(m[1]) <= level {
+			return strings.TrimSpace(strings.Join(lines[start:n], "\n")), true
+		}
+		if start < 0 && Slugify(m[2]) == name {
+			start, level = n+1, len(m[1])
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+	return strings.TrimSpace(strings.Join(lines[start:], "\n")), true
+}
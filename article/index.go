@@ -0,0 +1,240 @@
+package article
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// An Entry summarises an Article with just what listings of Articles show, so
+// they can be rendered without loading every Article's Body.
+type Entry struct {
+	ID          string `json:",omitempty"`
+	Slug        string
+	Title       string
+	Author      Author
+	PublishAt   time.Time
+	Updated     time.Time
+	Visibility  Visibility `json:",omitempty"`
+	Summary     string     `json:",omitempty"`
+	ReadingTime int
+	Protected   bool `json:",omitempty"`
+}
+
+// An Indexer is a Store able to list an Entry for each Article more cheaply
+// than loading every Article with List.
+type Indexer interface {
+	// Index returns an Entry for every stored Article, most recently updated
+	// first
+	Index() ([]*Entry, error)
+}
+
+// List returns an Entry for every Article in the DefaultStore, whether
+// published or not, sorted by latest date, returning the error if one occurs.
+// Unlike All, it doesn't load every Article if the DefaultStore is an Indexer.
+func List() ([]*Entry, error) {
+	if ix, ok := DefaultStore.(Indexer); ok {
+		return ix.Index()
+	}
+	articles, err := DefaultStore.List()
+	if err != nil {
+		return nil, err
+	}
+	return entries(articles), nil
+}
+
+// Page returns at most limit of the Articles Listed returns, sorted in order
+// and skipping the first offset, along with how many Listed returns in all.
+// Only the Articles on the page are loaded if the DefaultStore is an Indexer.
+func Page(offset, limit int, order Order) (res []*Article, total int, err error) {
+	index, err := List()
+	if err != nil {
+		return nil, 0, err
+	}
+	var listed []*Entry
+	for _, e := range index {
+		if e.Listed() {
+			listed = append(listed, e)
+		}
+	}
+	order.SortEntries(listed)
+	total = len(listed)
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || offset >= total {
+		return nil, total, nil
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	for _, e := range listed[offset:end] {
+		a, err := DefaultStore.Load(e.Slug)
+		if os.IsNotExist(err) {
+			// trashed since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		res = append(res, a)
+	}
+	return res, total, nil
+}
+
+// Entry returns the Entry listing the Article
+func (a *Article) Entry() *Entry {
+	e := &Entry{
+		ID:          a.ID,
+		Slug:        a.Slug,
+		Title:       a.Title,
+		Author:      a.Author,
+		PublishAt:   a.PublishAt,
+		Updated:     a.updated,
+		Visibility:  a.Visibility,
+		ReadingTime: a.ReadingTime(),
+		Protected:   a.Protected(),
+	}
+	// a protected Article's summary is only for those who know its password
+	if !e.Protected {
+		e.Summary = a.Summary()
+	}
+	return e
+}
+
+// Permalink returns the path the Article the Entry lists is served at
+func (e *Entry) Permalink() string {
+	return Permalink(e.Slug)
+}
+
+// Published reports whether the Article the Entry lists is visible to readers
+func (e *Entry) Published() bool {
+	return !e.PublishAt.After(time.Now())
+}
+
+// Listed reports whether the Article the Entry lists is published and appears
+// in listings, i.e. isn't Unlisted
+func (e *Entry) Listed() bool {
+	return e.Published() && e.Visibility != Unlisted
+}
+
+// entries returns the Entry of each of articles
+func entries(articles []*Article) []*Entry {
+	res := make([]*Entry, len(articles))
+	for i, a := range articles {
+		res[i] = a.Entry()
+	}
+	return res
+}
+
+// Index implements Indexer. The Entries are kept in a hidden index file
+// alongside the Articles, keyed by file name, and an Article is only loaded
+// when its file was modified since its Entry was made, so the index stays up
+// to date as Articles are saved, deleted or even edited by hand.
+func (s *FileStore) Index() ([]*Entry, error) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	files, err := readDir(s.dir())
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byLatestDate(files))
+
+	index := s.loadIndex()
+	fresh := map[string]*Entry{}
+	var res []*Entry
+	changed := false
+	for _, f := range files {
+		if !IsArticleFile(f) {
+			continue
+		}
+		e, ok := index[f.Name()]
+		if !ok || !e.Updated.Equal(f.ModTime()) {
+			a, err := loadIn(s.dir(), f.Name())
+			if err != nil {
+				return nil, err
+			}
+			e, changed = a.Entry(), true
+		}
+		fresh[f.Name()] = e
+		res = append(res, e)
+	}
+	res, cold, err := s.coldEntries(res, index, fresh)
+	if err != nil {
+		return nil, err
+	}
+	if changed || cold || len(fresh) != len(index) {
+		if err := s.saveIndex(fresh); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// coldEntries adds the Entries of the Articles in cold storage which aren't in
+// hot, those of the Articles in Dir, to it, keeping the result sorted by
+// latest date. Entries are taken from index, by the archive's name and the
+// file's, where they're up to date, and recorded in fresh, reporting whether
+// any had to be made.
+func (s *FileStore) coldEntries(hot []*Entry, index, fresh map[string]*Entry) (res []*Entry, changed bool, err error) {
+	pack, err := s.coldPack()
+	if err != nil || len(pack.files) == 0 {
+		return hot, false, err
+	}
+	seen := map[string]bool{}
+	for _, e := range hot {
+		seen[e.Slug] = true
+	}
+	res = hot
+	for slug, ce := range pack.files {
+		if seen[slug] || exists(s.dir(), slug) {
+			continue
+		}
+		key := coldFile + "/" + ce.name
+		e, ok := index[key]
+		if !ok || !e.Updated.Equal(ce.modTime) {
+			a, err := s.loadColdArticle(ce)
+			if err != nil {
+				return nil, false, err
+			}
+			e, changed = a.Entry(), true
+		}
+		fresh[key] = e
+		res = append(res, e)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Updated.After(res[j].Updated) })
+	return res, changed, nil
+}
+
+// loadIndex reads the Entries in the index file, keyed by file name, treating
+// a missing or unreadable index as empty so it's rebuilt
+func (s *FileStore) loadIndex() map[string]*Entry {
+	index := map[string]*Entry{}
+	b, err := ioutil.ReadFile(s.indexFile())
+	if err != nil {
+		return index
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		logTo(s.Logger).Warn("rebuilding the index of articles", "file", s.indexFile(), "error", err)
+		return map[string]*Entry{}
+	}
+	return index
+}
+
+// saveIndex replaces the index file with index
+func (s *FileStore) saveIndex(index map[string]*Entry) error {
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.indexFile(), b)
+}
+
+// indexFile returns the location of the Entries listing the Articles
+func (s *FileStore) indexFile() string {
+	return s.dir() + ".index.json"
+}
@@ -0,0 +1,382 @@
+package article
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// An Importer is a Store which can be filled in bulk from another, copying
+// every Article, with its revisions, along with the trash and redirects. It
+// can import from the same Store again: an Article it already holds, matched
+// by ID or else by slug, is updated if it has changed since, through Save so
+// its prior version is kept as a revision, and skipped if it hasn't, so
+// nothing is duplicated. Trashed Articles and redirects it already holds are
+// left alone.
+type Importer interface {
+	Store
+	Import(src Store) (*ImportReport, error)
+}
+
+// An ImportReport counts the Articles in the Store imported from by what
+// importing them did: created them, saved them over those already held which
+// differed, or skipped them as they were already held just as they are, or
+// can't be changed, e.g. being in cold storage
+type ImportReport struct {
+	Created int
+	Updated int
+	Skipped int
+}
+
+// an importItem is an Article to be imported, with the slug it has in the
+// Store it's imported from, whose revisions are found by it
+type importItem struct {
+	*Article
+	from string
+}
+
+// an importPlan sorts the Articles of a Store being imported from into those
+// to be created, updated and skipped
+type importPlan struct {
+	create  []importItem
+	update  []importItem
+	skipped int
+}
+
+// planImport matches each Article in src with any dst already holds, by ID or
+// else by slug, planning to create those it doesn't, and to update those which
+// differ, under the slug dst holds them by and in its Format
+func planImport(dst, src Store) (*importPlan, error) {
+	byID, bySlug := map[string]*Article{}, map[string]*Article{}
+	err := each(dst, func(a *Article) error {
+		if a.ID != "" {
+			byID[a.ID] = a
+		}
+		bySlug[a.Slug] = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &importPlan{}
+	err = each(src, func(a *Article) error {
+		existing := byID[a.ID]
+		if a.ID == "" || existing == nil {
+			existing = bySlug[a.Slug]
+		}
+		if existing == nil {
+			p.create = append(p.create, importItem{a, a.Slug})
+			return nil
+		}
+		c := *a
+		c.Slug, c.format, c.bundle = existing.Slug, existing.format, existing.bundle
+		if sameArticle(&c, existing) {
+			p.skipped++
+			return nil
+		}
+		p.update = append(p.update, importItem{&c, a.Slug})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// report returns the ImportReport of the plan
+func (p *importPlan) report() *ImportReport {
+	return &ImportReport{Created: len(p.create), Updated: len(p.update), Skipped: p.skipped}
+}
+
+// skip moves the Article planned to be created or updated as slug to those
+// skipped
+func (p *importPlan) skip(slug string) {
+	p.create = withoutItem(p.create, slug)
+	p.update = withoutItem(p.update, slug)
+	p.skipped++
+}
+
+// withoutItem returns items without the one planned as slug
+func withoutItem(items []importItem, slug string) []importItem {
+	var res []importItem
+	for _, it := range items {
+		if it.Slug != slug {
+			res = append(res, it)
+		}
+	}
+	return res
+}
+
+// saveUpdates saves the changed Articles planned to dst, dated when they were
+// last updated in the Store imported from
+func (p *importPlan) saveUpdates(dst Store) error {
+	for _, it := range p.update {
+		updated := it.updated
+		if err := dst.Save(it.Article); err != nil {
+			return err
+		}
+		if !updated.IsZero() {
+			if err := dst.Touch(it.Slug, updated); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importRevisions passes each revision of the Article held by src as slug to
+// put, with its ID and the time it was made
+func importRevisions(src Store, slug string, put func(id string, old *Article, t time.Time) error) error {
+	revisions, err := src.Revisions(slug)
+	if err != nil {
+		return err
+	}
+	for _, rev := range revisions {
+		old, err := src.LoadRevision(slug, rev.ID)
+		if err != nil {
+			return err
+		}
+		if err := put(rev.ID, old, rev.Time); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrNotEmpty is returned by Migrate when the Store to migrate to already holds
+// Articles
+var ErrNotEmpty = errors.New("article: the store to migrate to already holds articles")
+
+// A Summary counts what a Store holds, with a checksum of it all, so two
+// Stores can be checked to hold the same. The times Articles were last
+// updated aren't summed, as Stores record them to differing precision.
+type Summary struct {
+	Articles  int
+	Revisions int
+	Trashed   int
+	Redirects int
+	Checksum  string
+}
+
+func (s *Summary) String() string {
+	return fmt.Sprintf("%d articles, %d revisions, %d trashed and %d redirects, checksum %s", s.Articles, s.Revisions, s.Trashed, s.Redirects, s.Checksum)
+}
+
+// Summarize returns a Summary of s, loading one Article, and then each of its
+// revisions, at a time where s allows
+func Summarize(s Store) (*Summary, error) {
+	sum := &Summary{}
+	// the sums of the Articles, and the trash, by slug, so the checksum
+	// doesn't depend on the order they're listed in
+	sums := map[string]string{}
+	err := each(s, func(a *Article) error {
+		h := sha256.New()
+		if err := sumArticle(h, a); err != nil {
+			return err
+		}
+		revisions, err := s.Revisions(a.Slug)
+		if err != nil {
+			return err
+		}
+		for _, rev := range revisions {
+			old, err := s.LoadRevision(a.Slug, rev.ID)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(h, rev.ID)
+			if err := sumArticle(h, old); err != nil {
+				return err
+			}
+		}
+		sum.Articles++
+		sum.Revisions += len(revisions)
+		sums[a.Slug] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := s.Trashed()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range trashed {
+		h := sha256.New()
+		if err := sumArticle(h, a); err != nil {
+			return nil, err
+		}
+		sum.Trashed++
+		sums["trash:"+a.Slug] = hex.EncodeToString(h.Sum(nil))
+	}
+	redirects, err := s.Redirects()
+	if err != nil {
+		return nil, err
+	}
+	for from, to := range redirects {
+		sum.Redirects++
+		sums["redirect:"+from] = to
+	}
+
+	keys := make([]string, 0, len(sums))
+	for k := range sums {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintln(h, k, sums[k])
+	}
+	sum.Checksum = hex.EncodeToString(h.Sum(nil))
+	return sum, nil
+}
+
+// sumArticle writes a's fields, as JSON, to h
+func sumArticle(h io.Writer, a *Article) error {
+	b, err := encode(a, JSON)
+	if err != nil {
+		return err
+	}
+	_, err = h.Write(b)
+	return err
+}
+
+// Migrate copies everything src holds into dst, which must hold no Articles
+// yet, then checks dst holds the same as src by comparing their Summaries,
+// returning the Summary of what was copied
+func Migrate(dst Importer, src Store) (*Summary, error) {
+	existing, err := dst.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, ErrNotEmpty
+	}
+	if _, err := dst.Import(src); err != nil {
+		return nil, err
+	}
+	want, err := Summarize(src)
+	if err != nil {
+		return nil, err
+	}
+	got, err := Summarize(dst)
+	if err != nil {
+		return nil, err
+	}
+	if *got != *want {
+		return got, fmt.Errorf("article: the store migrated to holds %v, not %v", got, want)
+	}
+	return got, nil
+}
+
+// Import implements Importer, writing the Articles' files dated when they were
+// last updated. Articles in cold storage are skipped, as they can't be changed,
+// as are those whose slugs are held there.
+func (s *FileStore) Import(src Store) (*ImportReport, error) {
+	if err := os.MkdirAll(s.dir(), 0700); err != nil {
+		return nil, err
+	}
+	p, err := planImport(s, src)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range append(append([]importItem(nil), p.create...), p.update...) {
+		if s.inCold(it.Slug) {
+			p.skip(it.Slug)
+		}
+	}
+	for _, it := range p.create {
+		if err := s.importFile(s.path(it.Article), it.Article, it.updated); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.saveUpdates(s); err != nil {
+		return nil, err
+	}
+	for _, it := range append(p.create, p.update...) {
+		dir := s.revisionsDir() + it.Slug + "/"
+		err := importRevisions(src, it.from, func(id string, old *Article, t time.Time) error {
+			if _, err := find(dir, id); err == nil {
+				return nil
+			}
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return err
+			}
+			return s.importFile(dir+id+old.fileFormat().Ext(), old, t)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	trashed, err := src.Trashed()
+	if err != nil {
+		return nil, err
+	}
+	if len(trashed) > 0 {
+		if err := os.MkdirAll(s.trashDir(), 0700); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range trashed {
+		if _, err := find(s.trashDir(), a.Slug); err == nil {
+			continue
+		}
+		if err := s.importFile(s.trashDir()+a.Slug+a.fileFormat().Ext(), a, a.updated); err != nil {
+			return nil, err
+		}
+	}
+
+	redirects, err := src.Redirects()
+	if err != nil || len(redirects) == 0 {
+		return p.report(), err
+	}
+	s.redirectsMu.Lock()
+	defer s.redirectsMu.Unlock()
+	current, err := s.loadRedirects()
+	if err != nil {
+		return nil, err
+	}
+	for from, to := range redirects {
+		if _, ok := current[from]; !ok {
+			current[from] = to
+		}
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	return p.report(), writeFile(s.redirectsFile(), b)
+}
+
+// importFile writes a to path, in its Format, dated t unless that's zero
+func (s *FileStore) importFile(path string, a *Article, t time.Time) error {
+	b, err := encode(a, a.fileFormat())
+	if err != nil {
+		return err
+	}
+	if err := writeFile(path, b); err != nil {
+		return err
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, t, t)
+}
+
+// Import implements Importer, committing everything imported at once
+func (s *GitStore) Import(src Store) (*ImportReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report, err := s.FileStore.Import(src)
+	if err != nil {
+		return nil, err
+	}
+	return report, s.commit(fmt.Sprintf("Import articles: %d created, %d updated", report.Created, report.Updated))
+}
@@ -0,0 +1,93 @@
+package article
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// expectReport fails the test unless rep counts created, updated and skipped
+func expectReport(t *testing.T, rep *ImportReport, created, updated, skipped int) {
+	t.Helper()
+	if rep.Created != created || rep.Updated != updated || rep.Skipped != skipped {
+		t.Fatalf("imported %+v, want %d created, %d updated and %d skipped", *rep, created, updated, skipped)
+	}
+}
+
+func TestImportAgain(t *testing.T) {
+	src, dst := newTestFileStore(t, 0), newTestFileStore(t, 0)
+	for i := 0; i < 3; i++ {
+		a := &Article{ID: NewID(time.Unix(int64(i), 0)), Title: fmt.Sprintf("Article %d", i), Body: "First draft", Slug: fmt.Sprintf("article-%d", i)}
+		if err := src.Create(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rep, err := dst.Import(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReport(t, rep, 3, 0, 0)
+
+	rep, err = dst.Import(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReport(t, rep, 0, 0, 3)
+
+	// one changed, one renamed, which is matched by its ID, and one new
+	changed, err := src.Load("article-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed.Body = "Second draft"
+	if err := src.Save(changed); err != nil {
+		t.Fatal(err)
+	}
+	// renamed as the site does, saving it again under its new slug
+	renamed, err := src.Load("article-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Rename("article-1", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	renamed.Slug = "renamed"
+	if err := src.Save(renamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Create(&Article{ID: NewID(time.Unix(3, 0)), Title: "New", Body: "...", Slug: "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err = dst.Import(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReport(t, rep, 1, 1, 2)
+
+	a, err := dst.Load("article-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Body != "Second draft" {
+		t.Errorf("the changed article's body is %q after importing it again", a.Body)
+	}
+	revisions, err := dst.Revisions("article-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) == 0 {
+		t.Error("updating the changed article kept no revision of it")
+	}
+	if exists(dst.dir(), "renamed") {
+		t.Error("the renamed article was imported again under its new slug")
+	}
+	all, err := dst.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Errorf("holds %d articles after importing again, want 4", len(all))
+	}
+}
@@ -0,0 +1,88 @@
+package article
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An Order is a way of sorting a listing of Articles, named as it appears in
+// URLs.
+type Order string
+
+const (
+	// Latest lists the most recently updated Articles first
+	Latest Order = "updated"
+	// Newest lists the most recently created Articles first
+	Newest Order = "newest"
+	// Oldest lists the earliest created Articles first
+	Oldest Order = "oldest"
+	// ByTitle lists Articles alphabetically by title, ignoring case
+	ByTitle Order = "title"
+)
+
+// Orders lists every Order Articles may be listed in, Latest, the order
+// Stores list them in, first
+var Orders = []Order{Latest, Newest, Oldest, ByTitle}
+
+// ParseOrder returns the Order named s, e.g. "newest", or Latest if s is empty
+func ParseOrder(s string) (Order, error) {
+	if s == "" {
+		return Latest, nil
+	}
+	for _, o := range Orders {
+		if string(o) == s {
+			return o, nil
+		}
+	}
+	return "", fmt.Errorf("article: unknown order %q", s)
+}
+
+// Sort sorts articles, which are assumed to be most recently updated first as
+// Stores list them, in the Order
+func (o Order) Sort(articles []*Article) {
+	sort.SliceStable(articles, func(i, j int) bool {
+		return o.less(articles[i].Title, articles[i].Created(), articles[j].Title, articles[j].Created())
+	})
+}
+
+// SortEntries sorts entries, which are assumed to be most recently updated
+// first as Indexers list them, in the Order
+func (o Order) SortEntries(entries []*Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return o.less(entries[i].Title, entries[i].Created(), entries[j].Title, entries[j].Created())
+	})
+}
+
+// less reports whether an Article titled t1 and created at c1 sorts before
+// one titled t2 and created at c2, and false for those the Order doesn't tell
+// apart, so they stay most recently updated first
+func (o Order) less(t1 string, c1 time.Time, t2 string, c2 time.Time) bool {
+	switch o {
+	case Newest:
+		return c1.After(c2)
+	case Oldest:
+		return c1.Before(c2)
+	case ByTitle:
+		return strings.ToLower(t1) < strings.ToLower(t2)
+	}
+	return false
+}
+
+// Created returns when the Article was created, as recorded in its ID, or when
+// it was last updated if it has no ID
+func (a *Article) Created() time.Time {
+	if t, ok := idTime(a.ID); ok {
+		return t
+	}
+	return a.updated
+}
+
+// Created returns when the Article the Entry lists was created
+func (e *Entry) Created() time.Time {
+	if t, ok := idTime(e.ID); ok {
+		return t
+	}
+	return e.Updated
+}
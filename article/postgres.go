@@ -0,0 +1,446 @@
+package article
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// A PostgresStore keeps Articles in a PostgreSQL database, which several
+// gournal instances behind a load balancer can share. Like a SQLiteStore each
+// Article is stored as JSON, alongside copies of the columns it is searched
+// and sorted by. Every query is cancelled if it takes longer than Timeout.
+// Open one with OpenPostgres.
+type PostgresStore struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// the tables of a PostgresStore, created when it is first opened
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS articles (
+	slug    TEXT PRIMARY KEY,
+	title   TEXT NOT NULL,
+	body    TEXT NOT NULL,
+	tags    TEXT NOT NULL,
+	data    TEXT NOT NULL,
+	updated BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS articles_updated ON articles (updated);
+CREATE TABLE IF NOT EXISTS revisions (
+	slug TEXT NOT NULL,
+	id   TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (slug, id)
+);
+CREATE TABLE IF NOT EXISTS trash (
+	slug    TEXT PRIMARY KEY,
+	data    TEXT NOT NULL,
+	trashed BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS redirects (
+	slug   TEXT PRIMARY KEY,
+	target TEXT NOT NULL
+);`
+
+// postgresSchemaLock is the advisory lock held while creating the schema, so
+// instances starting at once don't race to create the same tables
+const postgresSchemaLock = 0x676f75726e616c // "gournal"
+
+// DefaultPostgresTimeout bounds each query of a PostgresStore opened with
+// OpenPostgres
+const DefaultPostgresTimeout = 10 * time.Second
+
+// OpenPostgres connects to the PostgreSQL database described by dsn, e.g.
+// "postgres://gournal@localhost/gournal?sslmode=disable", creating its tables
+// if they don't exist yet. At most maxConns connections are pooled, or any
+// number if maxConns is 0. A driver registered as "postgres", such as
+// github.com/lib/pq, must be imported by the program.
+func OpenPostgres(dsn string, maxConns int) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+	db.SetConnMaxLifetime(time.Hour)
+
+	s := &PostgresStore{DB: db, Timeout: DefaultPostgresTimeout}
+	err = s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, postgresSchemaLock)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, postgresSchema)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load implements Store
+func (s *PostgresStore) Load(slug string) (*Article, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	return scanArticle(s.DB.QueryRowContext(ctx, `SELECT data, updated FROM articles WHERE slug = $1`, slug), slug)
+}
+
+// List implements Store
+func (s *PostgresStore) List() ([]*Article, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	return queryArticles(ctx, s.DB, `SELECT data, updated FROM articles ORDER BY updated DESC`)
+}
+
+// Each implements Iterator, loading each Article in turn once their slugs
+// are listed, so no connection is held while fn runs
+func (s *PostgresStore) Each(fn func(*Article) error) error {
+	ctx, cancel := s.context()
+	slugs, err := querySlugs(ctx, s.DB, `SELECT slug FROM articles ORDER BY updated DESC`)
+	cancel()
+	if err != nil {
+		return err
+	}
+	return eachSlug(s, slugs, fn)
+}
+
+// Search implements Store
+func (s *PostgresStore) Search(query string) ([]*Article, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	return queryArticles(ctx, s.DB, `SELECT data, updated FROM articles
+		WHERE title ILIKE $1 OR body ILIKE $1 OR tags ILIKE $1
+		ORDER BY updated DESC`, pattern)
+}
+
+// Create implements Store
+func (s *PostgresStore) Create(a *Article) error {
+	data, err := encode(a, JSON)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	now := time.Now()
+	res, err := s.DB.ExecContext(ctx, `INSERT INTO articles (slug, title, body, tags, data, updated)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (slug) DO NOTHING`,
+		a.Slug, a.Title, a.Body, strings.Join(a.Tags, ","), string(data), now.UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSlugExists
+	}
+	a.updated = now
+	return nil
+}
+
+// Save implements Store
+func (s *PostgresStore) Save(a *Article) error {
+	data, err := encode(a, JSON)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		// instances saving at the same moment may both try to keep a revision
+		_, err := tx.ExecContext(ctx, `INSERT INTO revisions (slug, id, data)
+			SELECT slug, $1, data FROM articles WHERE slug = $2
+			ON CONFLICT (slug, id) DO NOTHING`,
+			now.UTC().Format(revisionLayout), a.Slug)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO articles (slug, title, body, tags, data, updated)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (slug) DO UPDATE SET title = excluded.title, body = excluded.body,
+				tags = excluded.tags, data = excluded.data, updated = excluded.updated`,
+			a.Slug, a.Title, a.Body, strings.Join(a.Tags, ","), string(data), now.UnixNano())
+		if err == nil {
+			a.updated = now
+		}
+		return err
+	})
+}
+
+// Delete implements Store
+func (s *PostgresStore) Delete(slug string) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `INSERT INTO trash (slug, data, trashed)
+			SELECT slug, data, $1 FROM articles WHERE slug = $2
+			ON CONFLICT (slug) DO UPDATE SET data = excluded.data, trashed = excluded.trashed`,
+			time.Now().UnixNano(), slug)
+		if err := mustAffect(res, err, slug); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM articles WHERE slug = $1`, slug)
+		return err
+	})
+}
+
+// Touch implements Store
+func (s *PostgresStore) Touch(slug string, t time.Time) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	res, err := s.DB.ExecContext(ctx, `UPDATE articles SET updated = $1 WHERE slug = $2`, t.UnixNano(), slug)
+	return mustAffect(res, err, slug)
+}
+
+// Rename implements Store
+func (s *PostgresStore) Rename(from, to string) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		var taken int
+		err := tx.QueryRowContext(ctx, `SELECT count(*) FROM articles WHERE slug = $1`, to).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrSlugExists
+		}
+
+		a, err := scanArticle(tx.QueryRowContext(ctx,
+			`SELECT data, updated FROM articles WHERE slug = $1 FOR UPDATE`, from), from)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			a.Slug = to
+			data, err := encode(a, JSON)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE articles SET slug = $1, data = $2 WHERE slug = $3`,
+				to, string(data), from)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, q := range []string{
+			`UPDATE revisions SET slug = $2 WHERE slug = $1`,
+			// lead older redirects straight to the new slug, not along a chain
+			`UPDATE redirects SET target = $2 WHERE target = $1`,
+			`INSERT INTO redirects (slug, target) VALUES ($1, $2)
+				ON CONFLICT (slug) DO UPDATE SET target = excluded.target`,
+			// the new slug is a real Article again, so it must not redirect
+			`DELETE FROM redirects WHERE slug = $2`,
+		} {
+			if _, err := tx.ExecContext(ctx, q, from, to); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Redirect implements Store
+func (s *PostgresStore) Redirect(slug string) (string, bool) {
+	ctx, cancel := s.context()
+	defer cancel()
+	var to string
+	err := s.DB.QueryRowContext(ctx, `SELECT target FROM redirects WHERE slug = $1`, slug).Scan(&to)
+	return to, err == nil
+}
+
+// Redirects implements Store
+func (s *PostgresStore) Redirects() (map[string]string, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	rows, err := s.DB.QueryContext(ctx, `SELECT slug, target FROM redirects`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	redirects := map[string]string{}
+	for rows.Next() {
+		var from, to string
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+		redirects[from] = to
+	}
+	return redirects, rows.Err()
+}
+
+// Trashed implements Store
+func (s *PostgresStore) Trashed() ([]*Article, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	return queryArticles(ctx, s.DB, `SELECT data, trashed FROM trash ORDER BY trashed DESC`)
+}
+
+// Restore implements Store
+func (s *PostgresStore) Restore(slug string) error {
+	return s.inTx(func(ctx context.Context, tx *sql.Tx) error {
+		a, err := scanArticle(tx.QueryRowContext(ctx,
+			`SELECT data, trashed FROM trash WHERE slug = $1 FOR UPDATE`, slug), slug)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `INSERT INTO articles (slug, title, body, tags, data, updated)
+			SELECT slug, $1, $2, $3, data, trashed FROM trash WHERE slug = $4
+			ON CONFLICT (slug) DO NOTHING`,
+			a.Title, a.Body, strings.Join(a.Tags, ","), slug)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("article: cannot restore %q, an article with that slug already exists", slug)
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM trash WHERE slug = $1`, slug)
+		return err
+	})
+}
+
+// Revisions implements Store
+func (s *PostgresStore) Revisions(slug string) (res []Revision, err error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	rows, err := s.DB.QueryContext(ctx, `SELECT id FROM revisions WHERE slug = $1 ORDER BY id DESC`, slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(revisionLayout, id)
+		if err != nil {
+			continue
+		}
+		res = append(res, Revision{ID: id, Time: t})
+	}
+	return res, rows.Err()
+}
+
+// LoadRevision implements Store
+func (s *PostgresStore) LoadRevision(slug, id string) (*Article, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	var data string
+	err := s.DB.QueryRowContext(ctx, `SELECT data FROM revisions WHERE slug = $1 AND id = $2`, slug, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, notFound(slug + "/" + id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode([]byte(data), JSON)
+}
+
+// Import implements Importer, inserting nothing it already holds, so instances
+// importing at once don't conflict. It is used to migrate an existing site,
+// e.g. from a FileStore, and to bring it up to date again. The import as a
+// whole isn't bound by Timeout.
+func (s *PostgresStore) Import(src Store) (*ImportReport, error) {
+	p, err := planImport(s, src)
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := src.Trashed()
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := src.Redirects()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, it := range p.create {
+		data, err := encode(it.Article, JSON)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO articles (slug, title, body, tags, data, updated)
+			VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (slug) DO NOTHING`,
+			it.Slug, it.Title, it.Body, strings.Join(it.Tags, ","), string(data), it.updated.UnixNano())
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, it := range append(p.create, p.update...) {
+		err := importRevisions(src, it.from, func(id string, old *Article, _ time.Time) error {
+			data, err := encode(old, JSON)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO revisions (slug, id, data) VALUES ($1, $2, $3)
+				ON CONFLICT (slug, id) DO NOTHING`, it.Slug, id, string(data))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range trashed {
+		data, err := encode(a, JSON)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO trash (slug, data, trashed) VALUES ($1, $2, $3)
+			ON CONFLICT (slug) DO NOTHING`, a.Slug, string(data), a.updated.UnixNano())
+		if err != nil {
+			return nil, err
+		}
+	}
+	for from, to := range redirects {
+		_, err := tx.ExecContext(ctx, `INSERT INTO redirects (slug, target) VALUES ($1, $2)
+			ON CONFLICT (slug) DO NOTHING`, from, to)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p.report(), p.saveUpdates(s)
+}
+
+// Close closes the database, and every pooled connection
+func (s *PostgresStore) Close() error {
+	return s.DB.Close()
+}
+
+// context returns a context cancelled after the PostgresStore's Timeout, if
+// it has one
+func (s *PostgresStore) context() (context.Context, context.CancelFunc) {
+	if s.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.Timeout)
+}
+
+// inTx runs fn in a transaction bound by the PostgresStore's Timeout,
+// committing it if fn succeeds and rolling it back otherwise
+func (s *PostgresStore) inTx(fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
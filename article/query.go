@@ -0,0 +1,180 @@
+package article
+
+import (
+	"strings"
+	"time"
+)
+
+// A Q selects the listed Articles in the DefaultStore matching all of its
+// conditions, which are added by chaining its methods, e.g.
+//
+//	article.Query().Tag("go").After(t).Limit(10).Articles()
+//
+// Each method returns the Q itself, so a Q shouldn't be shared once built.
+type Q struct {
+	tags          []string
+	author        string
+	after, before time.Time
+	offset, limit int
+	unlisted      bool
+	scheduled     bool
+	order         Order
+}
+
+// Query returns a Q selecting every listed Article, sorted by latest date
+func Query() *Q {
+	return &Q{order: Latest}
+}
+
+// Tag selects only the Articles tagged tag, ignoring case. Tag may be used
+// several times to select the Articles with all of the tags.
+func (q *Q) Tag(tag string) *Q {
+	q.tags = append(q.tags, tag)
+	return q
+}
+
+// Author selects only the Articles written by the author name, ignoring case
+func (q *Q) Author(name string) *Q {
+	q.author = name
+	return q
+}
+
+// After selects only the Articles last updated after t
+func (q *Q) After(t time.Time) *Q {
+	q.after = t
+	return q
+}
+
+// Before selects only the Articles last updated before t
+func (q *Q) Before(t time.Time) *Q {
+	q.before = t
+	return q
+}
+
+// Offset skips the first n of the selected Articles
+func (q *Q) Offset(n int) *Q {
+	q.offset = n
+	return q
+}
+
+// Limit selects at most n Articles, or all of them if n isn't positive
+func (q *Q) Limit(n int) *Q {
+	q.limit = n
+	return q
+}
+
+// Sort sorts the selected Articles in order, before Offset and Limit apply
+func (q *Q) Sort(order Order) *Q {
+	q.order = order
+	return q
+}
+
+// Unlisted also selects published Articles which don't appear in listings
+func (q *Q) Unlisted() *Q {
+	q.unlisted = true
+	return q
+}
+
+// Scheduled also selects unlisted Articles, and those yet to be published
+func (q *Q) Scheduled() *Q {
+	q.scheduled = true
+	return q
+}
+
+// Articles returns the selected Articles, returning the error if one occurs.
+// Sorted Latest, it stops reading the DefaultStore once it has found Limit of
+// them, and only holds those in memory if the DefaultStore is an Iterator or an
+// Indexer; sorted otherwise, it has to find them all first.
+func (q *Q) Articles() (res []*Article, err error) {
+	if q.order != Latest {
+		return q.sorted()
+	}
+	skip := q.offset
+	err = Each(func(a *Article) error {
+		if !q.matches(a) {
+			return nil
+		}
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		res = append(res, a)
+		if q.limit > 0 && len(res) >= q.limit {
+			return Stop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// sorted returns the selected Articles for an Order other than Latest
+func (q *Q) sorted() (res []*Article, err error) {
+	err = Each(func(a *Article) error {
+		if q.matches(a) {
+			res = append(res, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.order.Sort(res)
+	if q.offset >= len(res) {
+		return nil, nil
+	}
+	if q.offset > 0 {
+		res = res[q.offset:]
+	}
+	if q.limit > 0 && q.limit < len(res) {
+		res = res[:q.limit]
+	}
+	return res, nil
+}
+
+// Count returns how many Articles the Q selects, ignoring its Offset and Limit
+func (q *Q) Count() (n int, err error) {
+	err = Each(func(a *Article) error {
+		if q.matches(a) {
+			n++
+		}
+		return nil
+	})
+	return
+}
+
+// matches reports whether a meets all of the Q's conditions
+func (q *Q) matches(a *Article) bool {
+	switch {
+	case q.scheduled:
+	case q.unlisted && !a.Published(), !q.unlisted && !a.Listed():
+		return false
+	}
+	if q.author != "" && !strings.EqualFold(a.Author.Name, q.author) {
+		return false
+	}
+	if !q.after.IsZero() && !a.updated.After(q.after) {
+		return false
+	}
+	if !q.before.IsZero() && !a.updated.Before(q.before) {
+		return false
+	}
+	for _, tag := range q.tags {
+		if !a.Tagged(tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tagged reports whether the Article is tagged tag, ignoring case
+func (a *Article) Tagged(tag string) bool {
+	for _, t := range a.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
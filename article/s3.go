@@ -0,0 +1,564 @@
+package article
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// An S3Store keeps Articles as objects in a bucket of Amazon S3, or any
+// compatible object storage such as MinIO, so they outlive the container
+// gournal runs in. Each Article is stored as JSON at articles/<slug>.json
+// beneath Prefix, with revisions, the trash and redirects alongside. Objects
+// read are cached in process, and only fetched again once their ETag changes.
+// Open one with OpenS3.
+type S3Store struct {
+	Client *minio.Client
+	Bucket string
+	Prefix string
+
+	mu    sync.Mutex
+	cache map[string]s3Object
+}
+
+// S3Options locates the bucket of an S3Store. Endpoint is a host, such as
+// "s3.amazonaws.com" or "localhost:9000" for MinIO, reached over HTTPS unless
+// Insecure is set. Without an AccessKey the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables are used.
+type S3Options struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Insecure  bool
+}
+
+// an s3Object is the cached content of an object, as of ETag
+type s3Object struct {
+	ETag string
+	Data []byte
+}
+
+// an s3Record is stored for each Article and trashed Article, Updated being
+// when it was last saved, or trashed
+type s3Record struct {
+	Updated time.Time
+	Article json.RawMessage
+}
+
+// OpenS3 connects to the bucket described by opts, creating it if it doesn't
+// exist yet
+func OpenS3(opts S3Options) (*S3Store, error) {
+	creds := credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, "")
+	if opts.AccessKey == "" {
+		creds = credentials.NewEnvAWS()
+	}
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  creds,
+		Region: opts.Region,
+		Secure: !opts.Insecure,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	ok, err := client.BucketExists(ctx, opts.Bucket)
+	if err == nil && !ok {
+		err = client.MakeBucket(ctx, opts.Bucket, minio.MakeBucketOptions{Region: opts.Region})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &S3Store{
+		Client: client,
+		Bucket: opts.Bucket,
+		Prefix: strings.Trim(opts.Prefix, "/"),
+		cache:  map[string]s3Object{},
+	}, nil
+}
+
+// Load implements Store
+func (s *S3Store) Load(slug string) (*Article, error) {
+	return s.getArticle(s.articleKey(slug), slug)
+}
+
+// List implements Store
+func (s *S3Store) List() ([]*Article, error) {
+	return s.listArticles(s.key("articles") + "/")
+}
+
+// Search implements Store
+func (s *S3Store) Search(query string) (res []*Article, err error) {
+	articles, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range articles {
+		if a.matches(query) {
+			res = append(res, a)
+		}
+	}
+	return
+}
+
+// Create implements Store. The object is only written if it doesn't exist, so
+// two Articles created at once with the same slug can't overwrite one another.
+func (s *S3Store) Create(a *Article) error {
+	now := time.Now()
+	opts := minio.PutObjectOptions{}
+	opts.SetMatchETagExcept("*")
+	err := s.putRecord(s.articleKey(a.Slug), a, now, opts)
+	if minio.ToErrorResponse(err).Code == minio.PreconditionFailed {
+		return ErrSlugExists
+	}
+	if err != nil {
+		return err
+	}
+	a.updated = now
+	return nil
+}
+
+// Save implements Store
+func (s *S3Store) Save(a *Article) error {
+	now := time.Now()
+	prev, err := s.getRecord(s.articleKey(a.Slug), a.Slug)
+	if err == nil {
+		err = s.put(s.revisionKey(a.Slug, now.UTC().Format(revisionLayout)), prev.Article, minio.PutObjectOptions{})
+	}
+	if err != nil && !isNotExist(err) {
+		return err
+	}
+	err = s.putRecord(s.articleKey(a.Slug), a, now, minio.PutObjectOptions{})
+	if err != nil {
+		return err
+	}
+	a.updated = now
+	return nil
+}
+
+// Delete implements Store
+func (s *S3Store) Delete(slug string) error {
+	a, err := s.Load(slug)
+	if err != nil {
+		return err
+	}
+	// record when the Article was trashed so Trashed can list newest first
+	err = s.putRecord(s.trashKey(slug), a, time.Now(), minio.PutObjectOptions{})
+	if err != nil {
+		return err
+	}
+	return s.remove(s.articleKey(slug))
+}
+
+// Touch implements Store
+func (s *S3Store) Touch(slug string, t time.Time) error {
+	a, err := s.Load(slug)
+	if err != nil {
+		return err
+	}
+	return s.putRecord(s.articleKey(slug), a, t, minio.PutObjectOptions{})
+}
+
+// Rename implements Store
+func (s *S3Store) Rename(from, to string) error {
+	if _, err := s.Load(to); err == nil {
+		return ErrSlugExists
+	} else if !isNotExist(err) {
+		return err
+	}
+
+	a, err := s.Load(from)
+	if err == nil {
+		a.Slug = to
+		opts := minio.PutObjectOptions{}
+		opts.SetMatchETagExcept("*")
+		err = s.putRecord(s.articleKey(to), a, a.updated, opts)
+		if minio.ToErrorResponse(err).Code == minio.PreconditionFailed {
+			return ErrSlugExists
+		}
+		if err == nil {
+			err = s.remove(s.articleKey(from))
+		}
+	}
+	if err != nil && !isNotExist(err) {
+		return err
+	}
+
+	revisions, err := s.Revisions(from)
+	if err != nil {
+		return err
+	}
+	for _, rev := range revisions {
+		src, dst := s.revisionKey(from, rev.ID), s.revisionKey(to, rev.ID)
+		_, err := s.Client.CopyObject(context.Background(),
+			minio.CopyDestOptions{Bucket: s.Bucket, Object: dst},
+			minio.CopySrcOptions{Bucket: s.Bucket, Object: src})
+		if err == nil {
+			err = s.remove(src)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	redirects, err := s.Redirects()
+	if err != nil {
+		return err
+	}
+	// lead older redirects straight to the new slug, not along a chain
+	for old, current := range redirects {
+		if current == from {
+			redirects[old] = to
+		}
+	}
+	redirects[from] = to
+	// the new slug is a real Article again, so it must not redirect anywhere
+	delete(redirects, to)
+	b, err := json.Marshal(redirects)
+	if err != nil {
+		return err
+	}
+	return s.put(s.key("redirects.json"), b, minio.PutObjectOptions{})
+}
+
+// Redirect implements Store
+func (s *S3Store) Redirect(slug string) (string, bool) {
+	redirects, err := s.Redirects()
+	if err != nil {
+		return "", false
+	}
+	to, ok := redirects[slug]
+	return to, ok
+}
+
+// Redirects implements Store
+func (s *S3Store) Redirects() (map[string]string, error) {
+	redirects := map[string]string{}
+	b, err := s.get(s.key("redirects.json"))
+	if isNotExist(err) {
+		return redirects, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &redirects)
+	return redirects, err
+}
+
+// Trashed implements Store
+func (s *S3Store) Trashed() ([]*Article, error) {
+	return s.listArticles(s.key("trash") + "/")
+}
+
+// Restore implements Store
+func (s *S3Store) Restore(slug string) error {
+	a, err := s.getArticle(s.trashKey(slug), slug)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{}
+	opts.SetMatchETagExcept("*")
+	err = s.putRecord(s.articleKey(slug), a, a.updated, opts)
+	if minio.ToErrorResponse(err).Code == minio.PreconditionFailed {
+		return fmt.Errorf("article: cannot restore %q, an article with that slug already exists", slug)
+	}
+	if err != nil {
+		return err
+	}
+	return s.remove(s.trashKey(slug))
+}
+
+// Revisions implements Store
+func (s *S3Store) Revisions(slug string) (res []Revision, err error) {
+	prefix := s.key("revisions", slug) + "/"
+	for obj := range s.Client.ListObjects(context.Background(), s.Bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), ".json")
+		t, err := time.Parse(revisionLayout, id)
+		if err != nil {
+			continue
+		}
+		res = append(res, Revision{ID: id, Time: t})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID > res[j].ID })
+	return res, nil
+}
+
+// LoadRevision implements Store
+func (s *S3Store) LoadRevision(slug, id string) (*Article, error) {
+	b, err := s.get(s.revisionKey(slug, id))
+	if isNotExist(err) {
+		return nil, notFound(slug + "/" + id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(b, JSON)
+}
+
+// Import implements Importer. It is used to migrate an existing site, e.g.
+// from a FileStore, and to bring it up to date again.
+func (s *S3Store) Import(src Store) (*ImportReport, error) {
+	p, err := planImport(s, src)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range p.create {
+		err := s.putRecord(s.articleKey(it.Slug), it.Article, it.updated, minio.PutObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := p.saveUpdates(s); err != nil {
+		return nil, err
+	}
+	for _, it := range append(p.create, p.update...) {
+		err := importRevisions(src, it.from, func(id string, old *Article, _ time.Time) error {
+			key := s.revisionKey(it.Slug, id)
+			if _, err := s.get(key); err == nil {
+				return nil
+			} else if !isNotExist(err) {
+				return err
+			}
+			data, err := encode(old, JSON)
+			if err != nil {
+				return err
+			}
+			return s.put(key, data, minio.PutObjectOptions{})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	trashed, err := src.Trashed()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range trashed {
+		if _, err := s.get(s.trashKey(a.Slug)); err == nil {
+			continue
+		} else if !isNotExist(err) {
+			return nil, err
+		}
+		err := s.putRecord(s.trashKey(a.Slug), a, a.updated, minio.PutObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	redirects, err := src.Redirects()
+	if err != nil || len(redirects) == 0 {
+		return p.report(), err
+	}
+	current, err := s.Redirects()
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		current = map[string]string{}
+	}
+	for from, to := range redirects {
+		if _, ok := current[from]; !ok {
+			current[from] = to
+		}
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	return p.report(), s.put(s.key("redirects.json"), b, minio.PutObjectOptions{})
+}
+
+// Close implements the same method of the other database backed Stores,
+// though an S3Store holds nothing open
+func (s *S3Store) Close() error {
+	return nil
+}
+
+// key joins parts onto the S3Store's Prefix
+func (s *S3Store) key(parts ...string) string {
+	return path.Join(append([]string{s.Prefix}, parts...)...)
+}
+
+// articleKey returns the key the Article identified by slug is stored at
+func (s *S3Store) articleKey(slug string) string {
+	return s.key("articles", slug+".json")
+}
+
+// trashKey returns the key the trashed Article identified by slug is stored at
+func (s *S3Store) trashKey(slug string) string {
+	return s.key("trash", slug+".json")
+}
+
+// revisionKey returns the key of the prior version id of the Article
+// identified by slug
+func (s *S3Store) revisionKey(slug, id string) string {
+	return s.key("revisions", slug, id+".json")
+}
+
+// listArticles returns the Articles stored beneath prefix, most recently
+// updated first, fetching only those which changed since they were cached
+func (s *S3Store) listArticles(prefix string) (res []*Article, err error) {
+	for obj := range s.Client.ListObjects(context.Background(), s.Bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if !strings.HasSuffix(obj.Key, ".json") {
+			continue
+		}
+		s.mu.Lock()
+		cached, ok := s.cache[obj.Key]
+		s.mu.Unlock()
+		data := cached.Data
+		if !ok || cached.ETag != obj.ETag {
+			if data, err = s.fetch(obj.Key); err != nil {
+				return nil, err
+			}
+		}
+		a, err := decodeRecord(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", obj.Key, err)
+		}
+		res = append(res, a)
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].updated.After(res[j].updated) })
+	return res, nil
+}
+
+// getArticle loads the Article stored in the record at key, returning a not
+// found error for slug if there is none
+func (s *S3Store) getArticle(key, slug string) (*Article, error) {
+	b, err := s.get(key)
+	if isNotExist(err) {
+		return nil, notFound(slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(b)
+}
+
+// getRecord returns the record stored at key, returning a not found error for
+// slug if there is none
+func (s *S3Store) getRecord(key, slug string) (rec s3Record, err error) {
+	b, err := s.get(key)
+	if isNotExist(err) {
+		return rec, notFound(slug)
+	}
+	if err != nil {
+		return rec, err
+	}
+	err = json.Unmarshal(b, &rec)
+	return rec, err
+}
+
+// putRecord stores a at key as updated at t
+func (s *S3Store) putRecord(key string, a *Article, t time.Time, opts minio.PutObjectOptions) error {
+	data, err := encode(a, JSON)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(s3Record{Updated: t, Article: data})
+	if err != nil {
+		return err
+	}
+	return s.put(key, b, opts)
+}
+
+// get returns the content of the object at key, from the cache if it hasn't
+// changed since it was last read
+func (s *S3Store) get(key string) ([]byte, error) {
+	info, err := s.Client.StatObject(context.Background(), s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && cached.ETag == info.ETag {
+		return cached.Data, nil
+	}
+	return s.fetch(key)
+}
+
+// fetch reads the object at key, caching its content
+func (s *S3Store) fetch(key string) ([]byte, error) {
+	obj, err := s.Client.GetObject(context.Background(), s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.cache[key] = s3Object{ETag: info.ETag, Data: b}
+	s.mu.Unlock()
+	return b, nil
+}
+
+// put writes b to the object at key, caching it
+func (s *S3Store) put(key string, b []byte, opts minio.PutObjectOptions) error {
+	opts.ContentType = "application/json"
+	info, err := s.Client.PutObject(context.Background(), s.Bucket, key, bytes.NewReader(b), int64(len(b)), opts)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.cache[key] = s3Object{ETag: info.ETag, Data: b}
+	s.mu.Unlock()
+	return nil
+}
+
+// remove deletes the object at key, and its cached content
+func (s *S3Store) remove(key string) error {
+	s.mu.Lock()
+	delete(s.cache, key)
+	s.mu.Unlock()
+	return s.Client.RemoveObject(context.Background(), s.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+// decodeRecord decodes the Article in the s3Record b
+func decodeRecord(b []byte) (*Article, error) {
+	var rec s3Record
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	a, err := decode(rec.Article, JSON)
+	if err != nil {
+		return nil, err
+	}
+	a.updated = rec.Updated
+	return a, nil
+}
+
+// isNotExist reports whether err means there was no such object, or Article
+func isNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	code := minio.ToErrorResponse(err).Code
+	return code == minio.NoSuchKey || os.IsNotExist(err)
+}
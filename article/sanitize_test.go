@@ -0,0 +1,82 @@
+package article
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/firegoby/gournal/snippet"
+)
+
+// the markup which must never survive from an Article's Markdown to its page
+var injected = []string{"<script", "onerror=", "onclick=", "javascript:", "<iframe", "<style"}
+
+func TestHTMLNeutralizesScript(t *testing.T) {
+	for _, body := range []string{
+		"<script>alert(1)</script>",
+		"Hello <script>alert(1)</script> world",
+		"<img src=x onerror=alert(1)>",
+		"<a href=\"#\" onclick=\"alert(1)\">click</a>",
+		"[click](javascript:alert(1))",
+		"![x](javascript:alert(1))",
+		"<iframe src=\"https://example.com\"></iframe>",
+		"<style>body { display: none }</style>",
+		"<div><script>alert(1)</script></div>\n\nText",
+		"`<script>alert(1)</script>`",
+	} {
+		a := &Article{Title: "Test", Body: body, Slug: "test"}
+		html, err := a.HTML()
+		if err != nil {
+			t.Errorf("HTML of %q: %v", body, err)
+			continue
+		}
+		for _, s := range injected {
+			if strings.Contains(strings.ToLower(string(html)), s) {
+				t.Errorf("HTML of %q = %q, which contains %q", body, html, s)
+			}
+		}
+	}
+}
+
+func TestSummaryHTMLNeutralizesScript(t *testing.T) {
+	for _, a := range []*Article{
+		{Title: "Test", Slug: "test", Excerpt: "<script>alert(1)</script> An excerpt"},
+		{Title: "Test", Slug: "test", Excerpt: "[click](javascript:alert(1))"},
+		{Title: "Test", Slug: "test", Body: "<img src=x onerror=alert(1)> " + strings.Repeat("word ", 2*ExcerptWords)},
+	} {
+		html, err := a.SummaryHTML()
+		if err != nil {
+			t.Errorf("SummaryHTML of %q: %v", a.Excerpt+a.Body, err)
+			continue
+		}
+		for _, s := range injected {
+			if strings.Contains(strings.ToLower(string(html)), s) {
+				t.Errorf("SummaryHTML of %q = %q, which contains %q", a.Excerpt+a.Body, html, s)
+			}
+		}
+	}
+}
+
+func TestValidateRejectsScriptSnippets(t *testing.T) {
+	defer func(p snippet.Policy) { SnippetPolicy = p }(SnippetPolicy)
+	SnippetPolicy = snippet.Restricted
+	for _, html := range []string{
+		"<script>alert(1)</script>",
+		`<img src="x" onerror="alert(1)">`,
+		`<link rel="stylesheet" href="javascript:alert(1)">`,
+	} {
+		a := &Article{Title: "Test", Body: "Body", Slug: "test", HeadHTML: html, FooterHTML: html}
+		err := a.Validate()
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Errorf("validating HeadHTML and FooterHTML %q: got %v, want ValidationErrors", html, err)
+			continue
+		}
+		fields := map[string]bool{}
+		for _, e := range errs {
+			fields[e.Field] = true
+		}
+		if !fields["HeadHTML"] || !fields["FooterHTML"] {
+			t.Errorf("validating HeadHTML and FooterHTML %q: got %v, want both refused", html, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package article
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SchemaVersion is the version of the layout of the JSON Articles are stored
+// in, recorded in each as it is saved, which goes up by one whenever a field
+// is added, renamed or changes type in a way older JSON wouldn't unmarshal
+// into, along with a Migration upgrading JSON from the version before.
+const SchemaVersion = 1
+
+// A Migration upgrades the fields of an Article stored as JSON, unmarshaled
+// into a map, from the version of the schema before To to To.
+type Migration struct {
+	To      int
+	Upgrade func(fields map[string]interface{}) error
+}
+
+// migrations holds the registered Migrations, indexed by To-1
+var migrations []Migration
+
+// RegisterMigration adds a Migration to the version to, which must be the
+// version after that of the last Migration registered
+func RegisterMigration(to int, upgrade func(fields map[string]interface{}) error) {
+	if to != len(migrations)+1 {
+		panic(fmt.Sprintf("article: migration to schema version %d registered out of order", to))
+	}
+	migrations = append(migrations, Migration{To: to, Upgrade: upgrade})
+}
+
+func init() {
+	// version 0, before the schema was versioned, was written by hand as often
+	// as not, with the Author and Tags as plain strings
+	RegisterMigration(1, func(fields map[string]interface{}) error {
+		if name, ok := fields["Author"].(string); ok {
+			fields["Author"] = map[string]interface{}{"Name": name}
+		}
+		if tags, ok := fields["Tags"].(string); ok {
+			var list []interface{}
+			for _, t := range strings.Split(tags, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					list = append(list, t)
+				}
+			}
+			fields["Tags"] = list
+		}
+		return nil
+	})
+}
+
+// versioned is an Article as stored in JSON, led by its SchemaVersion
+type versioned struct {
+	SchemaVersion int
+	*Article
+}
+
+// encodeJSON returns the JSON representation of a, at the current
+// SchemaVersion
+func encodeJSON(a *Article) ([]byte, error) {
+	return json.Marshal(versioned{SchemaVersion, a})
+}
+
+// decodeJSON parses an Article from its JSON representation b, first upgrading
+// it to the current SchemaVersion if it is older
+func decodeJSON(b []byte) (*Article, error) {
+	var v struct{ SchemaVersion int }
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	switch {
+	case v.SchemaVersion > SchemaVersion:
+		return nil, fmt.Errorf("article: stored by a newer version of gournal, at schema version %d", v.SchemaVersion)
+	case v.SchemaVersion < SchemaVersion:
+		var err error
+		if b, err = migrate(b, v.SchemaVersion); err != nil {
+			return nil, err
+		}
+	}
+	var a *Article
+	err := json.Unmarshal(b, &a)
+	return a, err
+}
+
+// migrate upgrades the JSON b from schema version from to the current one
+func migrate(b []byte, from int) ([]byte, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for _, m := range migrations[from:] {
+		if err := m.Upgrade(fields); err != nil {
+			return nil, fmt.Errorf("article: upgrading to schema version %d: %v", m.To, err)
+		}
+	}
+	delete(fields, "SchemaVersion")
+	return json.Marshal(fields)
+}
@@ -0,0 +1,464 @@
+package article
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// A SQLiteStore keeps Articles in a SQLite database, so listings and lookups
+// don't need to read a directory of files. Each Article is stored as JSON,
+// alongside copies of the columns it is searched and sorted by. Open one with
+// OpenSQLite.
+type SQLiteStore struct {
+	DB *sql.DB
+}
+
+// the tables of a SQLiteStore, created when it is first opened
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS articles (
+	slug    TEXT PRIMARY KEY,
+	title   TEXT NOT NULL,
+	body    TEXT NOT NULL,
+	tags    TEXT NOT NULL,
+	data    TEXT NOT NULL,
+	updated INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS articles_updated ON articles (updated);
+CREATE TABLE IF NOT EXISTS revisions (
+	slug TEXT NOT NULL,
+	id   TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (slug, id)
+);
+CREATE TABLE IF NOT EXISTS trash (
+	slug    TEXT PRIMARY KEY,
+	data    TEXT NOT NULL,
+	trashed INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS redirects (
+	slug   TEXT PRIMARY KEY,
+	target TEXT NOT NULL
+);`
+
+// OpenSQLite opens the SQLite database at path, creating it and its tables if
+// they don't exist yet. A driver registered as "sqlite", such as
+// modernc.org/sqlite, must be imported by the program.
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, so queue writes rather than fail them
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{DB: db}, nil
+}
+
+// Load implements Store
+func (s *SQLiteStore) Load(slug string) (*Article, error) {
+	return scanArticle(s.DB.QueryRow(`SELECT data, updated FROM articles WHERE slug = ?`, slug), slug)
+}
+
+// List implements Store
+func (s *SQLiteStore) List() ([]*Article, error) {
+	return queryArticles(context.Background(), s.DB, `SELECT data, updated FROM articles ORDER BY updated DESC`)
+}
+
+// Each implements Iterator, loading each Article in turn once their slugs
+// are listed, so fn may query the database too
+func (s *SQLiteStore) Each(fn func(*Article) error) error {
+	slugs, err := querySlugs(context.Background(), s.DB, `SELECT slug FROM articles ORDER BY updated DESC`)
+	if err != nil {
+		return err
+	}
+	return eachSlug(s, slugs, fn)
+}
+
+// Search implements Store
+func (s *SQLiteStore) Search(query string) ([]*Article, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	return queryArticles(context.Background(), s.DB, `SELECT data, updated FROM articles
+		WHERE title LIKE ?1 ESCAPE '\' OR body LIKE ?1 ESCAPE '\' OR tags LIKE ?1 ESCAPE '\'
+		ORDER BY updated DESC`, pattern)
+}
+
+// Create implements Store
+func (s *SQLiteStore) Create(a *Article) error {
+	data, err := encode(a, JSON)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	res, err := s.DB.Exec(`INSERT INTO articles (slug, title, body, tags, data, updated)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (slug) DO NOTHING`,
+		a.Slug, a.Title, a.Body, strings.Join(a.Tags, ","), data, now.UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSlugExists
+	}
+	a.updated = now
+	return nil
+}
+
+// Save implements Store
+func (s *SQLiteStore) Save(a *Article) error {
+	data, err := encode(a, JSON)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO revisions (slug, id, data)
+			SELECT slug, ?, data FROM articles WHERE slug = ?`,
+			now.UTC().Format(revisionLayout), a.Slug)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO articles (slug, title, body, tags, data, updated)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (slug) DO UPDATE SET title = excluded.title, body = excluded.body,
+				tags = excluded.tags, data = excluded.data, updated = excluded.updated`,
+			a.Slug, a.Title, a.Body, strings.Join(a.Tags, ","), data, now.UnixNano())
+		if err == nil {
+			a.updated = now
+		}
+		return err
+	})
+}
+
+// Delete implements Store
+func (s *SQLiteStore) Delete(slug string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`INSERT INTO trash (slug, data, trashed)
+			SELECT slug, data, ? FROM articles WHERE slug = ?
+			ON CONFLICT (slug) DO UPDATE SET data = excluded.data, trashed = excluded.trashed`,
+			time.Now().UnixNano(), slug)
+		if err := mustAffect(res, err, slug); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM articles WHERE slug = ?`, slug)
+		return err
+	})
+}
+
+// Touch implements Store
+func (s *SQLiteStore) Touch(slug string, t time.Time) error {
+	res, err := s.DB.Exec(`UPDATE articles SET updated = ? WHERE slug = ?`, t.UnixNano(), slug)
+	return mustAffect(res, err, slug)
+}
+
+// Rename implements Store
+func (s *SQLiteStore) Rename(from, to string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		var taken int
+		err := tx.QueryRow(`SELECT count(*) FROM articles WHERE slug = ?`, to).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrSlugExists
+		}
+
+		a, err := scanArticle(tx.QueryRow(`SELECT data, updated FROM articles WHERE slug = ?`, from), from)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			a.Slug = to
+			data, err := encode(a, JSON)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`UPDATE articles SET slug = ?, data = ? WHERE slug = ?`, to, data, from)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, q := range []string{
+			`UPDATE revisions SET slug = ?2 WHERE slug = ?1`,
+			// lead older redirects straight to the new slug, not along a chain
+			`UPDATE redirects SET target = ?2 WHERE target = ?1`,
+			`INSERT INTO redirects (slug, target) VALUES (?1, ?2)
+				ON CONFLICT (slug) DO UPDATE SET target = excluded.target`,
+			// the new slug is a real Article again, so it must not redirect
+			`DELETE FROM redirects WHERE slug = ?2`,
+		} {
+			if _, err := tx.Exec(q, from, to); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Redirect implements Store
+func (s *SQLiteStore) Redirect(slug string) (string, bool) {
+	var to string
+	err := s.DB.QueryRow(`SELECT target FROM redirects WHERE slug = ?`, slug).Scan(&to)
+	return to, err == nil
+}
+
+// Redirects implements Store
+func (s *SQLiteStore) Redirects() (map[string]string, error) {
+	rows, err := s.DB.Query(`SELECT slug, target FROM redirects`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	redirects := map[string]string{}
+	for rows.Next() {
+		var from, to string
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+		redirects[from] = to
+	}
+	return redirects, rows.Err()
+}
+
+// Trashed implements Store
+func (s *SQLiteStore) Trashed() ([]*Article, error) {
+	return queryArticles(context.Background(), s.DB, `SELECT data, trashed FROM trash ORDER BY trashed DESC`)
+}
+
+// Restore implements Store
+func (s *SQLiteStore) Restore(slug string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		a, err := scanArticle(tx.QueryRow(`SELECT data, trashed FROM trash WHERE slug = ?`, slug), slug)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(`INSERT INTO articles (slug, title, body, tags, data, updated)
+			SELECT slug, ?, ?, ?, data, trashed FROM trash WHERE slug = ?
+			ON CONFLICT (slug) DO NOTHING`,
+			a.Title, a.Body, strings.Join(a.Tags, ","), slug)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("article: cannot restore %q, an article with that slug already exists", slug)
+		}
+		_, err = tx.Exec(`DELETE FROM trash WHERE slug = ?`, slug)
+		return err
+	})
+}
+
+// Revisions implements Store
+func (s *SQLiteStore) Revisions(slug string) (res []Revision, err error) {
+	rows, err := s.DB.Query(`SELECT id FROM revisions WHERE slug = ? ORDER BY id DESC`, slug)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(revisionLayout, id)
+		if err != nil {
+			continue
+		}
+		res = append(res, Revision{ID: id, Time: t})
+	}
+	return res, rows.Err()
+}
+
+// LoadRevision implements Store
+func (s *SQLiteStore) LoadRevision(slug, id string) (*Article, error) {
+	var data string
+	err := s.DB.QueryRow(`SELECT data FROM revisions WHERE slug = ? AND id = ?`, slug, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, notFound(slug + "/" + id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode([]byte(data), JSON)
+}
+
+// Import implements Importer. It is used to migrate an existing site, e.g.
+// from a FileStore, and to bring it up to date again.
+func (s *SQLiteStore) Import(src Store) (*ImportReport, error) {
+	p, err := planImport(s, src)
+	if err != nil {
+		return nil, err
+	}
+	trashed, err := src.Trashed()
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := src.Redirects()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.inTx(func(tx *sql.Tx) error {
+		for _, it := range p.create {
+			data, err := encode(it.Article, JSON)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO articles (slug, title, body, tags, data, updated)
+				VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (slug) DO NOTHING`,
+				it.Slug, it.Title, it.Body, strings.Join(it.Tags, ","), data, it.updated.UnixNano())
+			if err != nil {
+				return err
+			}
+		}
+		for _, it := range append(p.create, p.update...) {
+			err := importRevisions(src, it.from, func(id string, old *Article, _ time.Time) error {
+				data, err := encode(old, JSON)
+				if err != nil {
+					return err
+				}
+				_, err = tx.Exec(`INSERT INTO revisions (slug, id, data) VALUES (?, ?, ?)
+					ON CONFLICT (slug, id) DO NOTHING`, it.Slug, id, data)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, a := range trashed {
+			data, err := encode(a, JSON)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO trash (slug, data, trashed) VALUES (?, ?, ?)
+				ON CONFLICT (slug) DO NOTHING`, a.Slug, data, a.updated.UnixNano())
+			if err != nil {
+				return err
+			}
+		}
+		for from, to := range redirects {
+			_, err := tx.Exec(`INSERT INTO redirects (slug, target) VALUES (?, ?)
+				ON CONFLICT (slug) DO NOTHING`, from, to)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p.report(), p.saveUpdates(s)
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.DB.Close()
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds and rolling it
+// back otherwise
+func (s *SQLiteStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// A sqlQuerier is a database or transaction which can be queried
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryArticles returns the Articles selected by query, whose rows must hold
+// an Article's JSON and its updated time
+func queryArticles(ctx context.Context, db sqlQuerier, query string, args ...interface{}) (res []*Article, err error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanArticle(rows, "")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
+
+// querySlugs returns the slugs selected by query
+func querySlugs(ctx context.Context, db sqlQuerier, query string, args ...interface{}) (res []string, err error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		res = append(res, slug)
+	}
+	return res, rows.Err()
+}
+
+// scanArticle decodes the Article in row, which must hold an Article's JSON
+// and its updated time, returning a not found error for slug if there is none
+func scanArticle(row interface{ Scan(...interface{}) error }, slug string) (*Article, error) {
+	var (
+		data    string
+		updated int64
+	)
+	err := row.Scan(&data, &updated)
+	if err == sql.ErrNoRows {
+		return nil, notFound(slug)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a, err := decode([]byte(data), JSON)
+	if err != nil {
+		return nil, err
+	}
+	a.updated = time.Unix(0, updated)
+	return a, nil
+}
+
+// mustAffect returns err, or a not found error for slug if the statement res
+// came from changed no rows
+func mustAffect(res sql.Result, err error, slug string) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound(slug)
+	}
+	return nil
+}
+
+// notFound returns an error satisfying os.IsNotExist for the Article slug
+func notFound(slug string) error {
+	return &os.PathError{Op: "load", Path: slug, Err: os.ErrNotExist}
+}
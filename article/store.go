@@ -0,0 +1,67 @@
+package article
+
+import (
+	"log/slog"
+	"time"
+)
+
+// A Store persists Articles, along with the prior revisions of each, the trash
+// and the redirects left behind when Articles are renamed. Methods which look
+// up a single Article return an error satisfying os.IsNotExist when there is
+// no such Article.
+type Store interface {
+	// Load returns the Article identified by slug
+	Load(slug string) (*Article, error)
+	// List returns every stored Article, most recently updated first
+	List() ([]*Article, error)
+	// Search returns the stored Articles whose title, body or tags contain
+	// query, ignoring case, most recently updated first
+	Search(query string) ([]*Article, error)
+
+	// Create stores a new Article, returning ErrSlugExists rather than
+	// overwriting an Article already stored with the same slug
+	Create(a *Article) error
+	// Save overwrites a stored Article, first keeping its previous version as
+	// a Revision
+	Save(a *Article) error
+	// Delete moves the Article identified by slug into the trash
+	Delete(slug string) error
+	// Touch sets the time the Article identified by slug was last updated,
+	// which orders List
+	Touch(slug string, t time.Time) error
+
+	// Rename moves the Article identified by from, and its revisions, to the
+	// slug to, returning ErrSlugExists if to is taken, and records a redirect
+	Rename(from, to string) error
+	// Redirect returns the slug an Article which was renamed away from slug
+	// can now be found at, reporting false if there is no such Article
+	Redirect(slug string) (string, bool)
+	// Redirects returns every renamed slug mapped to the slug it now redirects
+	// to
+	Redirects() (map[string]string, error)
+
+	// Trashed returns every Article in the trash, most recently trashed first
+	Trashed() ([]*Article, error)
+	// Restore moves the Article identified by slug out of the trash, refusing
+	// to overwrite an Article since stored with the same slug
+	Restore(slug string) error
+
+	// Revisions returns the prior versions of the Article identified by slug,
+	// newest first
+	Revisions(slug string) ([]Revision, error)
+	// LoadRevision returns the prior version id of the Article identified by
+	// slug
+	LoadRevision(slug, id string) (*Article, error)
+}
+
+// DefaultStore is the Store every Article is loaded from and saved to
+var DefaultStore Store = NewCache(&FileStore{Dir: Dir})
+
+// logTo returns l, one of the Stores' Loggers, or slog's default Logger if it
+// is nil
+func logTo(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
@@ -0,0 +1,117 @@
+package article
+
+import "time"
+
+// A TimedStore is a Store passing how long each operation on another Store
+// took to Observe, named for the method, e.g. "load" or "save", along with the
+// error it returned, e.g. to graph them. Redirects aren't timed, as they're
+// looked up on every request for a missing page.
+type TimedStore struct {
+	Store
+	Observe func(op string, d time.Duration, err error)
+}
+
+// time passes the time since start, and the error err points to, to Observe,
+// as op; it is deferred by the operations
+func (s *TimedStore) time(op string, start time.Time, err *error) {
+	s.Observe(op, time.Since(start), *err)
+}
+
+// Load implements Store
+func (s *TimedStore) Load(slug string) (a *Article, err error) {
+	defer s.time("load", time.Now(), &err)
+	return s.Store.Load(slug)
+}
+
+// List implements Store
+func (s *TimedStore) List() (res []*Article, err error) {
+	defer s.time("list", time.Now(), &err)
+	return s.Store.List()
+}
+
+// Search implements Store
+func (s *TimedStore) Search(query string) (res []*Article, err error) {
+	defer s.time("search", time.Now(), &err)
+	return s.Store.Search(query)
+}
+
+// Index implements Indexer, using the underlying Store's Index if it is an
+// Indexer, or else listing every Article
+func (s *TimedStore) Index() (res []*Entry, err error) {
+	defer s.time("index", time.Now(), &err)
+	if ix, ok := s.Store.(Indexer); ok {
+		return ix.Index()
+	}
+	articles, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	return entries(articles), nil
+}
+
+// Each implements Iterator, untimed, as fn takes part of the time
+func (s *TimedStore) Each(fn func(*Article) error) error {
+	return each(s.Store, fn)
+}
+
+// Create implements Store
+func (s *TimedStore) Create(a *Article) (err error) {
+	defer s.time("create", time.Now(), &err)
+	return s.Store.Create(a)
+}
+
+// Save implements Store
+func (s *TimedStore) Save(a *Article) (err error) {
+	defer s.time("save", time.Now(), &err)
+	return s.Store.Save(a)
+}
+
+// Delete implements Store
+func (s *TimedStore) Delete(slug string) (err error) {
+	defer s.time("delete", time.Now(), &err)
+	return s.Store.Delete(slug)
+}
+
+// Touch implements Store
+func (s *TimedStore) Touch(slug string, t time.Time) (err error) {
+	defer s.time("touch", time.Now(), &err)
+	return s.Store.Touch(slug, t)
+}
+
+// Rename implements Store
+func (s *TimedStore) Rename(from, to string) (err error) {
+	defer s.time("rename", time.Now(), &err)
+	return s.Store.Rename(from, to)
+}
+
+// Trashed implements Store
+func (s *TimedStore) Trashed() (res []*Article, err error) {
+	defer s.time("trashed", time.Now(), &err)
+	return s.Store.Trashed()
+}
+
+// Restore implements Store
+func (s *TimedStore) Restore(slug string) (err error) {
+	defer s.time("restore", time.Now(), &err)
+	return s.Store.Restore(slug)
+}
+
+// Revisions implements Store
+func (s *TimedStore) Revisions(slug string) (res []Revision, err error) {
+	defer s.time("revisions", time.Now(), &err)
+	return s.Store.Revisions(slug)
+}
+
+// LoadRevision implements Store
+func (s *TimedStore) LoadRevision(slug, id string) (a *Article, err error) {
+	defer s.time("load_revision", time.Now(), &err)
+	return s.Store.LoadRevision(slug, id)
+}
+
+// Close closes the underlying Store, if it needs closing
+func (s *TimedStore) Close() error {
+	if c, ok := s.Store.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
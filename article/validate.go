@@ -0,0 +1,158 @@
+package article
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/firegoby/gournal/snippet"
+)
+
+// Limits on the length, in characters, of an Article's fields
+const (
+	MaxTitleLength       = 200
+	MaxSlugLength        = 100
+	MaxBodyLength        = 200000
+	MaxExcerptLength     = 1000
+	MaxDescriptionLength = 300
+	MaxTags              = 20
+	MaxTagLength         = 50
+	MaxMeta              = 20
+	MaxMetaKeyLength     = 50
+	MaxMetaValueLength   = 1000
+	MaxSnippetLength     = 10000
+)
+
+// SnippetPolicy decides which HTML may be injected into an Article's page
+// through its HeadHTML and FooterHTML
+var SnippetPolicy = snippet.Restricted
+
+// A FieldError describes a problem with a single field of an Article.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationErrors lists every problem found by Validate.
+type ValidationErrors []FieldError
+
+// Error implements the error interface, joining every field's message
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Field + " " + e.Message
+	}
+	return "article: " + strings.Join(msgs, ", ")
+}
+
+// Get returns the message for the first problem with field, or an empty string
+// if the field is valid, for displaying next to form inputs
+func (v ValidationErrors) Get(field string) string {
+	for _, e := range v {
+		if e.Field == field {
+			return e.Message
+		}
+	}
+	return ""
+}
+
+// Validate checks an Article is fit to be saved, returning ValidationErrors
+// describing every problem found, or nil if there are none
+func (a *Article) Validate() error {
+	var errs ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{field, fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case strings.TrimSpace(a.Title) == "":
+		add("Title", "can't be empty")
+	case utf8.RuneCountInString(a.Title) > MaxTitleLength:
+		add("Title", "must be at most %d characters", MaxTitleLength)
+	}
+
+	switch {
+	case a.Slug == "" && strings.TrimSpace(a.Title) == "":
+		// reported as a missing title
+	case !ValidSlug(a.Slug):
+		add("Slug", "may only contain lowercase letters, digits and single hyphens")
+	case len(a.Slug) > MaxSlugLength:
+		add("Slug", "must be at most %d characters", MaxSlugLength)
+	}
+
+	if a.ID != "" && !ValidID(a.ID) {
+		add("ID", "must be a ULID, such as %s", NewID(time.Now()))
+	}
+
+	if utf8.RuneCountInString(a.Body) > MaxBodyLength {
+		add("Body", "must be at most %d characters", MaxBodyLength)
+	}
+	if utf8.RuneCountInString(a.Excerpt) > MaxExcerptLength {
+		add("Excerpt", "must be at most %d characters", MaxExcerptLength)
+	}
+	if utf8.RuneCountInString(a.Description) > MaxDescriptionLength {
+		add("Description", "must be at most %d characters", MaxDescriptionLength)
+	}
+
+	if len(a.Tags) > MaxTags {
+		add("Tags", "may have at most %d tags", MaxTags)
+	}
+	for _, t := range a.Tags {
+		if utf8.RuneCountInString(t) > MaxTagLength {
+			add("Tags", "must each be at most %d characters", MaxTagLength)
+			break
+		}
+	}
+
+	if len(a.Meta) > MaxMeta {
+		add("Meta", "may have at most %d entries", MaxMeta)
+	}
+	for k, v := range a.Meta {
+		if strings.TrimSpace(k) == "" {
+			add("Meta", "names can't be empty")
+			break
+		}
+		if utf8.RuneCountInString(k) > MaxMetaKeyLength {
+			add("Meta", "names must each be at most %d characters", MaxMetaKeyLength)
+			break
+		}
+		if utf8.RuneCountInString(v) > MaxMetaValueLength {
+			add("Meta", "values must each be at most %d characters", MaxMetaValueLength)
+			break
+		}
+	}
+
+	for _, f := range []struct{ name, html string }{{"HeadHTML", a.HeadHTML}, {"FooterHTML", a.FooterHTML}} {
+		if utf8.RuneCountInString(f.html) > MaxSnippetLength {
+			add(f.name, "must be at most %d characters", MaxSnippetLength)
+		} else if err := SnippetPolicy.Check(f.html); err != nil {
+			add(f.name, "%s", err)
+		}
+	}
+
+	switch a.Visibility {
+	case "", Public, Unlisted:
+	default:
+		add("Visibility", "must be public or unlisted")
+	}
+
+	if a.Author.Email != "" {
+		if _, err := mail.ParseAddress(a.Author.Email); err != nil {
+			add("AuthorEmail", "isn't a valid email address")
+		}
+	}
+	if a.Author.URL != "" {
+		u, err := url.Parse(a.Author.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("AuthorURL", "must be an http:// or https:// address")
+		}
+	}
+
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Package assist asks an OpenAI-compatible chat completions endpoint for
+// suggested metadata for an article draft. Suggestions are only ever offered
+// to the author, gournal never saves them without confirmation.
+package assist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A Suggestion holds the generated metadata for a draft.
+type Suggestion struct {
+	Excerpt     string
+	Description string
+	Tags        []string
+}
+
+// A Client talks to an OpenAI-compatible API, e.g. "https://api.openai.com/v1"
+// or a local server exposing the same /chat/completions endpoint.
+type Client struct {
+	URL   string
+	Key   string
+	Model string
+	HTTP  *http.Client
+}
+
+// prompt instructs the model to respond with a Suggestion as JSON
+const prompt = `You help a blogger prepare an article for publishing. Reply with
+only a JSON object with the keys "excerpt" (a one or two sentence summary),
+"description" (an SEO meta description under 160 characters) and "tags" (an
+array of at most 5 short lowercase tags).`
+
+// Suggest generates a Suggestion for the draft with title and body
+func (c *Client) Suggest(title, body string) (*Suggestion, error) {
+	req := map[string]interface{}{
+		"model": c.Model,
+		"messages": []map[string]string{
+			{"role": "system", "content": prompt},
+			{"role": "user", "content": "Title: " + title + "\n\n" + body},
+		},
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequest("POST", strings.TrimRight(c.URL, "/")+"/chat/completions", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if c.Key != "" {
+		r.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("assist: endpoint responded %s", resp.Status)
+	}
+
+	var res struct {
+		Choices []struct {
+			Message struct{ Content string }
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Choices) == 0 {
+		return nil, errors.New("assist: endpoint returned no choices")
+	}
+	return parse(res.Choices[0].Message.Content)
+}
+
+// parse extracts a Suggestion from the model's reply, tolerating any prose or
+// code fences around the JSON object
+func parse(content string) (*Suggestion, error) {
+	start, end := strings.Index(content, "{"), strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("assist: reply contained no JSON object")
+	}
+	var s struct {
+		Excerpt     string   `json:"excerpt"`
+		Description string   `json:"description"`
+		Tags        []string `json:"tags"`
+	}
+	err := json.Unmarshal([]byte(content[start:end+1]), &s)
+	if err != nil {
+		return nil, fmt.Errorf("assist: malformed reply: %v", err)
+	}
+	return &Suggestion{Excerpt: s.Excerpt, Description: s.Description, Tags: s.Tags}, nil
+}
@@ -0,0 +1,74 @@
+// Package breadcrumb builds the trail of links from the home page to the
+// current page, for display and as schema.org BreadcrumbList JSON-LD so search
+// engines can show it alongside results.
+package breadcrumb
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"strings"
+)
+
+// A Crumb is a single page along a Trail.
+type Crumb struct {
+	Name string
+	Path string
+}
+
+// A Trail leads from the home page to the current page, which is its last
+// Crumb.
+type Trail struct {
+	Base   string
+	Crumbs []Crumb
+}
+
+// New returns a Trail starting at the home page, named home, followed by a
+// Crumb for each name and path pair in crumbs. Base is the absolute URL of the
+// site which paths are relative to, e.g. "https://example.com".
+func New(base, home string, crumbs ...string) (*Trail, error) {
+	if len(crumbs)%2 != 0 {
+		return nil, errors.New("breadcrumb: crumbs must be name and path pairs")
+	}
+	t := &Trail{Base: strings.TrimSuffix(base, "/"), Crumbs: []Crumb{{home, "/"}}}
+	for i := 0; i < len(crumbs); i += 2 {
+		t.Crumbs = append(t.Crumbs, Crumb{crumbs[i], crumbs[i+1]})
+	}
+	return t, nil
+}
+
+// Links returns every Crumb but the last, i.e. the pages which lead to the
+// current one
+func (t *Trail) Links() []Crumb {
+	return t.Crumbs[:len(t.Crumbs)-1]
+}
+
+// Current returns the last Crumb, i.e. the current page
+func (t *Trail) Current() Crumb {
+	return t.Crumbs[len(t.Crumbs)-1]
+}
+
+// JSONLD returns the Trail as a schema.org BreadcrumbList, for use within a
+// <script type="application/ld+json"> element
+func (t *Trail) JSONLD() (template.JS, error) {
+	type listItem struct {
+		Type     string `json:"@type"`
+		Position int    `json:"position"`
+		Name     string `json:"name"`
+		Item     string `json:"item"`
+	}
+	items := make([]listItem, len(t.Crumbs))
+	for i, c := range t.Crumbs {
+		items[i] = listItem{"ListItem", i + 1, c.Name, t.Base + c.Path}
+	}
+	b, err := json.Marshal(struct {
+		Context string     `json:"@context"`
+		Type    string     `json:"@type"`
+		Items   []listItem `json:"itemListElement"`
+	}{"https://schema.org", "BreadcrumbList", items})
+	if err != nil {
+		return "", err
+	}
+	// json.Marshal escapes <, > and &, so the JSON can't close the script
+	return template.JS(b), nil
+}
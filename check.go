@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/firegoby/gournal/article"
+)
+
+// runCheck implements the `gournal check` command, checking the integrity of
+// the article files in article.Dir and exiting non-zero if any are broken
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	problems, err := article.Check()
+	if err != nil {
+		fatal("check: checking the articles", "error", err)
+	}
+
+	switch *format {
+	case "json":
+		if problems == nil {
+			problems = []article.Problem{}
+		}
+		b, err := json.MarshalIndent(problems, "", "  ")
+		if err != nil {
+			fatal("check: encoding the problems", "error", err)
+		}
+		fmt.Println(string(b))
+	case "text":
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+	default:
+		fatal("check: unknown format", "format", *format)
+	}
+
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/firegoby/gournal/article"
+	"github.com/firegoby/gournal/config"
+)
+
+// runCompact implements the `gournal compact` command, packing the articles
+// not updated for -years into cold storage, see article.FileStore.Compact
+func runCompact(args []string) {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	years := fs.Int("years", 5, "pack articles not updated for this many years")
+	fs.Parse(args)
+	if *years < 1 {
+		fatal("compact: -years must be at least 1")
+	}
+
+	cfg, err := config.Load()
+	if err != nil && !os.IsNotExist(err) {
+		fatal("compact: loading the configuration", "config", config.File, "error", err)
+	}
+	if cfg != nil && cfg.Storage != "" && cfg.Storage != "file" {
+		fatal("compact: only articles kept in files can be packed", "storage", cfg.Storage)
+	}
+
+	store := &article.FileStore{Dir: article.Dir}
+	n, err := store.Compact(time.Now().AddDate(-*years, 0, 0))
+	if err != nil {
+		fatal("compact: packing articles", "error", err)
+	}
+	fmt.Printf("Packed %d articles into cold storage\n", n)
+}
@@ -0,0 +1,349 @@
+// Package config holds the site-wide settings for gournal, stored as JSON on
+// disk and created by the first-run setup wizard.
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A Config contains the site-wide settings and the admin account, grouped by
+// the feature they configure. Settings left empty take the defaults noted.
+type Config struct {
+	SiteTitle string
+	BaseURL   string
+
+	// Storage names the backend articles are kept in: "file", "git" to commit
+	// every change to the files to a git repository, pushed to GitRemote if
+	// set, "sqlite" in the database at SQLitePath (default "./gournal.db"),
+	// "bolt" in the bbolt database at BoltPath (default "./gournal.bolt"),
+	// "postgres" in the PostgreSQL database at PostgresURL, pooling up to
+	// PostgresMaxConns connections (default 10), so several instances can
+	// share it, or "s3" beneath S3Prefix in S3Bucket of the S3-compatible
+	// object storage at S3Endpoint (see article.S3Options), e.g. for
+	// containers without a persistent disk.
+	Storage          string
+	GitRemote        string `json:",omitempty"`
+	SQLitePath       string `json:",omitempty"`
+	BoltPath         string `json:",omitempty"`
+	PostgresURL      string `json:",omitempty"`
+	PostgresMaxConns int    `json:",omitempty"`
+	S3Endpoint       string `json:",omitempty"`
+	S3Region         string `json:",omitempty"`
+	S3Bucket         string `json:",omitempty"`
+	S3Prefix         string `json:",omitempty"`
+	S3AccessKey      string `json:",omitempty"`
+	S3SecretKey      string `json:",omitempty"`
+	S3Insecure       bool   `json:",omitempty"`
+	// StaleCache has pages go on listing the articles as they were, for the
+	// moment it takes to list them again in the background after a change,
+	// rather than every request listing them itself (see article.Cache)
+	StaleCache bool `json:",omitempty"`
+	// SearchIndex optionally names the directory of a full-text index ranking
+	// search results (see article.BleveStore), which isn't used with the
+	// "postgres" and "s3" backends
+	SearchIndex string `json:",omitempty"`
+
+	// Permalinks is "slug" (the default) for permalinks such as
+	// /articles/weeknotes, de-duplicated with numeric suffixes, or "date" for
+	// permalinks scoped to the month, such as /2024/05/weeknotes
+	Permalinks string `json:",omitempty"`
+	// Format optionally names the article.Format new articles are saved in by
+	// the "file" and "git" backends
+	Format string `json:",omitempty"`
+
+	Admin User
+	// Secret signs the cookies gournal sets
+	Secret []byte
+
+	// SpellCheckURL optionally points at a LanguageTool server used to check
+	// drafts from the editor, in SpellCheckLanguage (default "auto")
+	SpellCheckURL      string `json:",omitempty"`
+	SpellCheckLanguage string `json:",omitempty"`
+	// AssistURL optionally points at an OpenAI-compatible API used to suggest
+	// metadata with AssistModel and, with an EmbeddingModel, to rank related
+	// articles, authenticating with AssistKey
+	AssistURL      string `json:",omitempty"`
+	AssistKey      string `json:",omitempty"`
+	AssistModel    string `json:",omitempty"`
+	EmbeddingModel string `json:",omitempty"`
+
+	// HeadHTML and FooterHTML are injected into every page, and SnippetPolicy
+	// names the snippet.Policy applied to them and to the HTML articles inject
+	// (default "restricted")
+	HeadHTML      string `json:",omitempty"`
+	FooterHTML    string `json:",omitempty"`
+	SnippetPolicy string `json:",omitempty"`
+
+	// Glossary names the glossary.Mode terms from the site's glossary are
+	// marked up in articles with (default "off")
+	Glossary string `json:",omitempty"`
+	// Typography optionally names the typography.Locale whose quotes, dashes
+	// and spacing articles are typeset with, e.g. "en" or "fr"
+	Typography string `json:",omitempty"`
+	// ReadingProgress offers readers of long articles, who consent to its
+	// cookie, a link to continue where they left off
+	ReadingProgress bool `json:",omitempty"`
+	// Annotations names the annotation.Mode readers may annotate articles in
+	// (default "off"), which an article's "annotations" meta field overrides
+	Annotations string `json:",omitempty"`
+
+	// ContactEmail enables the /contact page, whose messages are archived
+	// and, with an SMTPAddr (host:port) and SMTPFrom, emailed to it,
+	// authenticating as SMTPUsername if set
+	ContactEmail string `json:",omitempty"`
+	SMTPAddr     string `json:",omitempty"`
+	SMTPUsername string `json:",omitempty"`
+	SMTPPassword string `json:",omitempty"`
+	SMTPFrom     string `json:",omitempty"`
+
+	// GoogleVerification and BingVerification hold the codes Google Search
+	// Console and Bing Webmaster Tools verify ownership of the site with
+	GoogleVerification string `json:",omitempty"`
+	BingVerification   string `json:",omitempty"`
+
+	// PIDFile optionally records the PID of the process serving, which
+	// changes when gournal upgrades itself on SIGHUP
+	PIDFile string `json:",omitempty"`
+	// ErrorReportDSN optionally points at a Sentry-compatible error tracker
+	// the panics gournal recovers from are reported to
+	ErrorReportDSN string `json:",omitempty"`
+	// LogFormat is "text" (the default) or "json", for the records gournal
+	// logs to stderr, and LogLevel the least severe logged: "debug", "info"
+	// (the default), "warn" or "error"
+	LogFormat string `json:",omitempty"`
+	LogLevel  string `json:",omitempty"`
+
+	// EarlyHints sends the Link headers preloading the theme's critical
+	// assets (see theme.Manifest) ahead of pages in 103 Early Hints responses
+	EarlyHints bool `json:",omitempty"`
+	// MinifyHTML strips comments and surplus whitespace from pages
+	MinifyHTML bool `json:",omitempty"`
+
+	// TLSCertFile and TLSKeyFile hold the PEM certificate and key to serve
+	// HTTPS with or, failing those, AutocertHosts lists the hostnames to
+	// obtain certificates for from Let's Encrypt, registering AutocertEmail if
+	// set, kept in AutocertCache (default "./autocert")
+	TLSCertFile   string   `json:",omitempty"`
+	TLSKeyFile    string   `json:",omitempty"`
+	AutocertHosts []string `json:",omitempty"`
+	AutocertEmail string   `json:",omitempty"`
+	AutocertCache string   `json:",omitempty"`
+	// RedirectAddr optionally serves plain HTTP alongside HTTPS, e.g. on
+	// ":80", redirecting every request to HTTPS
+	RedirectAddr string `json:",omitempty"`
+
+	// WriteBurst and WritesPerMinute limit how many requests to make changes,
+	// such as creating articles, each IP address may send at once, and then
+	// each minute (default 20 and 10), bar the admin's
+	WriteBurst      int `json:",omitempty"`
+	WritesPerMinute int `json:",omitempty"`
+	// IPRetentionDays optionally limits how many days contact messages keep
+	// their senders' IP addresses, after which they're anonymised, and then
+	// article revisions only record the anonymised addresses of their editors
+	IPRetentionDays int `json:",omitempty"`
+
+	// ArchiveHours optionally has the site archived every so many hours, as a
+	// WARC file of every page and asset, e.g. for submission to web archives,
+	// kept in ArchiveDir (default "./archives")
+	ArchiveHours int    `json:",omitempty"`
+	ArchiveDir   string `json:",omitempty"`
+
+	// Theme holds the values of the theme's options (see theme.Option), keyed
+	// by name, as set from /admin/settings
+	Theme map[string]string `json:",omitempty"`
+	// Profiles holds named sets of settings, e.g. "dev" or "staging",
+	// overriding those above when selected with Profile
+	Profiles map[string]json.RawMessage `json:",omitempty"`
+
+	// the settings read from File, before the Profile's overrides
+	base map[string]json.RawMessage
+}
+
+// A User is an account able to manage the site.
+type User struct {
+	Username     string
+	PasswordHash []byte
+}
+
+// File is the location on disk of the JSON representation of the Config
+var File = "./gournal.json"
+
+// ProfileEnv names the environment variable selecting the Profile
+const ProfileEnv = "GOURNAL_PROFILE"
+
+// Profile names the profile in the Config's Profiles whose settings Load
+// applies, initially taken from the environment variable ProfileEnv, or none
+// if empty
+var Profile = os.Getenv(ProfileEnv)
+
+// Storages lists the storage backends selectable by the setup wizard
+var Storages = []string{"file", "git", "sqlite", "bolt", "postgres", "s3"}
+
+// Default returns the Config used before setup has been completed
+func Default() *Config {
+	secret, err := NewSecret()
+	if err != nil {
+		panic(err)
+	}
+	return &Config{SiteTitle: "Gournal", Storage: "file", Secret: secret}
+}
+
+// NewSecret returns a random key suitable for Config.Secret
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+// Load attempts to read the Config from File, with the settings of the
+// Profile applied over it, returning the error if one occurs. Use os.IsNotExist
+// on the error to detect a first run.
+func Load() (c *Config, err error) {
+	b, err := ioutil.ReadFile(File)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, err
+	}
+	if Profile == "" {
+		return c, nil
+	}
+	overrides, ok := c.Profiles[Profile]
+	if !ok {
+		return nil, fmt.Errorf("config: there is no profile %q in %s", Profile, File)
+	}
+	if err := json.Unmarshal(b, &c.base); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(overrides))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("config: profile %q in %s: %v", Profile, File, err)
+	}
+	return c, nil
+}
+
+// Exists reports whether a Config has already been written to File
+func Exists() bool {
+	_, err := os.Stat(File)
+	return err == nil
+}
+
+// Save stores a JSON representation of the Config in File. Settings the
+// Profile overrides are stored as they were read, unless they've changed
+// since, so the Profile's settings don't leak into every other.
+func (c *Config) Save() error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if c.base != nil {
+		if b, err = c.unapplyProfile(b); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "    "); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(File, buf.Bytes(), 0600)
+}
+
+// unapplyProfile returns the Config, encoded as JSON in b, with the settings
+// still as the Profile set them restored to those read from File
+func (c *Config) unapplyProfile(b []byte) ([]byte, error) {
+	var overrides map[string]json.RawMessage
+	if err := json.Unmarshal(c.Profiles[Profile], &overrides); err != nil {
+		return nil, err
+	}
+
+	// rewrite the fields in turn, keeping them in the order of the Config's
+	seen := map[string]bool{}
+	var buf bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('{')
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name := t.(string)
+		seen[name] = true
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if override, ok := overrides[name]; ok && sameJSON(value, override) {
+			base, ok := c.base[name]
+			if !ok {
+				continue
+			}
+			value = base
+		}
+		writeField(&buf, name, value)
+	}
+	// settings the Profile empties are left out, as empty, so restore them
+	for name, override := range overrides {
+		if base, ok := c.base[name]; ok && !seen[name] && emptyJSON(override) {
+			writeField(&buf, name, base)
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeField adds the field name, holding value, to the JSON object being
+// written to buf
+func writeField(buf *bytes.Buffer, name string, value json.RawMessage) {
+	if buf.Len() > 1 {
+		buf.WriteByte(',')
+	}
+	key, _ := json.Marshal(name)
+	buf.Write(key)
+	buf.WriteByte(':')
+	buf.Write(value)
+}
+
+// emptyJSON reports whether v encodes an empty value, left out of fields
+// marked omitempty
+func emptyJSON(v json.RawMessage) bool {
+	for _, empty := range []string{`""`, "0", "false", "null", "[]", "{}"} {
+		if sameJSON(v, json.RawMessage(empty)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameJSON reports whether a and b encode the same value
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// SetPassword stores a bcrypt hash of password for the User
+func (u *User) SetPassword(password string) (err error) {
+	u.PasswordHash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return
+}
+
+// CheckPassword reports whether password matches the User's stored hash
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)) == nil
+}
@@ -0,0 +1,119 @@
+// Package consent is a registry of the gournal features which set cookies.
+// Features declare themselves with Register, and a consent banner is only
+// needed when a registered feature's cookies aren't strictly necessary, so a
+// default gournal stays cookieless and banner-free.
+package consent
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Feature is a part of gournal which sets cookies. Necessary features (e.g.
+// CSRF protection) don't require consent, any other feature may only set its
+// cookies once the visitor has accepted it.
+type Feature struct {
+	Name      string
+	Purpose   string
+	Cookies   []string
+	Necessary bool
+}
+
+// the name of the cookie recording the features a visitor has accepted
+const Cookie = "gournal_consent"
+
+var registry = struct {
+	sync.RWMutex
+	features map[string]Feature
+}{features: map[string]Feature{}}
+
+// Register declares a cookie-setting Feature, replacing any Feature already
+// registered with the same name
+func Register(f Feature) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.features[f.Name] = f
+}
+
+// Features returns every registered Feature, sorted by name
+func Features() []Feature {
+	registry.RLock()
+	defer registry.RUnlock()
+	res := make([]Feature, 0, len(registry.features))
+	for _, f := range registry.features {
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Optional returns the registered Features which require consent
+func Optional() (res []Feature) {
+	for _, f := range Features() {
+		if !f.Necessary {
+			res = append(res, f)
+		}
+	}
+	return
+}
+
+// Required reports whether any registered Feature requires consent, i.e.
+// whether a consent banner needs to be offered at all
+func Required() bool {
+	return len(Optional()) > 0
+}
+
+// Given reports whether the visitor making r has accepted the Feature name.
+// Necessary features are always allowed.
+func Given(r *http.Request, name string) bool {
+	registry.RLock()
+	f, ok := registry.features[name]
+	registry.RUnlock()
+	if ok && f.Necessary {
+		return true
+	}
+	c, err := r.Cookie(Cookie)
+	if err != nil {
+		return false
+	}
+	for _, accepted := range strings.Split(c.Value, ".") {
+		if accepted == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler records the visitor's choice from the consent banner form, which
+// posts the accepted feature names as "accept" values, then redirects back
+func Handler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var accepted []string
+	for _, name := range r.Form["accept"] {
+		for _, f := range Optional() {
+			if f.Name == name {
+				accepted = append(accepted, name)
+			}
+		}
+	}
+	// an empty value still records that the visitor has made a choice, and
+	// the banner's script reads the cookie so it isn't HttpOnly
+	http.SetCookie(w, &http.Cookie{
+		Name:     Cookie,
+		Value:    strings.Join(accepted, "."),
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// only ever redirect back to a page on this site
+	back := "/"
+	if u, err := url.Parse(r.Referer()); err == nil && strings.HasPrefix(u.Path, "/") {
+		back = u.Path
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
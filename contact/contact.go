@@ -0,0 +1,277 @@
+// Package contact keeps the messages visitors send through the contact form,
+// and screens them for spam before they are delivered.
+package contact
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// the limits, in characters, on the parts of a Message
+const (
+	MaxNameLength    = 100
+	MaxSubjectLength = 200
+	MaxBodyLength    = 5000
+)
+
+// the number of links in a Message's Body above which it is treated as spam
+const MaxLinks = 3
+
+// the least time a person takes to fill in the contact form; bots are quicker
+const MinFillTime = 3 * time.Second
+
+// A Message is sent by a visitor, Name, through the contact form, for the site
+// admin to reply to at Email.
+type Message struct {
+	ID       string
+	Name     string
+	Email    string
+	Subject  string `json:",omitempty"`
+	Body     string
+	IP       string `json:",omitempty"`
+	Received time.Time
+	// why the Message was screened out as spam, if it was
+	Spam string `json:",omitempty"`
+	// whether the Message was emailed to the site admin, or why it wasn't
+	Delivered bool   `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// Validate returns a problem with the Message a visitor should correct before
+// sending it, or an empty string if it is fine
+func (m *Message) Validate() string {
+	m.Name = strings.TrimSpace(m.Name)
+	m.Email = strings.TrimSpace(m.Email)
+	m.Subject = strings.Join(strings.Fields(m.Subject), " ")
+	m.Body = strings.TrimSpace(m.Body)
+	switch {
+	case m.Name == "":
+		return "Please give your name"
+	case utf8.RuneCountInString(m.Name) > MaxNameLength:
+		return fmt.Sprintf("Your name may be at most %d characters", MaxNameLength)
+	case m.Email == "":
+		return "Please give an email address to reply to"
+	case !validEmail(m.Email):
+		return "That doesn't look like an email address"
+	case utf8.RuneCountInString(m.Subject) > MaxSubjectLength:
+		return fmt.Sprintf("The subject may be at most %d characters", MaxSubjectLength)
+	case m.Body == "":
+		return "Please write a message"
+	case utf8.RuneCountInString(m.Body) > MaxBodyLength:
+		return fmt.Sprintf("The message may be at most %d characters", MaxBodyLength)
+	}
+	return ""
+}
+
+// validEmail reports whether s is a bare email address, without a display
+// name or anything which could inject headers
+func validEmail(s string) bool {
+	a, err := mail.ParseAddress(s)
+	return err == nil && a.Address == s && !strings.ContainsAny(s, "\r\n")
+}
+
+// link matches a URL, or HTML or BBCode link markup, in a Message's Body
+var link = regexp.MustCompile(`(?i)https?://|<a\s|\[url`)
+
+// A Check screens a Message for spam, given the contact form it was sent from,
+// returning why it is spam or an empty string if it isn't
+type Check func(m *Message, form Form) string
+
+// A Form holds what the contact form sent besides the Message itself: the
+// honeypot field hidden from people, which only bots fill in, and when the
+// form was rendered.
+type Form struct {
+	Honeypot string
+	Rendered time.Time
+}
+
+// Checks is the spam pipeline each Message is run through, in turn, until one
+// screens it out
+var Checks = []Check{
+	func(m *Message, form Form) string {
+		if form.Honeypot != "" {
+			return "filled in the hidden field"
+		}
+		return ""
+	},
+	func(m *Message, form Form) string {
+		if form.Rendered.IsZero() {
+			return "sent without loading the form"
+		}
+		if time.Since(form.Rendered) < MinFillTime {
+			return "sent too quickly after loading the form"
+		}
+		return ""
+	},
+	func(m *Message, form Form) string {
+		if n := len(link.FindAllString(m.Body, -1)); n > MaxLinks {
+			return fmt.Sprintf("contains %d links", n)
+		}
+		return ""
+	},
+}
+
+// Screen runs m through the Checks, recording in m.Spam why it is spam if one
+// screens it out, and reports whether it is spam
+func Screen(m *Message, form Form) bool {
+	for _, check := range Checks {
+		if reason := check(m, form); reason != "" {
+			m.Spam = reason
+			return true
+		}
+	}
+	return false
+}
+
+// An Archive holds every Message received, persisted as JSON in a file.
+type Archive struct {
+	sync.RWMutex
+
+	path string
+	// in the order they were received
+	messages []*Message
+}
+
+// Open loads the Archive kept at path, which need not exist yet
+func Open(path string) (*Archive, error) {
+	a := &Archive{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &a.messages); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return a, nil
+}
+
+// Add stores a copy of m, first giving it an ID and the time it was received
+func (a *Archive) Add(m *Message) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	m.ID = hex.EncodeToString(id)
+	m.Received = time.Now().UTC()
+
+	a.Lock()
+	defer a.Unlock()
+	c := *m
+	a.messages = append(a.messages, &c)
+	return a.save()
+}
+
+// Update persists changes to m, which must already be in the Archive, e.g.
+// once it's been delivered
+func (a *Archive) Update(m *Message) error {
+	a.Lock()
+	defer a.Unlock()
+	for i, old := range a.messages {
+		if old.ID == m.ID {
+			c := *m
+			a.messages[i] = &c
+			return a.save()
+		}
+	}
+	return fmt.Errorf("contact: no message %q", m.ID)
+}
+
+// Messages returns every Message, newest first
+func (a *Archive) Messages() []Message {
+	a.RLock()
+	defer a.RUnlock()
+	res := make([]Message, len(a.messages))
+	for i, m := range a.messages {
+		res[len(res)-1-i] = *m
+	}
+	return res
+}
+
+// Remove deletes the Message identified by id
+func (a *Archive) Remove(id string) error {
+	a.Lock()
+	defer a.Unlock()
+	for i, m := range a.messages {
+		if m.ID == id {
+			a.messages = append(a.messages[:i], a.messages[i+1:]...)
+			return a.save()
+		}
+	}
+	return fmt.Errorf("contact: no message %q", id)
+}
+
+// From returns every Message sent from email, ignoring case, newest first,
+// e.g. for the admin to export all the Archive holds about its sender
+func (a *Archive) From(email string) []Message {
+	a.RLock()
+	defer a.RUnlock()
+	var res []Message
+	for i := len(a.messages) - 1; i >= 0; i-- {
+		if strings.EqualFold(a.messages[i].Email, email) {
+			res = append(res, *a.messages[i])
+		}
+	}
+	return res
+}
+
+// Purge deletes every Message sent from email, ignoring case, returning how
+// many there were
+func (a *Archive) Purge(email string) (int, error) {
+	a.Lock()
+	defer a.Unlock()
+	kept := a.messages[:0]
+	for _, m := range a.messages {
+		if !strings.EqualFold(m.Email, email) {
+			kept = append(kept, m)
+		}
+	}
+	n := len(a.messages) - len(kept)
+	a.messages = kept
+	if n == 0 {
+		return 0, nil
+	}
+	return n, a.save()
+}
+
+// Anonymize replaces the IP address of each Message received before t with
+// anonymize(IP), e.g. one with its host masked, returning how many changed
+func (a *Archive) Anonymize(before time.Time, anonymize func(ip string) string) (int, error) {
+	a.Lock()
+	defer a.Unlock()
+	n := 0
+	for _, m := range a.messages {
+		if m.IP == "" || !m.Received.Before(before) {
+			continue
+		}
+		if ip := anonymize(m.IP); ip != m.IP {
+			m.IP = ip
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, a.save()
+}
+
+// save writes the Archive to its path
+func (a *Archive) save() error {
+	b, err := json.MarshalIndent(a.messages, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(a.path, b, 0600)
+}
@@ -0,0 +1,70 @@
+package contact
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestArchive returns an Archive holding a message from each of senders,
+// in turn, from the IP address 192.0.2.1
+func newTestArchive(t *testing.T, senders ...string) *Archive {
+	t.Helper()
+	a, err := Open(filepath.Join(t.TempDir(), "contact.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range senders {
+		if err := a.Add(&Message{Name: "Sender", Email: email, Body: "Hello from " + email, IP: "192.0.2.1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a
+}
+
+func TestFromAndPurge(t *testing.T) {
+	a := newTestArchive(t, "ann@example.com", "bob@example.com", "Ann@Example.com")
+	if got := a.From("ann@example.com"); len(got) != 2 {
+		t.Errorf("found %d messages from ann@example.com, want 2", len(got))
+	}
+
+	n, err := a.Purge("ANN@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("purged %d messages, want 2", n)
+	}
+	if got := a.From("ann@example.com"); len(got) != 0 {
+		t.Errorf("found %d messages from ann@example.com after purging them", len(got))
+	}
+
+	// the purge is persisted
+	again, err := Open(a.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := again.Messages(); len(got) != 1 || got[0].Email != "bob@example.com" {
+		t.Errorf("kept %+v after purging ann@example.com, want bob@example.com's message", got)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	a := newTestArchive(t, "ann@example.com")
+	mask := func(ip string) string { return ip[:strings.LastIndex(ip, ".")] + ".0" }
+
+	if n, err := a.Anonymize(time.Now().Add(-time.Hour), mask); err != nil || n != 0 {
+		t.Fatalf("anonymised %d new messages (%v), want none", n, err)
+	}
+	n, err := a.Anonymize(time.Now().Add(time.Hour), mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || a.Messages()[0].IP != "192.0.2.0" {
+		t.Errorf("anonymised %d messages to %q, want 1 to 192.0.2.0", n, a.Messages()[0].IP)
+	}
+	if n, _ := a.Anonymize(time.Now().Add(time.Hour), mask); n != 0 {
+		t.Errorf("anonymised %d messages again", n)
+	}
+}
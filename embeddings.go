@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/firegoby/gournal/article"
+	"github.com/firegoby/gournal/config"
+	"github.com/firegoby/gournal/related"
+)
+
+// runEmbeddings implements the `gournal embeddings` command, rebuilding the
+// embeddings index used to rank related articles
+func runEmbeddings(args []string) {
+	fs := flag.NewFlagSet("embeddings", flag.ExitOnError)
+	batch := fs.Int("batch", 16, "number of articles to embed per request")
+	fs.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("embeddings: loading the configuration", "config", config.File, "error", err)
+	}
+	if cfg.AssistURL == "" || cfg.EmbeddingModel == "" {
+		fatal("embeddings: set AssistURL and EmbeddingModel first", "config", config.File)
+	}
+	if *batch < 1 {
+		fatal("embeddings: -batch must be at least 1")
+	}
+
+	articles, err := article.All()
+	if err != nil {
+		fatal("embeddings: listing the articles", "error", err)
+	}
+
+	ix := &related.Index{}
+	e := &related.OpenAIEmbedder{URL: cfg.AssistURL, Key: cfg.AssistKey, Model: cfg.EmbeddingModel}
+	err = ix.Rebuild(e, articles, *batch)
+	if err != nil {
+		fatal("embeddings: rebuilding the embeddings", "error", err)
+	}
+	err = ix.Save()
+	if err != nil {
+		fatal("embeddings: saving the embeddings", "error", err)
+	}
+	fmt.Printf("Embedded %d articles into %s\n", len(ix.Vectors), related.File())
+}
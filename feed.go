@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firegoby/gournal/article"
+	"github.com/firegoby/mux"
+)
+
+// Feeds ======================================================================
+
+// the number of the latest articles listed in each feed
+const feedSize = 20
+
+// feedSuffix is appended to the name of a page's route to name the route of
+// its feed, e.g. "author.feed" for "author", which is how the layout finds the
+// feed to link to, see feedLinks
+const feedSuffix = ".feed"
+
+// an Atom feed, see https://www.rfc-editor.org/rfc/rfc4287
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string      `xml:"xml:base,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Link      atomLink   `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomAuthor `xml:"author"`
+	Summary   *atomText  `xml:"summary,omitempty"`
+	Content   *atomText  `xml:"content,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// FeedHandler serves the latest listed articles as an Atom feed for GET
+// /feed.xml, the feed of the home page
+func FeedHandler(w http.ResponseWriter, r *http.Request) {
+	articles, err := article.Query().Sort(article.Newest).Limit(feedSize).Articles()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeFeed(w, r, siteConfig().SiteTitle, articles)
+}
+
+// AuthorFeedHandler serves the latest listed articles by an author as an Atom
+// feed, the feed of their page
+func AuthorFeedHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	articles, err := article.Query().Author(name).Sort(article.Newest).Limit(feedSize).Articles()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeFeed(w, r, "Articles by "+name+" - "+siteConfig().SiteTitle, articles)
+}
+
+// TagFeedHandler serves the latest listed articles tagged with a tag as an
+// Atom feed, the feed of its page
+func TagFeedHandler(w http.ResponseWriter, r *http.Request) {
+	tag := mux.Vars(r)["tag"]
+	articles, err := article.Query().Tag(tag).Sort(article.Newest).Limit(feedSize).Articles()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeFeed(w, r, "Articles tagged "+tag+" - "+siteConfig().SiteTitle, articles)
+}
+
+// writeFeed responds to r with the Atom feed titled title of articles, given
+// newest first. Relative links in the articles are resolved against the site's
+// base URL by the feed's xml:base, and the articles protected by a password
+// are given only by their titles, like their Entries.
+func writeFeed(w http.ResponseWriter, r *http.Request, title string, articles []*article.Article) {
+	var updated time.Time
+	for _, a := range articles {
+		if a.Updated().After(updated) {
+			updated = a.Updated()
+		}
+	}
+	if notModified(w, r, updated, r.URL.Path, strconv.Itoa(len(articles))) {
+		return
+	}
+
+	base := baseURL(r)
+	feed := atomFeed{
+		Base:    base + "/",
+		ID:      base + r.URL.Path,
+		Title:   title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Rel: "self", Href: base + r.URL.Path}},
+	}
+	if page, ok := pageOf(r); ok {
+		feed.Links = append(feed.Links, atomLink{Rel: "alternate", Href: base + page})
+	}
+	for _, a := range articles {
+		entry := atomEntry{
+			ID:        base + a.Permalink(),
+			Title:     a.Title,
+			Link:      atomLink{Href: base + a.Permalink()},
+			Published: a.PublishAt.UTC().Format(time.RFC3339),
+			Updated:   a.Updated().UTC().Format(time.RFC3339),
+			Author:    atomAuthor{Name: a.Author.Name, URI: a.Author.URL},
+		}
+		if !a.Protected() {
+			summary, err := a.SummaryHTML()
+			if err != nil {
+				renderError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			body, err := a.HTML()
+			if err != nil {
+				renderError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entry.Summary = &atomText{Type: "html", Body: string(summary)}
+			entry.Content = &atomText{Type: "html", Body: string(body)}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		logger(r).Error("writing the feed", "error", err)
+	}
+}
+
+// a feedLink is a feed of the page being rendered, for the layout to link to
+// for feed readers to discover
+type feedLink struct {
+	Type string
+	URL  string
+}
+
+// feedLinks returns the feeds of the page r is for: the route named for the
+// route r matched with feedSuffix, filled in with the same variables, e.g.
+// "/authors/Ann/feed.xml" for "/authors/Ann". Pages without a feed have none.
+func feedLinks(r *http.Request) []feedLink {
+	var match mux.RouteMatch
+	if routes == nil || !routes.Match(r, &match) || match.Route == nil || match.Route.GetName() == "" {
+		return nil
+	}
+	feed := routes.Get(match.Route.GetName() + feedSuffix)
+	if feed == nil {
+		return nil
+	}
+	u, err := feed.URL(routeVars(match.Vars)...)
+	if err != nil {
+		logger(r).Warn("linking to the feed", "error", err)
+		return nil
+	}
+	return []feedLink{{Type: "application/atom+xml", URL: u.String()}}
+}
+
+// pageOf returns the path of the page the feed r is for, the reverse of
+// feedLinks, or false if there's no such page
+func pageOf(r *http.Request) (string, bool) {
+	var match mux.RouteMatch
+	if routes == nil || !routes.Match(r, &match) || match.Route == nil || !strings.HasSuffix(match.Route.GetName(), feedSuffix) {
+		return "", false
+	}
+	page := routes.Get(strings.TrimSuffix(match.Route.GetName(), feedSuffix))
+	if page == nil {
+		return "", false
+	}
+	u, err := page.URL(routeVars(match.Vars)...)
+	if err != nil {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// routeVars flattens the variables of a route match into the pairs taken by
+// mux.Route.URL
+func routeVars(vars map[string]string) []string {
+	var pairs []string
+	for k, v := range vars {
+		pairs = append(pairs, k, v)
+	}
+	return pairs
+}
@@ -0,0 +1,287 @@
+// Package glossary keeps a per-site glossary of terms, such as abbreviations
+// and jargon, and marks up the first occurrence of each in rendered articles
+// with its definition.
+package glossary
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// An Entry defines a Term, optionally linking to a URL explaining it further.
+type Entry struct {
+	Term       string
+	Definition string
+	URL        string `json:",omitempty"`
+}
+
+// A Mode decides how Annotate marks up glossary terms.
+type Mode string
+
+const (
+	// Off leaves articles alone, the default
+	Off Mode = "off"
+	// Abbr wraps terms in <abbr> elements titled with their definition
+	Abbr Mode = "abbr"
+	// Link links terms with a URL to it, and treats the rest as with Abbr
+	Link Mode = "link"
+)
+
+// Modes lists every Mode a site may choose
+var Modes = []Mode{Off, Abbr, Link}
+
+// ParseMode returns the Mode named s, treating an empty name as Off
+func ParseMode(s string) (Mode, error) {
+	if s == "" {
+		return Off, nil
+	}
+	for _, m := range Modes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("glossary: unknown mode %q", s)
+}
+
+// skipped lists the elements whose text is never annotated: those already
+// links or abbreviations, code, and headings
+var skipped = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.Code: true, atom.Pre: true, atom.Kbd: true,
+	atom.Script: true, atom.Style: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+// A Glossary is a set of Entries, persisted as JSON in a file, and the Mode
+// articles are annotated with them in.
+type Glossary struct {
+	sync.RWMutex
+	Mode Mode
+
+	path    string
+	entries map[string]Entry
+	// matches any term, rebuilt whenever the entries change
+	terms *regexp.Regexp
+}
+
+// Open loads the Glossary stored at path, which need not exist yet
+func Open(path string) (*Glossary, error) {
+	g := &Glossary{path: path, entries: map[string]Entry{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, e := range entries {
+		g.entries[strings.ToLower(e.Term)] = e
+	}
+	g.compile()
+	return g, nil
+}
+
+// Entries returns every Entry, sorted alphabetically by Term
+func (g *Glossary) Entries() []Entry {
+	g.RLock()
+	defer g.RUnlock()
+	return g.sorted()
+}
+
+// Set adds e, replacing any Entry for the same Term regardless of case, and
+// persists the Glossary
+func (g *Glossary) Set(e Entry) error {
+	e.Term = strings.Join(strings.Fields(e.Term), " ")
+	e.Definition = strings.TrimSpace(e.Definition)
+	e.URL = strings.TrimSpace(e.URL)
+	switch {
+	case e.Term == "":
+		return fmt.Errorf("glossary: a term is required")
+	case e.Definition == "":
+		return fmt.Errorf("glossary: %q needs a definition", e.Term)
+	case e.URL != "" && !strings.HasPrefix(e.URL, "http://") && !strings.HasPrefix(e.URL, "https://") && !strings.HasPrefix(e.URL, "/"):
+		return fmt.Errorf("glossary: the URL of %q must be absolute or start with /", e.Term)
+	}
+	g.Lock()
+	defer g.Unlock()
+	g.entries[strings.ToLower(e.Term)] = e
+	g.compile()
+	return g.save()
+}
+
+// Remove deletes the Entry for term, regardless of case, and persists the
+// Glossary
+func (g *Glossary) Remove(term string) error {
+	g.Lock()
+	defer g.Unlock()
+	delete(g.entries, strings.ToLower(term))
+	g.compile()
+	return g.save()
+}
+
+// Annotate marks up the first occurrence of each term in the HTML h according
+// to the Glossary's Mode, leaving any within links, abbreviations, code and
+// headings alone. Terms are matched as whole words, ignoring case.
+func (g *Glossary) Annotate(h template.HTML) (template.HTML, error) {
+	g.RLock()
+	defer g.RUnlock()
+	mode := g.Mode
+	if mode == Off || mode == "" || g.terms == nil {
+		return h, nil
+	}
+
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(string(h)), body)
+	if err != nil {
+		return "", err
+	}
+	seen := map[string]bool{}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		g.annotate(n, mode, seen)
+		if err := html.Render(&buf, n); err != nil {
+			return "", err
+		}
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// annotate marks up the first occurrences of terms, not yet seen, in the text
+// beneath n
+func (g *Glossary) annotate(n *html.Node, mode Mode, seen map[string]bool) {
+	if n.Type == html.ElementNode && skipped[n.DataAtom] {
+		return
+	}
+	if n.Type == html.TextNode {
+		g.annotateText(n, mode, seen)
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		// annotating may insert siblings after c, which are already done
+		next := c.NextSibling
+		g.annotate(c, mode, seen)
+		c = next
+	}
+}
+
+// annotateText splits the text node n around the first occurrence of each
+// term not yet seen, wrapping the occurrence in an <abbr> or <a> element
+func (g *Glossary) annotateText(n *html.Node, mode Mode, seen map[string]bool) {
+	text := n.Data
+	for from := 0; from < len(text); {
+		loc := g.terms.FindStringIndex(text[from:])
+		if loc == nil {
+			return
+		}
+		start := from + loc[0]
+		end := g.termAt(text, start)
+		if end < 0 {
+			// not a whole word, so look again from the next character
+			_, size := utf8.DecodeRuneInString(text[start:])
+			from = start + size
+			continue
+		}
+		key := strings.ToLower(text[start:end])
+		if seen[key] {
+			from = end
+			continue
+		}
+		seen[key] = true
+		e := g.entries[key]
+
+		el := &html.Node{Type: html.ElementNode, Data: "abbr", DataAtom: atom.Abbr,
+			Attr: []html.Attribute{{Key: "title", Val: e.Definition}}}
+		if mode == Link && e.URL != "" {
+			el = &html.Node{Type: html.ElementNode, Data: "a", DataAtom: atom.A,
+				Attr: []html.Attribute{{Key: "href", Val: e.URL}, {Key: "title", Val: e.Definition}, {Key: "class", Val: "glossary"}}}
+		}
+		el.AppendChild(&html.Node{Type: html.TextNode, Data: text[start:end]})
+		rest := &html.Node{Type: html.TextNode, Data: text[end:]}
+		n.Data = text[:start]
+		n.Parent.InsertBefore(el, n.NextSibling)
+		n.Parent.InsertBefore(rest, el.NextSibling)
+
+		// carry on through the remaining text
+		g.annotateText(rest, mode, seen)
+		return
+	}
+}
+
+// termAt returns the end of the longest term occurring as a whole word at
+// start in text, or -1 if there is none. The characters either side of a term
+// are only looked at, never consumed, so "HTML/CSS" has two terms.
+func (g *Glossary) termAt(text string, start int) int {
+	if r, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && wordRune(r) {
+		return -1
+	}
+	end := -1
+	for _, e := range g.entries {
+		i := start + len(e.Term)
+		if i <= end || i > len(text) || !strings.EqualFold(text[start:i], e.Term) {
+			continue
+		}
+		if r, _ := utf8.DecodeRuneInString(text[i:]); i < len(text) && wordRune(r) {
+			continue
+		}
+		end = i
+	}
+	return end
+}
+
+// wordRune reports whether r is part of a word, i.e. a letter, digit or
+// underscore
+func wordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// compile rebuilds the regexp finding where any term might occur, which
+// termAt then checks is a whole word
+func (g *Glossary) compile() {
+	if len(g.entries) == 0 {
+		g.terms = nil
+		return
+	}
+	terms := make([]string, 0, len(g.entries))
+	for _, e := range g.entries {
+		terms = append(terms, regexp.QuoteMeta(e.Term))
+	}
+	sort.Strings(terms)
+	g.terms = regexp.MustCompile(`(?i)` + strings.Join(terms, "|"))
+}
+
+// sorted returns the Entries sorted alphabetically by Term
+func (g *Glossary) sorted() []Entry {
+	entries := make([]Entry, 0, len(g.entries))
+	for _, e := range g.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Term) < strings.ToLower(entries[j].Term)
+	})
+	return entries
+}
+
+// save writes the Glossary to its path
+func (g *Glossary) save() error {
+	b, err := json.MarshalIndent(g.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(g.path, b, 0600)
+}
@@ -0,0 +1,68 @@
+package glossary
+
+import (
+	"html/template"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestGlossary returns a Glossary in mode holding terms, each defined as
+// itself in full
+func newTestGlossary(t *testing.T, mode Mode, terms ...string) *Glossary {
+	t.Helper()
+	g, err := Open(filepath.Join(t.TempDir(), "glossary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Mode = mode
+	for _, term := range terms {
+		if err := g.Set(Entry{Term: term, Definition: "about " + term}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g
+}
+
+func TestAnnotate(t *testing.T) {
+	g := newTestGlossary(t, Abbr, "HTML", "CSS", "HTTP", "HTTP/2", "Go")
+	for _, c := range []struct{ in, want string }{
+		{"<p>HTML/CSS basics</p>", `<p><abbr title="about HTML">HTML</abbr>/<abbr title="about CSS">CSS</abbr> basics</p>`},
+		{"<p>HTML CSS</p>", `<p><abbr title="about HTML">HTML</abbr> <abbr title="about CSS">CSS</abbr></p>`},
+		{"<p>html and HTML</p>", `<p><abbr title="about HTML">html</abbr> and HTML</p>`},
+		{"<p>HTTP/2 over HTTP</p>", `<p><abbr title="about HTTP/2">HTTP/2</abbr> over <abbr title="about HTTP">HTTP</abbr></p>`},
+		{"<p>HTTP/2x</p>", `<p><abbr title="about HTTP">HTTP</abbr>/2x</p>`},
+		{"<p>Gopher, Going, Go.</p>", `<p>Gopher, Going, <abbr title="about Go">Go</abbr>.</p>`},
+		{"<p>XHTML_CSS</p>", `<p>XHTML_CSS</p>`},
+		{"<h2>HTML</h2><p><code>CSS</code></p>", `<h2>HTML</h2><p><code>CSS</code></p>`},
+	} {
+		got, err := g.Annotate(template.HTML(c.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != c.want {
+			t.Errorf("Annotate(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestAnnotateOff(t *testing.T) {
+	g := newTestGlossary(t, Off, "HTML")
+	if got, _ := g.Annotate("<p>HTML</p>"); got != "<p>HTML</p>" {
+		t.Errorf("annotated %q with the glossary off", got)
+	}
+}
+
+func TestAnnotateLink(t *testing.T) {
+	g := newTestGlossary(t, Link)
+	if err := g.Set(Entry{Term: "ULID", Definition: "a sortable ID", URL: "https://github.com/ulid/spec"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := g.Annotate("<p>Every ULID sorts</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), `<a href="https://github.com/ulid/spec" title="a sortable ID" class="glossary">ULID</a>`) {
+		t.Errorf("Annotate linked %q", got)
+	}
+}
@@ -0,0 +1,106 @@
+package gournaltest
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// update has ExpectGolden write the golden files rather than compare with
+// them, e.g. go test -run Snapshot -gournaltest.update, once a change to the
+// pages has been checked
+var update = flag.Bool("gournaltest.update", false, "rewrite golden files with the pages rendered")
+
+// the URL the Site's own URL is replaced with in golden files, as its port
+// differs on every run
+const goldenURL = "http://gournal.test"
+
+// ExpectGolden fails the test unless the Response's body matches the golden
+// file, or writes it there if the tests are run with -gournaltest.update
+func (r *Response) ExpectGolden(tb testing.TB, file string) *Response {
+	tb.Helper()
+	body := strings.Replace(r.Body, "http://"+r.Request.URL.Host, goldenURL, -1)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+			tb.Fatal(err)
+		}
+		return r
+	}
+	want, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		tb.Fatalf("%s %s: no golden file %s, run the tests with -gournaltest.update to write it", r.Request.Method, r.Request.URL.Path, file)
+	} else if err != nil {
+		tb.Fatal(err)
+	}
+	if line, got, wanted := firstDiff(body, string(want)); line > 0 {
+		tb.Fatalf("%s %s: body differs from %s at line %d:\ngot:  %s\nwant: %s", r.Request.Method, r.Request.URL.Path, file, line, got, wanted)
+	}
+	return r
+}
+
+// firstDiff returns the number, from 1, and content of the first line at
+// which got and want differ, or 0 if they're the same
+func firstDiff(got, want string) (int, string, string) {
+	if got == want {
+		return 0, "", ""
+	}
+	g, w := strings.Split(got, "\n"), strings.Split(want, "\n")
+	for i := 0; ; i++ {
+		var gl, wl string
+		if i < len(g) {
+			gl = g[i]
+		}
+		if i < len(w) {
+			wl = w[i]
+		}
+		if gl != wl || i >= len(g) || i >= len(w) {
+			return i + 1, gl, wl
+		}
+	}
+}
+
+// Snapshot renders the home page, the archive and each of the articles in the
+// source's articles/ through the default theme, and through each theme given,
+// a directory of the source tree holding overrides for it, e.g.
+// testdata/themes/minimal, comparing every page with its golden file in dir,
+// see ExpectGolden. Each theme is run as a subtest, named after its directory.
+// The articles are dated a day apart from a fixed day, and the site runs in
+// UTC, so the pages are the same wherever and whenever they're rendered.
+func Snapshot(t *testing.T, dir string, themes ...string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(filepath.Join(sourceDir(), "articles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]string{"home": "/", "archive": "/archive"}
+	for _, fi := range entries {
+		name := fi.Name()
+		if fi.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		slug := strings.TrimSuffix(name, filepath.Ext(name))
+		pages["article-"+slug] = "/articles/" + slug
+	}
+
+	for _, theme := range append([]string{""}, themes...) {
+		name, files := "default", map[string]string{"articles": "articles"}
+		if theme != "" {
+			name, files[theme] = filepath.Base(theme), "overrides"
+		}
+		t.Run(name, func(t *testing.T) {
+			site := start(t, nil, files)
+			// the forms on the pages carry the CSRF token of the visitor's
+			// cookie, rather than a new random one
+			site.Header.Set("Cookie", "gournal_csrf="+csrfToken)
+			for page, path := range pages {
+				site.Get(t, path).Expect(t, 200).ExpectGolden(t, filepath.Join(dir, name, page+".html"))
+			}
+		})
+	}
+}
@@ -0,0 +1,392 @@
+// Package gournaltest runs gournal end to end for tests: a server built from
+// the source is started against a temporary copy of the site, with clients
+// for its HTML pages and its JSON API, e.g.
+//
+//	site := gournaltest.Start(t, nil)
+//	site.Admin().API(t, "POST", "/api/v1/articles", map[string]string{"Title": "Hi", "Body": "..."}, nil).Expect(t, 201)
+//	site.Get(t, "/articles/hi").Expect(t, 200).ExpectBody(t, "Hi</h1>")
+package gournaltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/firegoby/gournal/config"
+	"github.com/firegoby/gournal/upgrade"
+)
+
+// the admin's credentials on every Site
+const (
+	AdminUsername = "admin"
+	AdminPassword = "password"
+)
+
+// the files of the source tree copied into each Site's directory
+var copied = []string{"templates", "public"}
+
+var (
+	buildOnce sync.Once
+	binary    string
+	buildErr  error
+)
+
+// A Site is a gournal server run for a test, serving at URL from the site in
+// Dir, with its Client making requests anonymously.
+type Site struct {
+	*Client
+	URL string
+	Dir string
+
+	mu  sync.Mutex
+	log bytes.Buffer
+}
+
+// A Client makes requests to a Site, as the admin if it was returned by
+// Admin. It doesn't follow redirects, so tests can check them.
+type Client struct {
+	site   *Site
+	admin  bool
+	client *http.Client
+	// Header is sent with every request, e.g. Accept-Language
+	Header http.Header
+}
+
+// A Response is the response to a Client's request, read in full.
+type Response struct {
+	*http.Response
+	Body string
+}
+
+// Start builds gournal from the source, if it hasn't yet been built, and
+// starts it serving a new site, configured by cfg, or as a configured site
+// with the file backend if cfg is nil, on a free port. The Admin is always
+// AdminUsername, with AdminPassword. The Site is stopped, and its directory
+// removed, when the test finishes.
+func Start(tb testing.TB, cfg *config.Config) *Site {
+	tb.Helper()
+	return start(tb, cfg, nil)
+}
+
+// start starts a Site as Start does, first copying the files beneath each of
+// the source tree's paths in files to the site's path they map to, e.g. its
+// articles
+func start(tb testing.TB, cfg *config.Config, files map[string]string) *Site {
+	tb.Helper()
+	buildOnce.Do(build)
+	if buildErr != nil {
+		tb.Fatalf("gournaltest: building gournal: %v", buildErr)
+	}
+
+	dir, err := ioutil.TempDir("", "gournaltest")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+	for _, name := range copied {
+		if err := copyDir(filepath.Join(sourceDir(), name), filepath.Join(dir, name)); err != nil {
+			tb.Fatalf("gournaltest: copying %s: %v", name, err)
+		}
+	}
+	for src, dst := range files {
+		if err := copyDir(filepath.Join(sourceDir(), src), filepath.Join(dir, dst)); err != nil {
+			tb.Fatalf("gournaltest: copying %s: %v", src, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "articles"), 0755); err != nil {
+		tb.Fatal(err)
+	}
+	if err := dateArticles(filepath.Join(dir, "articles")); err != nil {
+		tb.Fatalf("gournaltest: %v", err)
+	}
+	if err := writeConfig(dir, cfg); err != nil {
+		tb.Fatalf("gournaltest: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+	s := &Site{URL: "http://" + ln.Addr().String(), Dir: dir}
+	s.Client = s.newClient(false)
+
+	cmd := exec.Command(binary)
+	cmd.Dir = dir
+	for _, env := range os.Environ() {
+		// the site's settings are cfg's alone, and its files in dir
+		if !strings.HasPrefix(env, "GOURNAL_") && !strings.HasPrefix(env, "TZ=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	// and the dates it shows don't depend on where the tests are run
+	cmd.Env = append(cmd.Env, "TZ=UTC")
+	cmd.Stdout, cmd.Stderr = (*siteLog)(s), (*siteLog)(s)
+	exited, err := upgrade.Start(cmd, ln)
+	if err != nil {
+		tb.Fatalf("gournaltest: starting gournal: %v\n%s", err, s.Log())
+	}
+	tb.Cleanup(func() {
+		cmd.Process.Kill()
+		<-exited
+		if tb.Failed() {
+			tb.Logf("gournal's log:\n%s", s.Log())
+		}
+	})
+	return s
+}
+
+// Admin returns a Client making requests with the admin's credentials
+func (s *Site) Admin() *Client {
+	return s.newClient(true)
+}
+
+// Log returns what the Site has logged so far
+func (s *Site) Log() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log.String()
+}
+
+// siteLog collects what a Site logs
+type siteLog Site
+
+func (l *siteLog) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.log.Write(b)
+}
+
+// newClient returns a Client of the Site, as the admin if admin is set
+func (s *Site) newClient(admin bool) *Client {
+	return &Client{
+		site:  s,
+		admin: admin,
+		client: &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}},
+		Header: http.Header{},
+	}
+}
+
+// Do sends req, whose URL may be just a path on the Site, failing the test if
+// there's no response
+func (c *Client) Do(tb testing.TB, req *http.Request) *Response {
+	tb.Helper()
+	if req.URL.Host == "" {
+		u, err := url.Parse(c.site.URL + req.URL.String())
+		if err != nil {
+			tb.Fatal(err)
+		}
+		req.URL, req.Host = u, u.Host
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	if c.admin {
+		req.SetBasicAuth(AdminUsername, AdminPassword)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		tb.Fatalf("gournaltest: %s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		tb.Fatalf("gournaltest: %s %s: %v", req.Method, req.URL.Path, err)
+	}
+	return &Response{Response: resp, Body: string(b)}
+}
+
+// Get requests the page at path
+func (c *Client) Get(tb testing.TB, path string) *Response {
+	tb.Helper()
+	return c.Do(tb, newRequest(tb, "GET", path, nil))
+}
+
+// PostForm submits form to path, with its _method set to send a PUT or DELETE
+// as the site's own forms do. It carries a CSRF token, as if sent from one of
+// the site's pages, unless form sets csrf_token itself.
+func (c *Client) PostForm(tb testing.TB, path string, form url.Values) *Response {
+	tb.Helper()
+	if _, ok := form["csrf_token"]; !ok {
+		form = cloneValues(form)
+		form.Set("csrf_token", csrfToken)
+	}
+	req := newRequest(tb, "POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "gournal_csrf", Value: csrfToken})
+	return c.Do(tb, req)
+}
+
+// the CSRF token PostForm sends, in both the visitor's cookie and the form
+const csrfToken = "gournaltest-csrf-token-00000000000000000000"
+
+// cloneValues returns a copy of v, which may then be changed
+func cloneValues(v url.Values) url.Values {
+	c := url.Values{}
+	for k, vs := range v {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
+
+// API sends in, unless it's nil, as JSON to the JSON API at path, decoding the
+// response into out, unless it's nil or the response is an error
+func (c *Client) API(tb testing.TB, method, path string, in, out interface{}) *Response {
+	tb.Helper()
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req := newRequest(tb, method, path, body)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp := c.Do(tb, req)
+	if out != nil && resp.StatusCode < 300 && resp.Body != "" {
+		if err := json.Unmarshal([]byte(resp.Body), out); err != nil {
+			tb.Fatalf("gournaltest: %s %s: decoding %q: %v", method, path, resp.Body, err)
+		}
+	}
+	return resp
+}
+
+// Expect fails the test unless the Response has the status given
+func (r *Response) Expect(tb testing.TB, status int) *Response {
+	tb.Helper()
+	if r.StatusCode != status {
+		tb.Fatalf("%s %s: got status %d, want %d\n%s", r.Request.Method, r.Request.URL.Path, r.StatusCode, status, r.Body)
+	}
+	return r
+}
+
+// ExpectBody fails the test unless the Response's body contains each of want
+func (r *Response) ExpectBody(tb testing.TB, want ...string) *Response {
+	tb.Helper()
+	for _, s := range want {
+		if !strings.Contains(r.Body, s) {
+			tb.Fatalf("%s %s: body doesn't contain %q\n%s", r.Request.Method, r.Request.URL.Path, s, r.Body)
+		}
+	}
+	return r
+}
+
+// newRequest returns a request for path, failing the test if it can't
+func newRequest(tb testing.TB, method, path string, body io.Reader) *http.Request {
+	tb.Helper()
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return req
+}
+
+// sourceDir returns the directory of gournal's source
+func sourceDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(filepath.Dir(file))
+}
+
+// build builds gournal from the source into a temporary directory, setting
+// binary, or buildErr if it can't be
+func build() {
+	dir, err := ioutil.TempDir("", "gournaltest-bin")
+	if err != nil {
+		buildErr = err
+		return
+	}
+	binary = filepath.Join(dir, "gournal")
+	cmd := exec.Command("go", "build", "-o", binary, ".")
+	cmd.Dir = sourceDir()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		buildErr = fmt.Errorf("%v\n%s", err, out)
+	}
+}
+
+// writeConfig writes cfg, or a default Config, to dir, with the admin's
+// credentials
+func writeConfig(dir string, cfg *config.Config) error {
+	if cfg == nil {
+		cfg = config.Default()
+		cfg.SiteTitle = "Gournal Test"
+		cfg.BaseURL = "http://gournal.test"
+	}
+	c := *cfg
+	c.Admin.Username = AdminUsername
+	if err := c.Admin.SetPassword(AdminPassword); err != nil {
+		return err
+	}
+	if len(c.Secret) == 0 {
+		secret, err := config.NewSecret()
+		if err != nil {
+			return err
+		}
+		c.Secret = secret
+	}
+	b, err := json.MarshalIndent(&c, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, filepath.Base(config.File)), b, 0600)
+}
+
+// articlesDate is when the articles copied into a Site were last updated:
+// the first by name, with each after it a day earlier, so the pages rendered
+// from them don't depend on when, or in what order, they were copied
+var articlesDate = time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+
+// dateArticles dates the files in dir, a Site's articles, from articlesDate
+func dateArticles(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for i, fi := range files {
+		t := articlesDate.AddDate(0, 0, -i)
+		if err := os.Chtimes(filepath.Join(dir, fi.Name()), t, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDir copies the files beneath src to dst
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, b, fi.Mode().Perm())
+	})
+}
@@ -0,0 +1,354 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/firegoby/gournal/gournaltest"
+)
+
+// the parts of the JSON API's articles the tests check
+type apiArticle struct {
+	Slug    string
+	Title   string
+	Body    string
+	HTML    string
+	Version string
+}
+
+// createArticle creates an article through the JSON API as the admin,
+// returning it as created
+func createArticle(t *testing.T, site *gournaltest.Site, fields map[string]interface{}) *apiArticle {
+	t.Helper()
+	var a apiArticle
+	site.Admin().API(t, "POST", "/api/v1/articles", fields, &a).Expect(t, 201)
+	return &a
+}
+
+// location returns the path a Response redirects to
+func location(t *testing.T, resp *gournaltest.Response) string {
+	t.Helper()
+	u, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Path
+}
+
+func TestHomePage(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	site.Get(t, "/").Expect(t, 200).ExpectBody(t, "Gournal Test", "No posts yet!")
+
+	createArticle(t, site, map[string]interface{}{"Title": "First Post", "Body": "Hello there"})
+	site.Get(t, "/").Expect(t, 200).ExpectBody(t, "First Post", "Hello there")
+}
+
+func TestNotFound(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	site.Get(t, "/articles/no-such-article").Expect(t, 404)
+	site.Get(t, "/no/such/page").Expect(t, 404).ExpectBody(t, "Page Not Found")
+}
+
+func TestArticleFormLifecycle(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	admin := site.Admin()
+
+	site.Get(t, "/articles/new").Expect(t, 200)
+
+	resp := admin.PostForm(t, "/articles", url.Values{"title": {"Form Post"}, "body": {"Written in the form"}}).Expect(t, 302)
+	permalink := location(t, resp)
+	site.Get(t, permalink).Expect(t, 200).ExpectBody(t, "Form Post", "Written in the form")
+	slug := permalink[strings.LastIndex(permalink, "/")+1:]
+
+	site.Get(t, "/articles/"+slug+"/edit").Expect(t, 200).ExpectBody(t, "Written in the form")
+	resp = admin.PostForm(t, "/articles/"+slug, url.Values{
+		"_method": {"PUT"},
+		"title":   {"Form Post"},
+		"slug":    {slug},
+		"body":    {"Edited in the form"},
+		"summary": {"Reworded"},
+	}).Expect(t, 302)
+	site.Get(t, location(t, resp)).Expect(t, 200).ExpectBody(t, "Edited in the form")
+	site.Get(t, "/articles/"+slug+"/revisions").Expect(t, 200).ExpectBody(t, "Reworded")
+
+	admin.PostForm(t, "/articles/"+slug, url.Values{"_method": {"DELETE"}}).Expect(t, 302)
+	site.Get(t, permalink).Expect(t, 404)
+	admin.Get(t, "/trash").Expect(t, 200).ExpectBody(t, "Form Post")
+
+	site.PostForm(t, "/articles/"+slug+"/restore", url.Values{}).Expect(t, 401)
+	admin.PostForm(t, "/articles/"+slug+"/restore", url.Values{"csrf_token": {"wrong"}}).Expect(t, 403)
+	site.Get(t, permalink).Expect(t, 404)
+	admin.PostForm(t, "/articles/"+slug+"/restore", url.Values{}).Expect(t, 302)
+	site.Get(t, permalink).Expect(t, 200).ExpectBody(t, "Edited in the form")
+}
+
+func TestArticleFormValidation(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	resp := site.Admin().PostForm(t, "/articles", url.Values{"title": {""}, "body": {"No title"}})
+	if resp.StatusCode == 302 {
+		t.Fatalf("an article without a title was saved, at %s", resp.Header.Get("Location"))
+	}
+	resp.ExpectBody(t, "No title")
+}
+
+func TestArticleFormRequiresCSRF(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	resp := site.Admin().PostForm(t, "/articles", url.Values{"title": {"Forged"}, "body": {"..."}, "csrf_token": {"wrong"}})
+	if resp.StatusCode < 400 {
+		t.Fatalf("a form without the CSRF token was accepted with status %d", resp.StatusCode)
+	}
+	site.Get(t, "/articles/forged").Expect(t, 404)
+}
+
+func TestEditConflict(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	a := createArticle(t, site, map[string]interface{}{"Title": "Contended", "Body": "Original"})
+	site.Admin().PostForm(t, "/articles/"+a.Slug, url.Values{
+		"_method": {"PUT"},
+		"title":   {"Contended"},
+		"slug":    {a.Slug},
+		"body":    {"Stale edit"},
+		"version": {"not-the-version"},
+	}).Expect(t, 409).ExpectBody(t, "Stale edit")
+	site.Get(t, "/articles/"+a.Slug).Expect(t, 200).ExpectBody(t, "Original")
+}
+
+func TestRenameRedirects(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	a := createArticle(t, site, map[string]interface{}{"Title": "Old Name", "Body": "Moved"})
+	site.Admin().API(t, "PUT", "/api/v1/articles/"+a.Slug, map[string]interface{}{"Slug": "new-name"}, nil).Expect(t, 200)
+
+	resp := site.Get(t, "/articles/"+a.Slug).Expect(t, 301)
+	if got := location(t, resp); !strings.HasSuffix(got, "/new-name") {
+		t.Errorf("the old slug redirects to %s, want the new one", got)
+	}
+	site.Get(t, "/articles/new-name").Expect(t, 200).ExpectBody(t, "Moved")
+}
+
+func TestPasswordProtectedArticle(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	a := createArticle(t, site, map[string]interface{}{"Title": "Secret", "Body": "Hidden words", "Excerpt": "Hidden excerpt", "Description": "Hidden description", "Password": "letmein"})
+
+	for _, path := range []string{"/articles/" + a.Slug, "/articles/" + a.Slug + "/edit"} {
+		resp := site.Get(t, path).Expect(t, 401)
+		if strings.Contains(resp.Body, "Hidden words") {
+			t.Errorf("GET %s showed a protected article's body without its password", path)
+		}
+	}
+	site.Admin().Get(t, "/articles/"+a.Slug+"/edit").Expect(t, 200).ExpectBody(t, "Hidden words")
+
+	for _, path := range []string{"/api/v1/articles/" + a.Slug, "/articles/" + a.Slug} {
+		resp := site.API(t, "GET", path, nil, nil).Expect(t, 200)
+		if strings.Contains(resp.Body, "Hidden") {
+			t.Errorf("GET %s as JSON showed a protected article's summary without its password: %s", path, resp.Body)
+		}
+	}
+
+	resp := site.PostForm(t, "/articles/"+a.Slug+"/unlock", url.Values{"password": {"letmein"}})
+	var unlock string
+	for _, c := range resp.Cookies() {
+		unlock = c.Name + "=" + c.Value
+	}
+	if unlock == "" {
+		t.Fatalf("unlocking the article set no cookie, status %d", resp.StatusCode)
+	}
+	site.Header.Set("Cookie", unlock)
+	site.Get(t, "/articles/"+a.Slug).Expect(t, 200).ExpectBody(t, "Hidden words")
+}
+
+func TestAPI(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	admin := site.Admin()
+
+	site.API(t, "POST", "/api/v1/articles", map[string]string{"Title": "Anonymous", "Body": "..."}, nil).Expect(t, 401)
+
+	var created apiArticle
+	resp := admin.API(t, "POST", "/api/v1/articles", map[string]string{"Title": "Via the API", "Body": "*Emphatic*"}, &created).Expect(t, 201)
+	if got := resp.Header.Get("Location"); got != "/api/v1/articles/"+created.Slug {
+		t.Errorf("created at %s, want /api/v1/articles/%s", got, created.Slug)
+	}
+
+	var shown apiArticle
+	site.API(t, "GET", "/api/v1/articles/"+created.Slug, nil, &shown).Expect(t, 200)
+	if shown.Title != "Via the API" || !strings.Contains(shown.HTML, "<em>Emphatic</em>") {
+		t.Errorf("got %+v", shown)
+	}
+
+	var list struct{ Articles []apiArticle }
+	site.API(t, "GET", "/api/v1/articles", nil, &list).Expect(t, 200)
+	if len(list.Articles) != 1 || list.Articles[0].Slug != created.Slug {
+		t.Errorf("listed %+v, want just %s", list.Articles, created.Slug)
+	}
+	site.API(t, "GET", "/api/v1/articles?limit=0", nil, nil).Expect(t, 400)
+
+	var updated apiArticle
+	admin.API(t, "PUT", "/api/v1/articles/"+created.Slug, map[string]string{"Body": "Changed"}, &updated).Expect(t, 200)
+	if updated.Body != "Changed" || updated.Title != "Via the API" {
+		t.Errorf("updated to %+v, want only the body changed", updated)
+	}
+
+	admin.API(t, "POST", "/api/v1/articles", map[string]string{"Title": "", "Body": "..."}, nil).Expect(t, 422)
+
+	admin.API(t, "DELETE", "/api/v1/articles/"+created.Slug, nil, nil).Expect(t, 204)
+	site.API(t, "GET", "/api/v1/articles/"+created.Slug, nil, nil).Expect(t, 404)
+}
+
+func TestAPIErrorsCarryRequestID(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	site.Header.Set("X-Request-ID", "handler-test-1")
+	resp := site.API(t, "GET", "/api/v1/articles/no-such-article", nil, nil).Expect(t, 404)
+	var body struct{ RequestID string }
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RequestID != "handler-test-1" || resp.Header.Get("X-Request-ID") != "handler-test-1" {
+		t.Errorf("got request ID %q, header %q, want handler-test-1", body.RequestID, resp.Header.Get("X-Request-ID"))
+	}
+}
+
+func TestAdminPages(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	for _, path := range []string{"/trash", "/backup", "/admin/settings", "/annotations", "/contact/messages", "/metrics"} {
+		site.Get(t, path).Expect(t, 401)
+		site.Admin().Get(t, path).Expect(t, 200)
+	}
+	// the webmaster checks fetch the site's BaseURL, which isn't served here
+	site.Get(t, "/webmaster").Expect(t, 401)
+}
+
+func TestAuthorPageAndFeeds(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	createArticle(t, site, map[string]interface{}{"Title": "By Ann", "Body": "...", "Author": map[string]string{"Name": "Ann"}})
+	createArticle(t, site, map[string]interface{}{"Title": "By Bob", "Body": "...", "Author": map[string]string{"Name": "Bob"}})
+
+	resp := site.Get(t, "/authors/Ann").Expect(t, 200).ExpectBody(t, "By Ann", `href="/authors/Ann/feed.xml"`)
+	if strings.Contains(resp.Body, "By Bob") {
+		t.Errorf("Ann's page lists Bob's article")
+	}
+	site.Get(t, "/").Expect(t, 200).ExpectBody(t, `<link rel="alternate"`, `href="/feed.xml"`)
+
+	site.Get(t, "/feed.xml").Expect(t, 200).ExpectBody(t, "<feed", "By Ann", "By Bob")
+	resp = site.Get(t, "/authors/Ann/feed.xml").Expect(t, 200).ExpectBody(t, "By Ann")
+	if strings.Contains(resp.Body, "By Bob") {
+		t.Errorf("Ann's feed lists Bob's article")
+	}
+}
+
+func TestArchiveAndSitemap(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	a := createArticle(t, site, map[string]interface{}{"Title": "Archived", "Body": "..."})
+	site.Get(t, "/archive").Expect(t, 200).ExpectBody(t, "Archived")
+	site.Get(t, "/sitemap.xml").Expect(t, 200).ExpectBody(t, "<urlset", a.Slug)
+}
+
+func TestConditionalGet(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	a := createArticle(t, site, map[string]interface{}{"Title": "Cached", "Body": "..."})
+	resp := site.Get(t, "/articles/"+a.Slug).Expect(t, 200)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag was sent")
+	}
+	site.Header.Set("If-None-Match", etag)
+	site.Get(t, "/articles/"+a.Slug).Expect(t, 304)
+}
+
+func TestGlossaryRequiresAdmin(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	term := url.Values{"term": {"ULID"}, "definition": {"Defaced"}}
+	site.PostForm(t, "/glossary", term).Expect(t, 401)
+	site.Admin().PostForm(t, "/glossary", url.Values{"term": {"ULID"}, "definition": {"A sortable ID"}, "csrf_token": {"wrong"}}).Expect(t, 403)
+	site.Admin().PostForm(t, "/glossary", url.Values{"term": {"ULID"}, "definition": {"A sortable ID"}}).Expect(t, 303)
+
+	site.PostForm(t, "/glossary", url.Values{"_method": {"DELETE"}, "term": {"ULID"}}).Expect(t, 401)
+	resp := site.Get(t, "/glossary").Expect(t, 200).ExpectBody(t, "A sortable ID")
+	if strings.Contains(resp.Body, "Defaced") || strings.Contains(resp.Body, "Save Term") {
+		t.Errorf("the glossary can be changed without signing in")
+	}
+}
+
+func TestRevisionsShowIPOnlyToAdmin(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	a := createArticle(t, site, map[string]interface{}{"Title": "Audited", "Body": "..."})
+	resp := site.Get(t, "/articles/"+a.Slug+"/revisions").Expect(t, 200)
+	if strings.Contains(resp.Body, "127.0.0.1") {
+		t.Errorf("the revisions show the editor's IP address to visitors")
+	}
+	site.Admin().Get(t, "/articles/"+a.Slug+"/revisions").Expect(t, 200).ExpectBody(t, "from 127.0.0.1")
+}
+
+func TestRevisionsOfUnreadableArticles(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	admin := site.Admin()
+	scheduled := createArticle(t, site, map[string]interface{}{"Title": "Embargoed", "Body": "Early draft", "PublishAt": time.Now().Add(24 * time.Hour)})
+	admin.API(t, "PUT", "/api/v1/articles/"+scheduled.Slug, map[string]interface{}{"Body": "Final draft", "Summary": "Embargoed summary"}, nil).Expect(t, 200)
+	protected := createArticle(t, site, map[string]interface{}{"Title": "Guarded", "Body": "Hidden words", "Password": "letmein"})
+	admin.API(t, "PUT", "/api/v1/articles/"+protected.Slug, map[string]interface{}{"Body": "More hidden words", "Summary": "Guarded summary"}, nil).Expect(t, 200)
+
+	site.Get(t, "/articles/"+scheduled.Slug+"/revisions").Expect(t, 404)
+	resp := site.Get(t, "/articles/"+protected.Slug+"/revisions").Expect(t, 401)
+	if strings.Contains(resp.Body, "Guarded summary") {
+		t.Errorf("the revisions of a protected article were listed without its password")
+	}
+
+	for _, a := range []*apiArticle{scheduled, protected} {
+		resp := admin.Get(t, "/articles/"+a.Slug+"/revisions").Expect(t, 200)
+		m := regexp.MustCompile(`/revisions/([^'"]+)`).FindStringSubmatch(resp.Body)
+		if m == nil {
+			t.Fatalf("the admin was shown no revisions of %s", a.Slug)
+		}
+		path := "/articles/" + a.Slug + "/revisions/" + m[1]
+		resp = site.Get(t, path)
+		if resp.StatusCode < 400 || strings.Contains(resp.Body, "draft") || strings.Contains(resp.Body, "Hidden words") {
+			t.Errorf("GET %s showed the revision to a visitor, status %d", path, resp.StatusCode)
+		}
+		admin.Get(t, path).Expect(t, 200)
+	}
+}
+
+func TestEditorToolsRequireAdmin(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	site.PostForm(t, "/spellcheck", url.Values{"text": {"Teh"}}).Expect(t, 401)
+	site.PostForm(t, "/spellcheck/dictionary", url.Values{"word": {"gournal"}}).Expect(t, 401)
+	site.Admin().PostForm(t, "/spellcheck/dictionary", url.Values{"word": {"gournal"}, "csrf_token": {"wrong"}}).Expect(t, 403)
+	site.Admin().PostForm(t, "/spellcheck/dictionary", url.Values{"word": {"gournal"}}).Expect(t, 204)
+
+	site.PostForm(t, "/articles/suggest", url.Values{"title": {"Free"}, "body": {"Tokens"}}).Expect(t, 401)
+	site.Admin().PostForm(t, "/articles/suggest", url.Values{"title": {"Forged"}, "csrf_token": {"wrong"}}).Expect(t, 403)
+}
+
+func TestContactDataRequiresAdmin(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	site.Get(t, "/contact/messages/export?email=ann@example.com").Expect(t, 401)
+	var exported []map[string]interface{}
+	resp := site.Admin().Get(t, "/contact/messages/export?email=ann@example.com").Expect(t, 200)
+	if err := json.Unmarshal([]byte(resp.Body), &exported); err != nil || len(exported) != 0 {
+		t.Errorf("exported %q (%v), want an empty list", resp.Body, err)
+	}
+
+	site.PostForm(t, "/contact/messages/purge", url.Values{"email": {"ann@example.com"}}).Expect(t, 401)
+	site.Admin().PostForm(t, "/contact/messages/purge", url.Values{"email": {"ann@example.com"}, "csrf_token": {"wrong"}}).Expect(t, 403)
+	site.Admin().PostForm(t, "/contact/messages/purge", url.Values{"email": {"ann@example.com"}}).Expect(t, 303)
+}
+
+func TestTagPageAndFeed(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	tagged := createArticle(t, site, map[string]interface{}{"Title": "About Go", "Body": "...", "Tags": []string{"Go", "open source"}})
+	createArticle(t, site, map[string]interface{}{"Title": "About Rust", "Body": "...", "Tags": []string{"rust"}})
+
+	site.Get(t, "/articles/"+tagged.Slug).Expect(t, 200).ExpectBody(t, `href="/tags/Go"`, `href="/tags/open%20source"`)
+	resp := site.Get(t, "/tags/go").Expect(t, 200).ExpectBody(t, "About Go", `href="/tags/go/feed.xml"`)
+	if strings.Contains(resp.Body, "About Rust") {
+		t.Errorf("the go tag's page lists an article tagged rust")
+	}
+	site.Get(t, "/tags/open%20source").Expect(t, 200).ExpectBody(t, "About Go")
+
+	resp = site.Get(t, "/tags/go/feed.xml").Expect(t, 200).ExpectBody(t, "<feed", "About Go")
+	if strings.Contains(resp.Body, "About Rust") {
+		t.Errorf("the go tag's feed lists an article tagged rust")
+	}
+}
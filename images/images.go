@@ -0,0 +1,195 @@
+// Package images describes the images articles embed from gournal's public
+// directory, reading their dimensions and generating narrower variants of
+// them, so they can be rendered with width, height and srcset attributes and
+// loaded lazily.
+package images
+
+import (
+	"fmt"
+	"image"
+	// GIFs' dimensions are read, but they aren't resized as that would lose
+	// their animation
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Widths lists the widths, in pixels, of the variants generated of each image
+// wider than them
+var Widths = []int{480, 960, 1440}
+
+// A Variant is a copy of an image scaled down to Width pixels wide, served at
+// Path.
+type Variant struct {
+	Path  string
+	Width int
+}
+
+// An Info describes an image: its dimensions, in pixels, and its Variants,
+// narrowest first.
+type Info struct {
+	Width    int
+	Height   int
+	Variants []Variant
+}
+
+// SrcSet returns the value of a srcset attribute offering the Variants and the
+// image itself, served at src, or an empty string if it has no Variants
+func (info *Info) SrcSet(src string) string {
+	if len(info.Variants) == 0 {
+		return ""
+	}
+	var set []string
+	for _, v := range info.Variants {
+		set = append(set, fmt.Sprintf("%s %dw", v.Path, v.Width))
+	}
+	return strings.Join(append(set, fmt.Sprintf("%s %dw", src, info.Width)), ", ")
+}
+
+// Sizes returns the value of a sizes attribute showing the image no wider than
+// it is, nor than the viewport
+func (info *Info) Sizes() string {
+	return fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", info.Width, info.Width)
+}
+
+// A Library describes the images in a directory served at the root of the
+// site, remembering each until the file changes.
+type Library struct {
+	Dir string
+
+	mu    sync.Mutex
+	infos map[string]cached
+}
+
+// cached is an Info along with the modification time of the file it describes
+type cached struct {
+	info    *Info
+	modTime time.Time
+}
+
+// New returns a Library of the images in dir
+func New(dir string) *Library {
+	return &Library{Dir: dir, infos: map[string]cached{}}
+}
+
+// Lookup returns the Info of the image served at the path src, generating any
+// of its Variants which are missing or older than it. It returns an error
+// satisfying os.IsNotExist if src isn't a path within the Library, such as a
+// URL on another site.
+func (l *Library) Lookup(src string) (*Info, error) {
+	if !strings.HasPrefix(src, "/") || strings.HasPrefix(src, "//") {
+		return nil, os.ErrNotExist
+	}
+	src = path.Clean(src)
+	file := filepath.Join(l.Dir, filepath.FromSlash(src))
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.infos[src]; ok && c.modTime.Equal(fi.ModTime()) {
+		return c.info, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	config, format, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("images: %s: %v", src, err)
+	}
+	info := &Info{Width: config.Width, Height: config.Height}
+	if format == "jpeg" || format == "png" {
+		info.Variants, err = l.variants(src, file, fi.ModTime(), config.Width)
+		if err != nil {
+			// the image can still be served without them
+			slog.Warn("generating image variants", "src", src, "error", err)
+		}
+	}
+	l.infos[src] = cached{info, fi.ModTime()}
+	return info, nil
+}
+
+// variants returns the Variants of the image src, stored in file, generating
+// those which don't exist or are older than modTime
+func (l *Library) variants(src, file string, modTime time.Time, width int) (res []Variant, err error) {
+	var img image.Image
+	ext := path.Ext(src)
+	for _, w := range Widths {
+		if w >= width {
+			break
+		}
+		v := Variant{Path: fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(src, ext), w, ext), Width: w}
+		vfile := filepath.Join(l.Dir, filepath.FromSlash(v.Path))
+		if fi, err := os.Stat(vfile); err == nil && !fi.ModTime().Before(modTime) {
+			res = append(res, v)
+			continue
+		}
+		if img == nil {
+			if img, err = decode(file); err != nil {
+				return res, err
+			}
+		}
+		if err = resize(img, w, vfile, ext); err != nil {
+			return res, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+// decode reads the image stored in file
+func decode(file string) (image.Image, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// resize writes img, scaled to width pixels wide, to file in the format of
+// the extension ext, replacing it once it's complete
+func resize(img image.Image, width int, file, ext string) error {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, b, draw.Over, nil)
+
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(ext) {
+	case ".png":
+		err = png.Encode(f, scaled)
+	default:
+		err = jpeg.Encode(f, scaled, &jpeg.Options{Quality: 85})
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,45 @@
+package images
+
+import (
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Extend adds the dimensions and Variants of the images in the Library to the
+// images Markdown rendered by m embeds, and has every image loaded lazily
+func (l *Library) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(l, 500)))
+}
+
+// Transform implements parser.ASTTransformer, setting the attributes of the
+// images in doc
+func (l *Library) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		img, ok := n.(*ast.Image)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		img.SetAttributeString("loading", []byte("lazy"))
+		img.SetAttributeString("decoding", []byte("async"))
+
+		src := string(img.Destination)
+		info, err := l.Lookup(src)
+		if err != nil {
+			// hosted elsewhere, missing or not an image, all of which the
+			// browser copes with as best it can
+			return ast.WalkSkipChildren, nil
+		}
+		img.SetAttributeString("width", []byte(strconv.Itoa(info.Width)))
+		img.SetAttributeString("height", []byte(strconv.Itoa(info.Height)))
+		if srcset := info.SrcSet(src); srcset != "" {
+			img.SetAttributeString("srcset", []byte(srcset))
+			img.SetAttributeString("sizes", []byte(info.Sizes()))
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
@@ -0,0 +1,69 @@
+package main_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/firegoby/gournal/gournaltest"
+)
+
+// the script each test tries to inject, which must never reach a page intact
+const injectedScript = `<script>alert("injected")</script>`
+
+// expectNoScript fails the test if the page at path carries the injected
+// script, or any trace of it outside escaped text
+func expectNoScript(t *testing.T, c *gournaltest.Client, path string) {
+	t.Helper()
+	resp := c.Get(t, path).Expect(t, 200)
+	for _, s := range []string{injectedScript, `alert("injected")</script>`, "onerror=alert", "javascript:alert"} {
+		if strings.Contains(resp.Body, s) {
+			t.Errorf("GET %s: the page contains %q\n%s", path, s, resp.Body)
+		}
+	}
+}
+
+func TestScriptInTitleIsEscaped(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	var created struct{ Slug string }
+	site.Admin().API(t, "POST", "/api/v1/articles", map[string]interface{}{
+		"Title":  "Hello " + injectedScript,
+		"Body":   "Safe body",
+		"Author": map[string]string{"Name": injectedScript},
+		"Tags":   []string{injectedScript},
+	}, &created).Expect(t, 201)
+
+	for _, path := range []string{"/", "/archive", "/articles/" + created.Slug} {
+		expectNoScript(t, site.Client, path)
+	}
+	site.Get(t, "/articles/"+created.Slug).ExpectBody(t, "&lt;script&gt;")
+}
+
+func TestScriptInBodyIsRemoved(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	var created struct{ Slug string }
+	site.Admin().API(t, "POST", "/api/v1/articles", map[string]interface{}{
+		"Title":   "Body",
+		"Body":    "Hello\n\n" + injectedScript + "\n\n<img src=x onerror=alert(1)>\n\n[click](javascript:alert(1))",
+		"Excerpt": injectedScript + " excerpt",
+	}, &created).Expect(t, 201)
+
+	expectNoScript(t, site.Client, "/")
+	expectNoScript(t, site.Client, "/articles/"+created.Slug)
+	site.Get(t, "/articles/"+created.Slug).ExpectBody(t, "Hello")
+}
+
+func TestScriptSnippetsAreRefused(t *testing.T) {
+	site := gournaltest.Start(t, nil)
+	// the site's default snippet policy, restricted, refuses inline script
+	resp := site.Admin().PostForm(t, "/articles", url.Values{
+		"title":       {"Snippets"},
+		"body":        {"Body"},
+		"head_html":   {injectedScript},
+		"footer_html": {`<img src="x" onerror="alert(1)">`},
+	})
+	if resp.StatusCode == 302 {
+		t.Fatalf("an article with script in its snippets was saved, at %s", resp.Header.Get("Location"))
+	}
+	site.Get(t, "/articles/snippets").Expect(t, 404)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/firegoby/gournal/article"
+)
+
+// A lintIssue is a single style problem found in an article
+type lintIssue struct {
+	Slug    string `json:"slug"`
+	Line    int    `json:"line,omitempty"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// lintOptions configures which rules run and how they behave
+type lintOptions struct {
+	disabled     map[string]bool
+	headingLevel int
+}
+
+// lintRule checks a single article, returning any issues found
+type lintRule func(a *article.Article, opts lintOptions) []lintIssue
+
+// lintRules maps each rule name to its implementation
+var lintRules = map[string]lintRule{
+	"trailing-whitespace": lintTrailingWhitespace,
+	"bare-url":            lintBareURL,
+	"required-fields":     lintRequiredFields,
+	"heading-level":       lintHeadingLevel,
+	"include":             lintInclude,
+}
+
+// lintRuleOrder is the order in which rules run and are reported
+var lintRuleOrder = []string{"required-fields", "trailing-whitespace", "bare-url", "heading-level", "include"}
+
+var (
+	bareURLRegexp = regexp.MustCompile(`(^|[^(<"'])(https?://[^\s)>]+)`)
+	headingRegexp = regexp.MustCompile(`^(#{1,6})\s`)
+)
+
+// runLint implements the `gournal lint` command, checking every article in
+// article.Dir for style issues and exiting non-zero if any are found
+func runLint(args []string) {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	disable := fs.String("disable", "", "comma separated list of rules to skip")
+	headingLevel := fs.Int("heading-level", 2, "level the first heading in a body must start at")
+	fs.Parse(args)
+
+	opts := lintOptions{disabled: map[string]bool{}, headingLevel: *headingLevel}
+	for _, rule := range strings.Split(*disable, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		if lintRules[rule] == nil {
+			fatal("lint: unknown rule", "rule", rule)
+		}
+		opts.disabled[rule] = true
+	}
+
+	issues, err := lintArticles(opts)
+	if err != nil {
+		fatal("lint: linting the articles", "error", err)
+	}
+
+	switch *format {
+	case "json":
+		if issues == nil {
+			issues = []lintIssue{}
+		}
+		b, err := json.MarshalIndent(issues, "", "  ")
+		if err != nil {
+			fatal("lint: encoding the issues", "error", err)
+		}
+		fmt.Println(string(b))
+	case "text":
+		for _, i := range issues {
+			if i.Line > 0 {
+				fmt.Printf("%s:%d: %s (%s)\n", i.Slug, i.Line, i.Message, i.Rule)
+			} else {
+				fmt.Printf("%s: %s (%s)\n", i.Slug, i.Message, i.Rule)
+			}
+		}
+	default:
+		fatal("lint: unknown format", "format", *format)
+	}
+
+	if len(issues) > 0 {
+		os.Exit(1)
+	}
+}
+
+// lintArticles runs every enabled rule over every article in article.Dir,
+// bundles and those in cold storage included, reporting those which can't be
+// loaded as parse issues
+func lintArticles(opts lintOptions) (issues []lintIssue, err error) {
+	err = article.EachFile(func(slug string, a *article.Article, err error) error {
+		if err != nil {
+			issues = append(issues, lintIssue{Slug: slug, Rule: "parse", Message: err.Error()})
+			return nil
+		}
+		for _, name := range lintRuleOrder {
+			if !opts.disabled[name] {
+				issues = append(issues, lintRules[name](a, opts)...)
+			}
+		}
+		return nil
+	})
+	return issues, err
+}
+
+// lintRequiredFields reports articles missing a title, slug or author
+func lintRequiredFields(a *article.Article, opts lintOptions) (issues []lintIssue) {
+	fields := []struct{ name, value string }{
+		{"Title", a.Title}, {"Slug", a.Slug}, {"Author", a.Author.Name},
+	}
+	for _, f := range fields {
+		if strings.TrimSpace(f.value) == "" {
+			issues = append(issues, lintIssue{Slug: a.Slug, Rule: "required-fields",
+				Message: "missing " + f.name})
+		}
+	}
+	return
+}
+
+// lintTrailingWhitespace reports body lines ending in spaces or tabs
+func lintTrailingWhitespace(a *article.Article, opts lintOptions) (issues []lintIssue) {
+	for n, line := range strings.Split(a.Body, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line != strings.TrimRight(line, " \t") {
+			issues = append(issues, lintIssue{Slug: a.Slug, Line: n + 1, Rule: "trailing-whitespace",
+				Message: "trailing whitespace"})
+		}
+	}
+	return
+}
+
+// lintBareURL reports URLs which aren't wrapped in a link or angle brackets
+func lintBareURL(a *article.Article, opts lintOptions) (issues []lintIssue) {
+	for n, line := range strings.Split(a.Body, "\n") {
+		for _, m := range bareURLRegexp.FindAllStringSubmatch(line, -1) {
+			issues = append(issues, lintIssue{Slug: a.Slug, Line: n + 1, Rule: "bare-url",
+				Message: "bare URL " + m[2]})
+		}
+	}
+	return
+}
+
+// lintHeadingLevel reports a body whose first heading doesn't start at the
+// configured level, as the article title is already rendered as the <h1>
+func lintHeadingLevel(a *article.Article, opts lintOptions) []lintIssue {
+	for n, line := range strings.Split(a.Body, "\n") {
+		m := headingRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if len(m[1]) != opts.headingLevel {
+			return []lintIssue{{Slug: a.Slug, Line: n + 1, Rule: "heading-level",
+				Message: fmt.Sprintf("first heading is level %d, expected %d", len(m[1]), opts.headingLevel)}}
+		}
+		return nil
+	}
+	return nil
+}
+
+// lintInclude reports include shortcodes which can't be expanded, such as those
+// referring to missing articles or sections, or which would include the
+// article within itself
+func lintInclude(a *article.Article, opts lintOptions) (issues []lintIssue) {
+	_, errs := a.ExpandIncludes()
+	for _, err := range errs {
+		issues = append(issues, lintIssue{Slug: a.Slug, Line: err.Line, Rule: "include",
+			Message: err.Error()})
+	}
+	return
+}
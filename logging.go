@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/firegoby/gournal/config"
+	"github.com/firegoby/mux"
+)
+
+// Logging ====================================================================
+
+// setupLogging has gournal log records to stderr in format, "text" or "json",
+// from level up, as set by the site's LogFormat and LogLevel. What's logged
+// through the log package, e.g. by the standard library, is logged at the
+// error level.
+func setupLogging(format, level string) error {
+	var min slog.Level
+	if level != "" {
+		if err := min.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("unknown LogLevel %q in %s, use \"debug\", \"info\", \"warn\" or \"error\"", level, config.File)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: min}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("unknown LogFormat %q in %s, use \"text\" or \"json\"", format, config.File)
+	}
+	slog.SetDefault(slog.New(h))
+	slog.SetLogLoggerLevel(slog.LevelError)
+	return nil
+}
+
+// fatal logs msg at the error level, with the attributes args as slog.Error
+// takes them, then exits, for failures gournal can't run past
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// routeKey is the key under which nameRoutes keeps the route a request
+// matched in its context
+type routeKey struct{}
+
+// nameRoutes notes the route of router each request matches, by its path
+// template, e.g. "/articles/{title}", for its metrics and logs, see routeOf
+func nameRoutes(router *mux.Router, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, route)))
+	})
+}
+
+// routeOf returns the route r matched, as noted by nameRoutes, so the paths
+// of articles don't each get a series of metrics
+func routeOf(r *http.Request) string {
+	if route, ok := r.Context().Value(routeKey{}).(string); ok {
+		return route
+	}
+	return "unmatched"
+}
+
+// logger returns the logger for what happens serving r, recording its ID and
+// route with every record
+func logger(r *http.Request) *slog.Logger {
+	return slog.With("request_id", requestID(r), "route", routeOf(r))
+}
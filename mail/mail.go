@@ -0,0 +1,114 @@
+// Package mail delivers plain text email through an SMTP server, for the
+// messages gournal sends such as those from its contact form.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// ErrNotConfigured is returned by Send when no SMTP server has been set up
+var ErrNotConfigured = errors.New("mail: no SMTP server is configured")
+
+// A Server is the SMTP server, given as host:port, mail is sent through, as
+// From. Username and Password are optional, and only sent over TLS.
+type Server struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// A Message is a plain text email.
+type Message struct {
+	To      []string
+	ReplyTo string
+	Subject string
+	Body    string
+}
+
+// Send delivers m through the Server
+func (s *Server) Send(m *Message) error {
+	if s.Addr == "" || s.From == "" {
+		return ErrNotConfigured
+	}
+	if len(m.To) == 0 {
+		return errors.New("mail: a message needs a recipient")
+	}
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("mail: the sender %q is invalid: %v", s.From, err)
+	}
+	to := make([]string, len(m.To))
+	for i, addr := range m.To {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return fmt.Errorf("mail: the recipient %q is invalid: %v", addr, err)
+		}
+		to[i] = a.Address
+	}
+	msg, err := s.format(m, from)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("mail: %v", err)
+		}
+		// refuses to send the password unencrypted, except to localhost
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, from.Address, to, msg)
+}
+
+// format returns m as an RFC 5322 message from from
+func (s *Server) format(m *Message, from *mail.Address) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	header := [][2]string{
+		{"From", from.String()},
+		{"To", strings.Join(m.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id) + "@" + domain + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	if m.ReplyTo != "" {
+		a, err := mail.ParseAddress(m.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("mail: the reply address %q is invalid: %v", m.ReplyTo, err)
+		}
+		header = append(header, [2]string{"Reply-To", a.String()})
+	}
+
+	var buf bytes.Buffer
+	for _, h := range header {
+		// nothing submitted may start a header of its own
+		if strings.ContainsAny(h[1], "\r\n") {
+			return nil, fmt.Errorf("mail: the %s header contains a line break", h[0])
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", h[0], h[1])
+	}
+	buf.WriteString("\r\n")
+	body := strings.Replace(m.Body, "\r\n", "\n", -1)
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return buf.Bytes(), nil
+}
@@ -25,38 +25,745 @@
 package main
 
 import (
-	"log"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
-	"text/template"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/firegoby/gournal/annotation"
 	"github.com/firegoby/gournal/article"
+	"github.com/firegoby/gournal/assist"
+	"github.com/firegoby/gournal/breadcrumb"
+	"github.com/firegoby/gournal/config"
+	"github.com/firegoby/gournal/consent"
+	"github.com/firegoby/gournal/contact"
+	"github.com/firegoby/gournal/glossary"
+	"github.com/firegoby/gournal/images"
+	"github.com/firegoby/gournal/mail"
+	"github.com/firegoby/gournal/minify"
+	"github.com/firegoby/gournal/ratelimit"
+	"github.com/firegoby/gournal/related"
+	"github.com/firegoby/gournal/report"
+	"github.com/firegoby/gournal/review"
+	"github.com/firegoby/gournal/snippet"
+	"github.com/firegoby/gournal/spellcheck"
+	"github.com/firegoby/gournal/theme"
+	"github.com/firegoby/gournal/typography"
+	"github.com/firegoby/gournal/upgrade"
+	"github.com/firegoby/gournal/webmaster"
 	"github.com/firegoby/mux"
+	_ "github.com/lib/pq"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/text/language"
+	_ "modernc.org/sqlite"
 )
 
-// Main creates a gorilla/mux router & dispatches requests on port :3000
+// site holds the current configuration, which is replaced by the setup
+// wizard on first run
+var site = struct {
+	sync.RWMutex
+	cfg        *config.Config
+	configured bool
+	// when the configuration, or the templates rendering pages with it,
+	// last changed, as far as gournal knows
+	changed time.Time
+}{cfg: config.Default(), changed: time.Now()}
+
+// dictionary holds the words accepted by the spell checker for this site
+var dictionary *spellcheck.Dictionary
+
+// terms holds the site's glossary, whose terms are marked up in articles
+var terms *glossary.Glossary
+
+// typeset applies the typographic conventions of the site's language to
+// articles, if it has chosen one
+var typeset *typography.Locale
+
+// annotations holds the highlights readers have made in articles
+var annotations *annotation.Store
+
+// reviews holds the comments reviewers have left on drafts shared with them
+var reviews *review.Store
+
+// routes is the site's routing table, which the layout looks up the feeds of
+// pages in, see feedLinks
+var routes *mux.Router
+
+// messages archives what visitors send through the contact form
+var messages *contact.Archive
+
+// assets describes the theme, listing the assets pages need to render
+var assets *theme.Manifest
+
+// writeLimiter limits how many requests to make changes each visitor may send
+var writeLimiter *ratelimit.Limiter
+
+// reporter sends the panics recovered from while serving requests to an error
+// tracker, if one is configured
+var reporter *report.Reporter
+
+func init() {
+	consent.Register(consent.Feature{
+		Name:      "protected-articles",
+		Purpose:   "Remembers the password of a protected article once entered",
+		Cookies:   []string{"gournal_article_*"},
+		Necessary: true,
+	})
+	consent.Register(consent.Feature{
+		Name:      "forms",
+		Purpose:   "Proves the forms you send came from this site's own pages",
+		Cookies:   []string{csrfCookie},
+		Necessary: true,
+	})
+}
+
+// addr is the TCP address gournal listens on
+var addr = ":3000"
+
+// Main creates a gorilla/mux router & dispatches requests on addr, or runs a
+// subcommand such as `gournal seed`, `gournal lint`, `gournal check`,
+// `gournal compact`, `gournal mirror`, `gournal migrate` or
+// `gournal embeddings`, with the settings of the profile given by -profile.
+// Where gournal listens and keeps its files is set by flags, or else by
+// GOURNAL_ environment variables.
 func main() {
+	flag.StringVar(&config.Profile, "profile", config.Profile, "apply the `name`d profile of settings in the config file, e.g. dev (default $"+config.ProfileEnv+")")
+	flag.StringVar(&addr, "addr", getenv("GOURNAL_ADDR", addr), "listen on the TCP `address` (default $GOURNAL_ADDR or :3000)")
+	flag.StringVar(&config.File, "config", getenv("GOURNAL_CONFIG", config.File), "read the settings from `file` (default $GOURNAL_CONFIG or "+config.File+")")
+	flag.StringVar(&article.Dir, "data", getenv("GOURNAL_DATA_DIR", article.Dir), "keep articles and site data in `dir` (default $GOURNAL_DATA_DIR or "+article.Dir+")")
+	flag.StringVar(&theme.Templates, "templates", getenv("GOURNAL_TEMPLATE_DIR", theme.Templates), "read the theme's templates from `dir` (default $GOURNAL_TEMPLATE_DIR or "+theme.Templates+")")
+	flag.StringVar(&theme.Public, "public", getenv("GOURNAL_PUBLIC_DIR", theme.Public), "serve the theme's assets from `dir` (default $GOURNAL_PUBLIC_DIR or "+theme.Public+")")
+	flag.Parse()
+	// the directory is prefixed to file names
+	article.Dir = strings.TrimSuffix(article.Dir, "/") + "/"
+	// for the commands, until the configured store is opened
+	article.DefaultStore = article.NewCache(&article.FileStore{Dir: article.Dir})
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "seed":
+			runSeed(args[1:])
+			return
+		case "lint":
+			runLint(args[1:])
+			return
+		case "check":
+			runCheck(args[1:])
+			return
+		case "compact":
+			runCompact(args[1:])
+			return
+		case "mirror":
+			runMirror(args[1:])
+			return
+		case "migrate":
+			runMigrate(args[1:])
+			return
+		case "import":
+			runImport(args[1:])
+			return
+		case "embeddings":
+			runEmbeddings(args[1:])
+			return
+		}
+	}
+
+	cfg, err := config.Load()
+	switch {
+	case err == nil:
+		if err := setupLogging(cfg.LogFormat, cfg.LogLevel); err != nil {
+			fatal("configuring logging", "error", err)
+		}
+		site.cfg, site.configured = cfg, true
+		if config.Profile != "" {
+			slog.Info("using a profile", "profile", config.Profile, "config", config.File)
+		}
+		if len(cfg.Secret) == 0 {
+			// configs written before cookies were signed have no secret yet
+			cfg.Secret, err = config.NewSecret()
+			if err == nil {
+				err = cfg.Save()
+			}
+			if err != nil {
+				fatal("saving a new Secret", "config", config.File, "error", err)
+			}
+		}
+		if cfg.ReadingProgress {
+			consent.Register(consent.Feature{
+				Name:    "reading-progress",
+				Purpose: "Remembers how far you've read through long articles",
+				Cookies: []string{progressCookie},
+			})
+		}
+		article.SnippetPolicy, err = snippet.ParsePolicy(cfg.SnippetPolicy)
+		if err != nil {
+			fatal("parsing the SnippetPolicy", "config", config.File, "error", err)
+		}
+		for _, html := range []string{cfg.HeadHTML, cfg.FooterHTML} {
+			if err := article.SnippetPolicy.Check(html); err != nil {
+				slog.Warn("dropping what custom HTML isn't allowed", "config", config.File, "error", err)
+			}
+		}
+		switch cfg.Permalinks {
+		case "", "slug":
+		case "date":
+			article.DatedSlugs = true
+		default:
+			fatal("unknown Permalinks, use \"slug\" or \"date\"", "permalinks", cfg.Permalinks, "config", config.File)
+		}
+		if cfg.Format != "" {
+			article.DefaultFormat, err = article.ParseFormat(cfg.Format)
+			if err != nil {
+				fatal("parsing the Format", "config", config.File, "error", err)
+			}
+		}
+	case os.IsNotExist(err):
+		setupLogging("", "")
+		slog.Info("no configuration found, visit /setup to get started", "config", config.File)
+	default:
+		fatal("loading the configuration", "config", config.File, "error", err)
+	}
+
+	if dsn := siteConfig().ErrorReportDSN; dsn != "" {
+		reporter, err = report.New(dsn)
+		if err != nil {
+			fatal("setting up error reports", "error", err)
+		}
+	}
+
+	article.DefaultStore, err = openStore(siteConfig())
+	if err != nil {
+		fatal("opening the articles", "storage", siteConfig().Storage, "error", err)
+	}
+	if n, err := article.AssignIDs(); err != nil {
+		fatal("assigning article IDs", "error", err)
+	} else if n > 0 {
+		slog.Info("assigned IDs to articles", "articles", n)
+	}
+
+	dictionary, err = spellcheck.OpenDictionary(article.Dir + ".dictionary.txt")
+	if err != nil {
+		fatal("opening the dictionary", "error", err)
+	}
+	terms, err = glossary.Open(article.Dir + ".glossary.json")
+	if err != nil {
+		fatal("opening the glossary", "error", err)
+	}
+	terms.Mode, err = glossary.ParseMode(siteConfig().Glossary)
+	if err != nil {
+		fatal("parsing the Glossary mode", "config", config.File, "error", err)
+	}
+	images.New(theme.Public).Extend(article.Renderer)
+	assets, err = theme.Load(theme.Template(theme.File))
+	if err != nil {
+		fatal("loading the theme", "error", err)
+	}
+	typeset, err = typography.ParseLocale(siteConfig().Typography)
+	if err != nil {
+		fatal("parsing the Typography locale", "config", config.File, "error", err)
+	}
+	if _, err = annotation.ParseMode(siteConfig().Annotations); err != nil {
+		fatal("parsing the Annotations mode", "config", config.File, "error", err)
+	}
+	annotations, err = annotation.Open(article.Dir + ".annotations.json")
+	if err != nil {
+		fatal("opening the annotations", "error", err)
+	}
+	reviews, err = review.Open(article.Dir + ".reviews.json")
+	if err != nil {
+		fatal("opening the reviews", "error", err)
+	}
+	messages, err = contact.Open(article.Dir + ".contact.json")
+	if err != nil {
+		fatal("opening the contact messages", "error", err)
+	}
+
 	r := mux.NewRouter().StrictSlash(true).HTTPMethodOverride(true)
 
-	r.HandleFunc("/", HomeHandler).Methods("GET")
+	r.HandleFunc("/setup", SetupHandler).Methods("GET")
+	r.HandleFunc("/setup", CreateSetupHandler).Methods("POST")
+	r.HandleFunc("/", HomeHandler).Methods("GET").Name("home")
+	r.HandleFunc("/feed.xml", FeedHandler).Methods("GET").Name("home" + feedSuffix)
 	r.HandleFunc("/articles/new", NewArticleHandler).Methods("GET")
-	r.HandleFunc("/articles", CreateArticleHandler).Methods("POST")
+	r.HandleFunc("/articles", requireCSRF(CreateArticleHandler)).Methods("POST")
+	r.HandleFunc("/api/v1/articles", APIIndexArticleHandler).Methods("GET")
+	r.HandleFunc("/api/v1/articles", APICreateArticleHandler).Methods("POST")
+	r.HandleFunc("/api/v1/articles/{slug}", APIShowArticleHandler).Methods("GET")
+	r.HandleFunc("/api/v1/articles/{slug}", APIUpdateArticleHandler).Methods("PUT")
+	r.HandleFunc("/api/v1/articles/{slug}", APIDestroyArticleHandler).Methods("DELETE")
+	r.HandleFunc("/articles/suggest", requireCSRF(SuggestArticleHandler)).Methods("POST")
+	r.HandleFunc("/articles/id/{id}", ArticleByIDHandler).Methods("GET")
+	r.HandleFunc("/articles/id/{id}/{rest:edit|revisions}", ArticleByIDHandler).Methods("GET")
 	r.HandleFunc("/articles/{title}", ShowArticleHandler).Methods("GET")
 	r.HandleFunc("/articles/{title}/edit", EditArticleHandler).Methods("GET")
-	r.HandleFunc("/articles/{title}", UpdateArticleHandler).Methods("PUT")
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./public/")))
+	r.HandleFunc("/articles/{title}", requireCSRF(UpdateArticleHandler)).Methods("PUT")
+	r.HandleFunc("/articles/{title}", requireCSRF(DestroyArticleHandler)).Methods("DELETE")
+	r.HandleFunc("/articles/{title}/restore", requireCSRF(RestoreArticleHandler)).Methods("POST")
+	r.HandleFunc("/articles/{title}/unlock", UnlockArticleHandler).Methods("POST")
+	r.HandleFunc("/articles/{title}/progress", ProgressHandler).Methods("POST")
+	r.HandleFunc("/articles/{title}/annotations", CreateAnnotationHandler).Methods("POST")
+	r.HandleFunc("/articles/{title}/preview", PreviewArticleHandler).Methods("GET")
+	r.HandleFunc("/articles/{title}/reviews", CreateReviewHandler).Methods("POST")
+	r.HandleFunc("/articles/{title}/{file:[^/]+\\.[A-Za-z0-9]+}", BundleFileHandler).Methods("GET")
+	r.HandleFunc("/annotations", AnnotationsHandler).Methods("GET")
+	r.HandleFunc("/annotations/{id}/approve", ApproveAnnotationHandler).Methods("POST")
+	r.HandleFunc("/annotations/{id}", DestroyAnnotationHandler).Methods("DELETE")
+	r.HandleFunc("/reviews/{id}", DestroyReviewHandler).Methods("DELETE")
+	r.HandleFunc("/trash", TrashHandler).Methods("GET")
+	r.HandleFunc("/metrics", MetricsHandler).Methods("GET")
+	r.HandleFunc("/backup", BackupHandler).Methods("GET")
+	r.HandleFunc("/backup", RestoreBackupHandler).Methods("POST")
+	r.HandleFunc("/backup/download", DownloadBackupHandler).Methods("GET")
+	r.HandleFunc("/admin/settings", SettingsHandler).Methods("GET")
+	r.HandleFunc("/admin/settings", SaveSettingsHandler).Methods("POST")
+	r.HandleFunc("/consent", consent.Handler).Methods("POST")
+	r.HandleFunc("/contact", ContactHandler).Methods("GET")
+	r.HandleFunc("/contact", SendContactHandler).Methods("POST")
+	r.HandleFunc("/contact/messages", ContactMessagesHandler).Methods("GET")
+	r.HandleFunc("/contact/messages/export", ExportContactMessagesHandler).Methods("GET")
+	r.HandleFunc("/contact/messages/purge", requireCSRF(PurgeContactMessagesHandler)).Methods("POST")
+	r.HandleFunc("/contact/messages/{id}", DestroyContactMessageHandler).Methods("DELETE")
+	r.HandleFunc("/spellcheck", SpellCheckHandler).Methods("POST")
+	r.HandleFunc("/spellcheck/dictionary", requireCSRF(AddWordHandler)).Methods("POST")
+	r.HandleFunc("/glossary", GlossaryHandler).Methods("GET")
+	r.HandleFunc("/glossary", requireCSRF(SetGlossaryHandler)).Methods("POST")
+	r.HandleFunc("/glossary", requireCSRF(DestroyGlossaryHandler)).Methods("DELETE")
+	r.HandleFunc("/articles/{title}/profile", ProfileArticleHandler).Methods("GET")
+	r.HandleFunc("/articles/{title}/revisions", RevisionsArticleHandler).Methods("GET")
+	r.HandleFunc("/articles/{title}/revisions/{id}", ShowRevisionArticleHandler).Methods("GET")
+	r.HandleFunc("/authors/{name}", AuthorHandler).Methods("GET").Name("author")
+	r.HandleFunc("/authors/{name}/feed.xml", AuthorFeedHandler).Methods("GET").Name("author" + feedSuffix)
+	r.HandleFunc("/tags/{tag}", TagHandler).Methods("GET").Name("tag")
+	r.HandleFunc("/tags/{tag}/feed.xml", TagFeedHandler).Methods("GET").Name("tag" + feedSuffix)
+	r.HandleFunc("/sitemap.xml", SitemapHandler).Methods("GET")
+	r.HandleFunc("/webmaster", WebmasterHandler).Methods("GET")
+	r.HandleFunc("/archive", ArchiveHandler).Methods("GET")
+	r.HandleFunc("/{year:[0-9]{4}}", ArchiveHandler).Methods("GET")
+	r.HandleFunc("/{year:[0-9]{4}}/{month:[0-9]{2}}", ArchiveHandler).Methods("GET")
+	r.HandleFunc("/{year:[0-9]{4}}/{month:[0-9]{2}}/{slug}", ShowArticleHandler).Methods("GET")
+	r.PathPrefix("/").Handler(assetServer(theme.Assets()))
+	r.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
+	routes = r
+
+	go publishScheduled(time.Minute)
+	go anonymizeScheduled(time.Hour)
+	if cfg := siteConfig(); cfg.ArchiveHours > 0 {
+		dir := cfg.ArchiveDir
+		if dir == "" {
+			dir = "./archives"
+		}
+		go archiveScheduled(recoverPanics(r), dir, time.Duration(cfg.ArchiveHours)*time.Hour)
+	}
+
+	up, err := upgrade.New(addr)
+	if err != nil {
+		fatal("can't listen", "addr", addr, "error", err)
+	}
+	up.PIDFile = siteConfig().PIDFile
+
+	// SIGHUP hands the listener to a new process started from the binary,
+	// e.g. once it has been replaced by a new version
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("upgrading")
+			if err := up.Upgrade(); err != nil {
+				slog.Error("upgrading", "error", err)
+				continue
+			}
+			return
+		}
+	}()
+
+	burst, perMinute := siteConfig().WriteBurst, siteConfig().WritesPerMinute
+	if burst <= 0 {
+		burst = 20
+	}
+	if perMinute <= 0 {
+		perMinute = 10
+	}
+	writeLimiter = ratelimit.New(burst, time.Minute/time.Duration(perMinute))
+
+	srv := &http.Server{Handler: tagRequests(nameRoutes(r, logRequests(measureRequests(preloadAssets(compressResponses(minifyHTML(recoverPanics(requireSetup(limitWrites(r))))))))))}
+	var redirect http.Handler
+	srv.TLSConfig, redirect, err = tlsSetup(siteConfig())
+	if err != nil {
+		fatal("setting up TLS", "error", err)
+	}
+	if redirect != nil && siteConfig().RedirectAddr != "" {
+		go serveRedirects(siteConfig().RedirectAddr, redirect)
+	}
+
+	// SIGINT and SIGTERM stop the server once the requests it has accepted
+	// are served, for up to upgrade.DrainTimeout
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		sig := <-stop
+		slog.Info("shutting down", "signal", sig.String())
+		ctx, cancel := context.WithTimeout(context.Background(), upgrade.DrainTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Warn("stopping with requests unserved", "error", err)
+		}
+		close(stopped)
+	}()
+
+	if srv.TLSConfig != nil {
+		slog.Info("listening", "addr", addr, "https", true)
+	} else {
+		slog.Info("listening", "addr", addr)
+	}
+	err = up.Serve(srv)
+	switch {
+	case err == http.ErrServerClosed:
+		<-stopped
+	case err != nil:
+		fatal("serving", "error", err)
+	}
+	if err := closeStore(); err != nil {
+		fatal("closing the articles", "error", err)
+	}
+	if err == nil {
+		slog.Info("upgraded, the new process is serving")
+	} else {
+		slog.Info("shut down")
+	}
+}
+
+// tlsSetup returns the TLS configuration to serve HTTPS with, using the
+// certificate and key files in cfg or else certificates obtained from Let's
+// Encrypt for its AutocertHosts, or nil to serve plain HTTP. The handler
+// returned redirects plain HTTP requests to HTTPS, answering Let's Encrypt's
+// challenges too when its certificates are used.
+func tlsSetup(cfg *config.Config) (*tls.Config, http.Handler, error) {
+	redirect := http.HandlerFunc(redirectHTTPS)
+	switch {
+	case cfg.TLSCertFile != "" || cfg.TLSKeyFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading the TLS certificate: %v", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, redirect, nil
+	case len(cfg.AutocertHosts) > 0:
+		cache := cfg.AutocertCache
+		if cache == "" {
+			cache = "./autocert"
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertHosts...),
+			Cache:      autocert.DirCache(cache),
+			Email:      cfg.AutocertEmail,
+		}
+		return m.TLSConfig(), m.HTTPHandler(redirect), nil
+	}
+	return nil, nil, nil
+}
+
+// redirectHTTPS redirects r to the same URL served over HTTPS on addr
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	u := *r.URL
+	u.Scheme, u.Host = "https", host
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+}
+
+// serveRedirects serves h over plain HTTP on redirectAddr. A process started
+// by an upgrade can't listen there until the one it replaces exits, so while
+// the address is in use it keeps trying.
+func serveRedirects(redirectAddr string, h http.Handler) {
+	waiting := false
+	for {
+		ln, err := net.Listen("tcp", redirectAddr)
+		switch {
+		case err == nil:
+			slog.Info("redirecting HTTP to HTTPS", "addr", redirectAddr)
+			slog.Error("redirecting HTTP to HTTPS", "addr", redirectAddr, "error", http.Serve(ln, h))
+			return
+		case !errors.Is(err, syscall.EADDRINUSE):
+			fatal("can't listen", "addr", redirectAddr, "error", err)
+		case !waiting:
+			slog.Info("waiting for the address to be free", "addr", redirectAddr)
+			waiting = true
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// getenv returns the value of the environment variable name, or def if it is
+// unset or empty
+func getenv(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// closeStore closes the DefaultStore, if it needs closing, writing out any
+// search index and closing database connections
+func closeStore() error {
+	if c, ok := article.DefaultStore.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Setup Wizard ===============================================================
+
+// SetupHandler serves the first-run setup wizard, or a 404 once the site has
+// been configured
+func SetupHandler(w http.ResponseWriter, r *http.Request) {
+	if siteConfigured() {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	renderTemplate(w, r, "setup", struct {
+		Storages []string
+		Error    string
+	}{config.Storages, ""})
+}
+
+// CreateSetupHandler writes the configuration submitted by the setup wizard
+// and locks the wizard so it cannot be run again
+func CreateSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if siteConfigured() {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+
+	r.ParseForm()
+	cfg := &config.Config{
+		SiteTitle: r.FormValue("site_title"),
+		BaseURL:   r.FormValue("base_url"),
+		Storage:   r.FormValue("storage"),
+		Admin:     config.User{Username: r.FormValue("username")},
+		Secret:    siteConfig().Secret,
+	}
+	switch cfg.Storage {
+	case "postgres":
+		cfg.PostgresURL = r.FormValue("postgres_url")
+	case "s3":
+		// credentials are taken from the environment, or added to the config
+		cfg.S3Endpoint = r.FormValue("s3_endpoint")
+		cfg.S3Bucket = r.FormValue("s3_bucket")
+	}
+	if msg := validateSetup(cfg, r.FormValue("password")); msg != "" {
+		renderTemplate(w, r, "setup", struct {
+			Storages []string
+			Error    string
+		}{config.Storages, msg})
+		return
+	}
+	err := cfg.Admin.SetPassword(r.FormValue("password"))
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	store, err := openStore(cfg)
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	log.Println("Listening on 3000...")
-	http.ListenAndServe(":3000", r)
+	site.Lock()
+	defer site.Unlock()
+	if site.configured {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	err = cfg.Save()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	site.cfg, site.configured, site.changed = cfg, true, time.Now()
+	article.DefaultStore = store
+
+	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// Home =======================================================================
+
+// the number of articles listed on each page of the home page
+const homePageSize = 10
+
 // HomeHandler provides a welcome/index page with a listing of recents posts,
-// and a link to create a new post.
+// a page at a time in the order given by the sort parameter, and a link to
+// create a new post. It responds with the page's articles as JSON instead if
+// asked to, see wantsJSON.
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	articles, err := article.All()
+	w.Header().Add("Vary", "Accept")
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	order, err := article.ParseOrder(r.URL.Query().Get("sort"))
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	articles, total, err := article.Page((page-1)*homePageSize, homePageSize, order)
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if page > 1 && len(articles) == 0 {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+
+	entries, err := article.List()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var scheduled []*article.Entry
+	var modified time.Time
+	for _, e := range entries {
+		if !e.Published() {
+			scheduled = append(scheduled, e)
+		}
+		if e.Updated.After(modified) {
+			modified = e.Updated
+		}
+	}
+	// the count catches articles moved to the trash
+	if notModified(w, r, modified, r.URL.RawQuery, strconv.FormatBool(wantsJSON(r)), strconv.Itoa(len(entries))) {
+		return
+	}
+	if wantsJSON(r) {
+		respondArticles(w, r, articles, page, total)
+		return
+	}
+	// soonest first
+	sort.Slice(scheduled, func(i, j int) bool {
+		return scheduled[i].PublishAt.Before(scheduled[j].PublishAt)
+	})
+
+	var prev, next int
+	if page > 1 {
+		prev = page - 1
+	}
+	if page*homePageSize < total {
+		next = page + 1
+	}
+	renderTemplate(w, r, "home", struct {
+		Articles  []*article.Article
+		Scheduled []*article.Entry
+		PrevPage  int
+		NextPage  int
+		Order     article.Order
+		Orders    []article.Order
+	}{articles, scheduled, prev, next, order, article.Orders})
+}
+
+// AuthorHandler lists all the articles written by a single author
+func AuthorHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	articles, err := article.ByAuthor(params["name"])
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "author", struct {
+		Name     string
+		Articles []*article.Article
+	}{params["name"], articles})
+}
+
+// TagHandler lists the listed articles tagged with the tag in the path
+func TagHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	articles, err := article.ByTag(params["tag"])
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "tag", struct {
+		Tag      string
+		Articles []*article.Article
+	}{params["tag"], articles})
+}
+
+// ArchiveHandler lists the listed articles by year and month, either all of
+// them or just those of the year, or month, in the path
+func ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	archive, err := article.Archive()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var year *article.Year
+	var month *article.Month
+	if params["year"] != "" {
+		for _, y := range archive {
+			if fmt.Sprintf("%04d", y.Year) == params["year"] {
+				year = y
+			}
+		}
+		if year != nil && params["month"] != "" {
+			for _, m := range year.Months {
+				if fmt.Sprintf("%02d", m.Month) == params["month"] {
+					month = m
+				}
+			}
+		}
+		if year == nil || params["month"] != "" && month == nil {
+			renderError(w, r, "", http.StatusNotFound)
+			return
+		}
+		archive = []*article.Year{year}
+		if month != nil {
+			archive = []*article.Year{{Year: year.Year, Months: []*article.Month{month}}}
+		}
 	}
-	renderTemplate(w, "home", articles)
+
+	renderTemplate(w, r, "archive", struct {
+		Year  *article.Year
+		Month *article.Month
+		Years []*article.Year
+	}{year, month, archive})
 }
 
 // Article REST Functions - implements RESTfulResource interface ==============
@@ -69,33 +776,179 @@ func IndexArticleHandler(w http.ResponseWriter, r *http.Request) {
 
 // NewArticleHandler is a RESTful function for GET /articles/new
 func NewArticleHandler(w http.ResponseWriter, r *http.Request) {
-	renderTemplate(w, "new_article", nil)
+	renderTemplate(w, r, "new_article", articleForm{Article: &article.Article{}})
 }
 
 // CreateArticleHandler is a RESTful function for POST /articles/new
 func CreateArticleHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	a := article.New(r.FormValue("title"), r.FormValue("body"))
-	err := a.Save()
+	a, err := article.New(r.FormValue("title"), r.FormValue("body"), r.FormValue("slug"))
+	if err == article.ErrInvalidSlug {
+		// let Validate report the slug alongside any other problems
+		a, _ = article.New(r.FormValue("title"), r.FormValue("body"), "")
+		a.Slug = r.FormValue("slug")
+	}
+	applyArticleForm(a, r)
+	if r.FormValue("slug") == "" {
+		// derive the slug again now the publish date is known
+		a.Slug = a.DerivedSlug()
+	}
+
+	form := articleForm{Article: a, Slug: r.FormValue("slug")}
+	if errs, ok := a.Validate().(article.ValidationErrors); ok {
+		form.Errors = errs
+		renderArticleForm(w, r, "new_article", form)
+		return
+	}
+
+	requested := a.Slug
+	a.Change = changeFrom(r, nil, a)
+	err = a.Save()
+	if err == article.ErrSlugExists {
+		form.Errors = article.ValidationErrors{{Field: "Slug", Message: "is already used by another article"}}
+		renderArticleForm(w, r, "new_article", form)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if a.Slug != requested {
+		// let the author know their title's permalink was already in use
+		http.Redirect(w, r, a.Permalink()+"?deduplicated="+url.QueryEscape(requested), http.StatusFound)
 		return
 	}
-	http.Redirect(w, r, "/articles/"+a.Slug, http.StatusFound)
+	http.Redirect(w, r, a.Permalink(), http.StatusFound)
 }
 
-// ShowArticleHandler is a RESTful function for GET /articles/:id
+// ShowArticleHandler is a RESTful function for GET /articles/:id, also serving
+// date-based permalinks such as /2024/05/:id. It permanently redirects the old
+// permalinks of renamed articles, and any other path to an article's
+// canonical Permalink. It responds with the article as JSON instead if asked
+// to, see wantsJSON.
 func ShowArticleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
 	params := mux.Vars(r)
+	slug := params["title"]
+	if params["year"] != "" {
+		slug = params["year"] + "-" + params["month"] + "-" + params["slug"]
+	}
 
-	a, err := article.Load(params["title"])
+	a, err := article.Load(slug)
+	if os.IsNotExist(err) {
+		if to, ok := article.Redirect(slug); ok {
+			http.Redirect(w, r, article.Permalink(to), http.StatusMovedPermanently)
+			return
+		}
+	}
 	if err != nil {
-		log.Println(err.Error())
-		http.NotFound(w, r)
+		logger(r).Info("article not found", "slug", slug, "error", err)
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	if !a.Published() {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	if r.URL.Path != a.Permalink() {
+		u := a.Permalink()
+		if r.URL.RawQuery != "" {
+			u += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, u, http.StatusMovedPermanently)
+		return
+	}
+
+	asJSON := wantsJSON(r)
+	mode := annotationMode(a)
+	var highlights []annotation.Annotation
+	var related []*article.Article
+	if !asJSON {
+		if mode == annotation.Native {
+			highlights = annotations.Approved(a.ID)
+		}
+		related = relatedArticles(a)
+	}
+	parts := []string{a.Version(), r.URL.RawQuery, strconv.FormatBool(asJSON), strconv.Itoa(len(highlights))}
+	for _, rel := range related {
+		parts = append(parts, rel.Version())
+	}
+	if notModified(w, r, a.Updated(), parts...) {
+		return
+	}
+
+	if asJSON {
+		apiRespondArticle(w, r, http.StatusOK, a)
+		return
+	}
+	if !unlocked(r, a) {
+		renderPasswordPrompt(w, r, a, "")
+		return
+	}
+
+	var notice string
+	if requested := r.URL.Query().Get("deduplicated"); requested != "" {
+		notice = "An article already used the permalink " + article.Permalink(requested) +
+			", so this one was saved as " + a.Permalink()
+	}
+
+	renderTemplate(w, r, "show_article", showArticlePage{a, related, notice, tracksProgress(r, a), resumePosition(r, a), mode, highlights})
+}
+
+// BundleFileHandler serves a file kept alongside an article in its bundle,
+// such as an image it embeds, to those who may read the article
+func BundleFileHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	path, ok := article.BundleFile(params["title"], params["file"])
+	if !ok {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	a, err := article.Load(params["title"])
+	if err != nil || !a.Published() || !unlocked(r, a) {
+		renderError(w, r, "", http.StatusNotFound)
 		return
 	}
+	http.ServeFile(w, r, path)
+}
+
+// showArticlePage is the data the show_article template renders an article's
+// page from
+type showArticlePage struct {
+	*article.Article
+	Related        []*article.Article
+	Notice         string
+	TrackProgress  bool
+	ResumePosition int
+	Annotations    annotation.Mode
+	Highlights     []annotation.Annotation
+}
 
-	renderTemplate(w, "show_article", a)
+// ArticleByIDHandler is a function for GET /articles/id/:id, and its /edit and
+// /revisions subpages, which redirects to the article with that ID wherever
+// its slug has since moved to. Links built from IDs never break on renames.
+func ArticleByIDHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	if !article.ValidID(params["id"]) {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	a, err := article.LoadByID(params["id"])
+	if err != nil {
+		logger(r).Info("article not found", "id", params["id"], "error", err)
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	if params["rest"] == "edit" && !unlocked(r, a) && !(&requestInfo{r}).IsAdmin() {
+		renderPasswordPrompt(w, r, a, "")
+		return
+	}
+	u := a.Permalink()
+	if params["rest"] != "" {
+		u = "/articles/" + a.Slug + "/" + params["rest"]
+	}
+	http.Redirect(w, r, u, http.StatusFound)
 }
 
 // EditArticleHandler is a RESTful function for GET /articles/:id/edit
@@ -104,12 +957,17 @@ func EditArticleHandler(w http.ResponseWriter, r *http.Request) {
 
 	a, err := article.Load(params["title"])
 	if err != nil {
-		log.Println(err.Error())
-		http.NotFound(w, r)
+		logger(r).Info("article not found", "slug", params["title"], "error", err)
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	// the editor shows the body, so protected articles take their password
+	if !unlocked(r, a) && !(&requestInfo{r}).IsAdmin() {
+		renderPasswordPrompt(w, r, a, "")
 		return
 	}
 
-	renderTemplate(w, "edit_article", a)
+	renderTemplate(w, r, "edit_article", articleForm{Article: a, Slug: a.Slug, Original: a.Slug, Version: a.Version(), PreviewURL: previewURL(r, a), PreviewToken: previewToken(a), Reviews: reviews.For(a.ID)})
 }
 
 // UpdateArticleHandler is a RESTful function for PUT /articles/:id
@@ -118,33 +976,2049 @@ func UpdateArticleHandler(w http.ResponseWriter, r *http.Request) {
 
 	a, err := article.Load(params["title"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// refuse to overwrite changes saved since the form was loaded; forms
+	// without a version, e.g. from scripts, are saved regardless
+	version := r.FormValue("version")
+	if version != "" && version != a.Version() {
+		w.WriteHeader(http.StatusConflict)
+		renderTemplate(w, r, "conflict", struct {
+			Article *article.Article
+			Title   string
+			Body    string
+		}{a, r.FormValue("title"), r.FormValue("body")})
 		return
 	}
 
+	before := *a
+	original, slug := a.Slug, r.FormValue("slug")
+	derived := a.SlugDerived()
 	a.Title = r.FormValue("title")
 	a.Body = r.FormValue("body")
+	applyArticleForm(a, r)
+	if slug == "" || slug == original && derived {
+		// keep a slug generated from the title in step with the new title
+		slug = a.DerivedSlug()
+		if slug != original {
+			slug = article.UniqueSlug(slug)
+		}
+	}
 
-	err = a.Save()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// validate as though already renamed, so a bad slug is reported with
+	// everything else before anything is moved on disk
+	form := articleForm{Article: a, Slug: slug, Original: original, Version: version, ChangeSummary: r.FormValue("summary")}
+	a.Slug = slug
+	errs, _ := a.Validate().(article.ValidationErrors)
+	a.Slug = original
+	if errs == nil {
+		err = a.Rename(slug)
+		if err == article.ErrSlugExists {
+			errs = article.ValidationErrors{{Field: "Slug", Message: "is already used by another article"}}
+		} else if err != nil {
+			renderError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if errs != nil {
+		form.Errors = errs
+		renderArticleForm(w, r, "edit_article", form)
 		return
 	}
 
-	http.Redirect(w, r, "/articles/"+a.Slug, http.StatusFound)
+	a.Change = changeFrom(r, &before, a)
+	err = a.Save()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, a.Permalink(), http.StatusFound)
+}
+
+// RevisionsArticleHandler lists the prior versions of an article for
+// GET /articles/:id/revisions, to those who may read the article
+func RevisionsArticleHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	a, err := article.Load(params["title"])
+	if err != nil {
+		logger(r).Info("article not found", "slug", params["title"], "error", err)
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	if !readable(w, r, a) {
+		return
+	}
+
+	revisions, err := article.Revisions(a.Slug)
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "revisions", struct {
+		Article   *article.Article
+		Revisions []article.Revision
+	}{a, revisions})
+}
+
+// ShowRevisionArticleHandler displays a single prior version of an article for
+// GET /articles/:id/revisions/:revision, to those who may read the article as
+// it is now
+func ShowRevisionArticleHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	current, err := article.Load(params["title"])
+	if err != nil {
+		logger(r).Info("article not found", "slug", params["title"], "error", err)
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	if !readable(w, r, current) {
+		return
+	}
+	a, err := article.LoadRevision(params["title"], params["id"])
+	if err != nil {
+		logger(r).Info("revision not found", "slug", params["title"], "revision", params["id"], "error", err)
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	if !unlocked(r, a) && !(&requestInfo{r}).IsAdmin() {
+		renderPasswordPrompt(w, r, a, "")
+		return
+	}
+
+	renderTemplate(w, r, "show_revision", struct {
+		Article *article.Article
+		ID      string
+	}{a, params["id"]})
+}
+
+// UnlockArticleHandler checks the password submitted for a protected article
+// to POST /articles/:id/unlock, remembering a correct one in a cookie scoped to
+// the article so the visitor isn't asked again
+func UnlockArticleHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	a, err := article.Load(params["title"])
+	if err != nil || !a.Published() {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	if a.Protected() && !a.CheckPassword(r.FormValue("password")) {
+		renderPasswordPrompt(w, r, a, "That password isn't right, please try again")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookie(a),
+		Value:    unlockToken(a),
+		Path:     a.Permalink(),
+		Expires:  time.Now().Add(unlockDuration),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, a.Permalink(), http.StatusSeeOther)
+}
+
+// SuggestArticleHandler asks the configured assistant for a suggested
+// excerpt, meta description and tags for the submitted draft, responding with
+// JSON for the editor to offer to the author. Nothing is saved. Only the
+// admin may ask, as the assistant is paid for with the site's AssistKey.
+func SuggestArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	cfg := siteConfig()
+	if cfg.AssistURL == "" {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+
+	client := &assist.Client{URL: cfg.AssistURL, Key: cfg.AssistKey, Model: cfg.AssistModel}
+	suggestion, err := client.Suggest(r.FormValue("title"), r.FormValue("body"))
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestion)
 }
 
-// DestroyArticleHandler is a RESTful function for DELETE /articles/:id
+// DestroyArticleHandler is a RESTful function for DELETE /articles/:id, it
+// moves the article to the trash rather than deleting it outright
 func DestroyArticleHandler(w http.ResponseWriter, r *http.Request) {
-	// TODO
+	params := mux.Vars(r)
+
+	a, err := article.Load(params["title"])
+	if err != nil {
+		logger(r).Info("article not found", "slug", params["title"], "error", err)
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+
+	err = a.Trash()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-// Utilities ==================================================================
+// RestoreArticleHandler moves an article back out of the trash for
+// POST /articles/:id/restore, for the admin
+func RestoreArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	params := mux.Vars(r)
+
+	err := article.Restore(params["title"])
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusConflict)
+		return
+	}
+
+	http.Redirect(w, r, article.Permalink(params["title"]), http.StatusFound)
+}
+
+// Sitemap ====================================================================
+
+// a sitemap.xml document, see https://www.sitemaps.org/protocol.html
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// SitemapHandler lists the home page and every listed article search engines
+// may index for GET /sitemap.xml
+func SitemapHandler(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	sm := sitemap{URLs: []sitemapURL{{Loc: base + "/"}}}
+	err := article.Each(func(a *article.Article) error {
+		if a.Listed() && !a.NoIndex {
+			sm.URLs = append(sm.URLs, sitemapURL{
+				Loc:     base + a.Permalink(),
+				LastMod: a.Updated().UTC().Format(time.RFC3339),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(sm); err != nil {
+		logger(r).Error("writing the sitemap", "error", err)
+	}
+}
+
+// WebmasterHandler shows the site's search engine verification settings, and
+// checks its sitemap and robots.txt can be fetched from its BaseURL as search
+// engines would, for the admin
+func WebmasterHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	cfg := siteConfig()
+	// only the configured BaseURL is fetched, never one taken from the
+	// request's Host header, which could point the checks anywhere
+	var checks []*webmaster.Check
+	if cfg.BaseURL != "" {
+		checker := &webmaster.Checker{}
+		checks = checker.Run(strings.TrimSuffix(cfg.BaseURL, "/"))
+	}
+	renderTemplate(w, r, "webmaster", struct {
+		BaseURL            string
+		Configured         bool
+		GoogleVerification string
+		BingVerification   string
+		Checks             []*webmaster.Check
+	}{baseURL(r), cfg.BaseURL != "", cfg.GoogleVerification, cfg.BingVerification, checks})
+}
+
+// Trash ======================================================================
+
+// TrashHandler lists the articles which have been deleted and can be
+// restored, for the admin
+func TrashHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	articles, err := article.Trashed()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "trash", articles)
+}
+
+// Backups ====================================================================
+
+// BackupHandler offers the admin a backup of the site to download, and a form
+// to restore one
+func BackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	renderTemplate(w, r, "backup", "")
+}
+
+// DownloadBackupHandler sends the admin a backup of the site, see
+// article.Backup
+func DownloadBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	name := "gournal-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := article.Backup(w); err != nil {
+		// too late for an error page, the download is left incomplete
+		logger(r).Error("backing up", "error", err)
+	}
+}
+
+// RestoreBackupHandler restores the backup the admin uploaded, see
+// article.RestoreBackup
+func RestoreBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	f, _, err := r.FormFile("backup")
+	if err != nil {
+		renderTemplate(w, r, "backup", "Choose a backup to restore.")
+		return
+	}
+	defer f.Close()
+	rep, err := article.RestoreBackup(f)
+	if err == article.ErrNotBackup {
+		renderTemplate(w, r, "backup", "That file isn't a gournal backup.")
+		return
+	}
+	if err != nil {
+		renderError(w, r, fmt.Sprintf("restored %d articles before failing: %v", rep.Restored(), err), http.StatusInternalServerError)
+		return
+	}
+	logger(r).Info("restored a backup", "articles", rep.Restored(), "created", rep.Created, "updated", rep.Updated, "skipped", rep.Skipped)
+	renderTemplate(w, r, "backup", fmt.Sprintf("Restored %d articles: %d created, %d updated and %d skipped as unchanged. Restart gournal, or send it SIGHUP, to load the restored glossary, annotations and other site data.", rep.Restored(), rep.Created, rep.Updated, rep.Skipped))
+}
+
+// Settings ===================================================================
+
+// SettingsHandler shows the admin a form setting the theme's options
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	renderSettings(w, r, siteConfig().Theme, "")
+}
+
+// SaveSettingsHandler validates and saves the theme's options submitted by the
+// admin, leaving the settings as they were if any isn't valid
+func SaveSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	r.ParseForm()
+	values := map[string]string{}
+	for _, o := range assets.Options {
+		switch {
+		case o.Type == theme.Bool:
+			// unchecked boxes aren't submitted
+			values[o.Name] = strconv.FormatBool(r.FormValue(o.Name) == "true")
+		case r.PostForm[o.Name] != nil:
+			values[o.Name] = strings.TrimSpace(r.FormValue(o.Name))
+		}
+	}
+	if err := assets.Validate(values); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderSettings(w, r, values, err.Error())
+		return
+	}
+
+	site.Lock()
+	cfg := *site.cfg
+	cfg.Theme = values
+	err := cfg.Save()
+	if err == nil {
+		site.cfg, site.changed = &cfg, time.Now()
+	}
+	site.Unlock()
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderSettings(w, r, values, "Saved.")
+}
+
+// renderSettings renders the settings page, its form filled in with values
+// where set, or the theme's defaults
+func renderSettings(w http.ResponseWriter, r *http.Request, values map[string]string, notice string) {
+	type field struct {
+		theme.Option
+		Value string
+	}
+	var fields []field
+	for _, o := range assets.Options {
+		value, ok := values[o.Name]
+		if !ok {
+			value = o.Default
+		}
+		fields = append(fields, field{o, value})
+	}
+	renderTemplate(w, r, "settings", struct {
+		Theme  *theme.Manifest
+		Fields []field
+		Notice string
+	}{assets, fields, notice})
+}
+
+// Reading Progress ===========================================================
+
+// the name of the cookie remembering how far through long articles a reader
+// has got, as comma separated ID:position pairs, most recently read first
+const progressCookie = "gournal_progress"
+
+// how many articles, and for how long, reading progress is remembered
+const (
+	progressArticles = 20
+	progressDuration = 90 * 24 * time.Hour
+)
+
+// the reading time, in minutes, from which an article counts as long enough to
+// remember readers' progress through
+const progressMinutes = 3
+
+// ProgressHandler is a function for POST /articles/:id/progress, remembering
+// in a cookie how far through the article the reader has scrolled, as a
+// position in thousandths, so they can continue from there when they return
+func ProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if !siteConfig().ReadingProgress || !consent.Given(r, "reading-progress") {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	a, err := article.Load(mux.Vars(r)["title"])
+	if err != nil {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	pos, err := strconv.Atoi(r.FormValue("position"))
+	if err != nil || pos < 0 || pos > 1000 {
+		renderError(w, r, "position must be a number from 0 to 1000", http.StatusBadRequest)
+		return
+	}
+
+	pairs := []string{a.ID + ":" + strconv.Itoa(pos)}
+	for _, pair := range readingProgress(r) {
+		if len(pairs) < progressArticles && !strings.HasPrefix(pair, a.ID+":") {
+			pairs = append(pairs, pair)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     progressCookie,
+		Value:    strings.Join(pairs, ","),
+		Path:     "/",
+		Expires:  time.Now().Add(progressDuration),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tracksProgress reports whether the reader making r has their progress
+// through a remembered, which requires their consent and a long article
+func tracksProgress(r *http.Request, a *article.Article) bool {
+	return siteConfig().ReadingProgress && consent.Given(r, "reading-progress") &&
+		a.ID != "" && a.ReadingTime() >= progressMinutes
+}
+
+// resumePosition returns how far, in thousandths, the reader making r got
+// through a last time, or 0 if they haven't started it or have finished
+func resumePosition(r *http.Request, a *article.Article) int {
+	if !tracksProgress(r, a) {
+		return 0
+	}
+	for _, pair := range readingProgress(r) {
+		if !strings.HasPrefix(pair, a.ID+":") {
+			continue
+		}
+		pos, _ := strconv.Atoi(strings.TrimPrefix(pair, a.ID+":"))
+		// not worth resuming from the very start or end
+		if pos < 50 || pos > 950 {
+			return 0
+		}
+		return pos
+	}
+	return 0
+}
+
+// readingProgress returns the ID:position pairs remembered for the reader
+// making r, most recently read first
+func readingProgress(r *http.Request) []string {
+	c, err := r.Cookie(progressCookie)
+	if err != nil || c.Value == "" {
+		return nil
+	}
+	return strings.Split(c.Value, ",")
+}
+
+// Annotations ================================================================
+
+// annotationMode returns how readers may annotate a: as chosen by its
+// "annotations" meta field, if valid, or else for the whole site
+func annotationMode(a *article.Article) annotation.Mode {
+	mode, err := annotation.ParseMode(a.Meta["annotations"])
+	if err != nil || a.Meta["annotations"] == "" {
+		mode, _ = annotation.ParseMode(siteConfig().Annotations)
+	}
+	if mode == annotation.Native && a.ID == "" {
+		// native annotations are kept by article ID
+		return annotation.Off
+	}
+	return mode
+}
+
+// CreateAnnotationHandler is a function for POST /articles/:id/annotations,
+// saving the highlighted quote and note submitted by a reader of a published
+// article until a moderator approves it
+func CreateAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	a, err := article.Load(mux.Vars(r)["title"])
+	if err != nil || !a.Published() || !unlocked(r, a) || annotationMode(a) != annotation.Native {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	_, err = annotations.Add(annotation.Annotation{
+		Article: a.ID,
+		Quote:   r.FormValue("quote"),
+		Note:    r.FormValue("note"),
+		Name:    r.FormValue("name"),
+	})
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// AnnotationsHandler lists the annotations awaiting moderation by the admin
+func AnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	type pending struct {
+		annotation.Annotation
+		Title string
+	}
+	var list []pending
+	for _, an := range annotations.Pending() {
+		p := pending{Annotation: an}
+		if a, err := article.LoadByID(an.Article); err == nil {
+			p.Title = a.Title
+		}
+		list = append(list, p)
+	}
+	renderTemplate(w, r, "annotations", list)
+}
+
+// ApproveAnnotationHandler has the admin approve an annotation, showing it
+// with its article
+func ApproveAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := annotations.Approve(mux.Vars(r)["id"]); err != nil {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/annotations", http.StatusSeeOther)
+}
+
+// DestroyAnnotationHandler has the admin remove an annotation, whether pending
+// or approved, returning to the page it was removed from
+func DestroyAnnotationHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := annotations.Remove(mux.Vars(r)["id"]); err != nil {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	back := r.FormValue("back")
+	if !strings.HasPrefix(back, "/") || strings.HasPrefix(back, "//") {
+		back = "/annotations"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// Reviews ====================================================================
+
+// previewToken returns the token in the preview link of the article a, which
+// lets whoever has it read a as it stands, even as a draft, and comment on it.
+// It's signed over a's ID, so the link survives renames.
+func previewToken(a *article.Article) string {
+	mac := hmac.New(sha256.New, siteConfig().Secret)
+	mac.Write([]byte("preview:"))
+	mac.Write([]byte(a.ID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// previewURL returns the preview link of a, or an empty string if it has no
+// ID to keep the comments of its reviewers by
+func previewURL(r *http.Request, a *article.Article) string {
+	if a.ID == "" {
+		return ""
+	}
+	return baseURL(r) + "/articles/" + a.Slug + "/preview?token=" + previewToken(a)
+}
+
+// previewed returns the article r asks for, reporting whether r carries its
+// preview token, as the token parameter
+func previewed(r *http.Request) (*article.Article, bool) {
+	a, err := article.Load(mux.Vars(r)["title"])
+	if err != nil || a.ID == "" {
+		return nil, false
+	}
+	return a, hmac.Equal([]byte(r.FormValue("token")), []byte(previewToken(a)))
+}
+
+// PreviewArticleHandler shows an article, published or not, to a reviewer
+// following its preview link, offering them a form to comment on each of its
+// paragraphs
+func PreviewArticleHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := previewed(r)
+	if !ok {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	// keep the token out of the links followed from the preview
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	renderTemplate(w, r, "preview_article", struct {
+		*article.Article
+		Token string
+	}{a, r.FormValue("token")})
+}
+
+// CreateReviewHandler is a function for POST /articles/:id/reviews, saving a
+// reviewer's comment on a paragraph of the article they were sent a preview
+// link to, for its author to see in the editor
+func CreateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := previewed(r)
+	if !ok {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	paragraph, err := strconv.Atoi(r.FormValue("paragraph"))
+	if err != nil {
+		paragraph = -1
+	}
+	_, err = reviews.Add(review.Comment{
+		Article:   a.ID,
+		Paragraph: paragraph,
+		Quote:     r.FormValue("quote"),
+		Note:      r.FormValue("note"),
+		Name:      r.FormValue("name"),
+	})
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// DestroyReviewHandler removes a reviewer's comment once the author has dealt
+// with it, returning to the page it was removed from. It takes the admin, or
+// the preview token of the article commented on, as the editor sends.
+func DestroyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := reviews.Get(mux.Vars(r)["id"])
+	if !ok {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	if !(&requestInfo{r}).IsAdmin() {
+		a, err := article.LoadByID(c.Article)
+		if err != nil || !hmac.Equal([]byte(r.FormValue("token")), []byte(previewToken(a))) {
+			requireAdmin(w, r)
+			return
+		}
+	}
+	if err := reviews.Remove(c.ID); err != nil {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	back := r.FormValue("back")
+	if !strings.HasPrefix(back, "/") || strings.HasPrefix(back, "//") {
+		back = "/"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// Contact ====================================================================
+
+// contactLimiter limits how many messages each visitor may send through the
+// contact form: a few at once, then one every ten minutes
+var contactLimiter = ratelimit.New(3, 10*time.Minute)
+
+// ContactHandler provides a form visitors can send the site admin a message
+// through
+func ContactHandler(w http.ResponseWriter, r *http.Request) {
+	if siteConfig().ContactEmail == "" {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	// a subject may be suggested by links, e.g. to report an error
+	m := &contact.Message{Subject: r.URL.Query().Get("subject")}
+	renderContact(w, r, m, "", r.URL.Query().Get("sent") != "")
+}
+
+// SendContactHandler validates the message submitted through the contact form
+// and archives it, then emails it to the site admin unless it is spam. Spam is
+// thanked like any other message, so bots don't learn what gave them away.
+func SendContactHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := siteConfig()
+	if cfg.ContactEmail == "" {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	m := &contact.Message{
+		Name:    r.FormValue("name"),
+		Email:   r.FormValue("email"),
+		Subject: r.FormValue("subject"),
+		Body:    r.FormValue("body"),
+		IP:      clientIP(r),
+	}
+	if msg := m.Validate(); msg != "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderContact(w, r, m, msg, false)
+		return
+	}
+	if !contactLimiter.Allow(m.IP) {
+		minutes := int(contactLimiter.Wait(m.IP)/time.Minute) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(minutes*60))
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderContact(w, r, m, fmt.Sprintf("You've sent several messages recently, please try again in %d minutes", minutes), false)
+		return
+	}
+
+	spam := contact.Screen(m, contact.Form{
+		Honeypot: r.FormValue("website"),
+		Rendered: contactRendered(r.FormValue("token")),
+	})
+	if err := messages.Add(m); err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !spam {
+		server := &mail.Server{Addr: cfg.SMTPAddr, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.SMTPFrom}
+		subject := m.Subject
+		if subject == "" {
+			subject = "Message from " + m.Name
+		}
+		err := server.Send(&mail.Message{
+			To:      []string{cfg.ContactEmail},
+			ReplyTo: m.Email,
+			Subject: "[" + cfg.SiteTitle + "] " + subject,
+			Body:    m.Body + "\n\n-- \n" + m.Name + " <" + m.Email + "> via " + baseURL(r) + "/contact\n",
+		})
+		if err != nil {
+			// the message is archived, so it isn't lost
+			logger(r).Warn("emailing a contact message", "message", m.ID, "error", err)
+			m.Error = err.Error()
+		}
+		m.Delivered = err == nil
+		if err := messages.Update(m); err != nil {
+			logger(r).Error("archiving a contact message", "message", m.ID, "error", err)
+		}
+	}
+	http.Redirect(w, r, "/contact?sent=1", http.StatusSeeOther)
+}
+
+// ContactMessagesHandler lists every message sent through the contact form,
+// newest first, for the admin
+func ContactMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	renderTemplate(w, r, "contact_messages", messages.Messages())
+}
+
+// DestroyContactMessageHandler removes a message from the archive, for the
+// admin
+func DestroyContactMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := messages.Remove(mux.Vars(r)["id"]); err != nil {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, "/contact/messages", http.StatusSeeOther)
+}
+
+// ExportContactMessagesHandler sends the admin every message sent from the
+// email parameter as JSON, e.g. to answer its sender's request for the data
+// the site holds about them
+func ExportContactMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		renderError(w, r, "an email address is required", http.StatusBadRequest)
+		return
+	}
+	found := messages.From(email)
+	if found == nil {
+		found = []contact.Message{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="contact-messages.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(found)
+}
+
+// PurgeContactMessagesHandler deletes every message sent from the submitted
+// email address, for the admin, e.g. when its sender asks to be forgotten
+func PurgeContactMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		renderError(w, r, "an email address is required", http.StatusBadRequest)
+		return
+	}
+	n, err := messages.Purge(email)
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger(r).Info("purged contact messages", "messages", n)
+	http.Redirect(w, r, "/contact/messages", http.StatusSeeOther)
+}
+
+// renderContact renders the contact form, prefilled with m, along with any
+// problem sending it or thanks for a message which was
+func renderContact(w http.ResponseWriter, r *http.Request, m *contact.Message, msg string, sent bool) {
+	renderTemplate(w, r, "contact", struct {
+		*contact.Message
+		Token string
+		Error string
+		Sent  bool
+	}{m, contactToken(time.Now()), msg, sent})
+}
+
+// contactToken returns a signed record of the time t the contact form was
+// rendered, so messages sent implausibly quickly after it can be caught
+func contactToken(t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, siteConfig().Secret)
+	mac.Write([]byte("contact:" + ts))
+	return ts + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// contactRendered returns the time the contact form carrying token was
+// rendered, or the zero time if the token is missing or forged
+func contactRendered(token string) time.Time {
+	i := strings.Index(token, ".")
+	if i < 0 {
+		return time.Time{}
+	}
+	sec, err := strconv.ParseInt(token[:i], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	t := time.Unix(sec, 0)
+	if !hmac.Equal([]byte(token), []byte(contactToken(t))) {
+		return time.Time{}
+	}
+	return t
+}
+
+// clientIP returns the IP address of the visitor making r
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// anonymizeIP masks the host part of the IP address ip, keeping its /24
+// network for IPv4 or /48 for IPv6, or returns an empty string if it isn't one
+func anonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// anonymizeScheduled periodically anonymises the IP addresses of contact
+// messages older than the site's IPRetentionDays, if it sets any
+func anonymizeScheduled(interval time.Duration) {
+	for range time.Tick(interval) {
+		days := siteConfig().IPRetentionDays
+		if days <= 0 {
+			continue
+		}
+		n, err := messages.Anonymize(time.Now().AddDate(0, 0, -days), anonymizeIP)
+		if err != nil {
+			slog.Error("anonymising contact messages' IP addresses", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("anonymised contact messages' IP addresses", "messages", n, "days", days)
+		}
+	}
+}
+
+// Spell Checking =============================================================
+
+// SpellCheckHandler checks the submitted text with the configured spell
+// checker, responding with JSON matches not excused by the site dictionary.
+// Only the admin may use it, so the spell checker isn't open to anyone.
+func SpellCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	cfg := siteConfig()
+	if cfg.SpellCheckURL == "" {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	}
+
+	lang := cfg.SpellCheckLanguage
+	if lang == "" {
+		lang = "auto"
+	}
+
+	var checker spellcheck.Checker = &spellcheck.LanguageTool{URL: cfg.SpellCheckURL}
+	matches, err := checker.Check(r.FormValue("text"), lang)
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dictionary.Filter(matches))
+}
+
+// AddWordHandler adds the submitted word to the site dictionary, for the
+// admin
+func AddWordHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	err := dictionary.Add(r.FormValue("word"))
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Glossary ===================================================================
+
+// GlossaryHandler lists the site's glossary, with a form to add terms
+func GlossaryHandler(w http.ResponseWriter, r *http.Request) {
+	renderGlossary(w, r, glossary.Entry{}, "")
+}
+
+// SetGlossaryHandler adds the submitted term to the glossary, or replaces its
+// definition, for the admin
+func SetGlossaryHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	e := glossary.Entry{
+		Term:       r.FormValue("term"),
+		Definition: r.FormValue("definition"),
+		URL:        r.FormValue("url"),
+	}
+	if err := terms.Set(e); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		renderGlossary(w, r, e, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/glossary", http.StatusSeeOther)
+}
+
+// DestroyGlossaryHandler removes the submitted term from the glossary, for
+// the admin
+func DestroyGlossaryHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	if err := terms.Remove(r.FormValue("term")); err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/glossary", http.StatusSeeOther)
+}
+
+// renderGlossary renders the glossary page, with form prefilled in its form
+// and any error adding it
+func renderGlossary(w http.ResponseWriter, r *http.Request, form glossary.Entry, msg string) {
+	renderTemplate(w, r, "glossary", struct {
+		Entries []glossary.Entry
+		Mode    glossary.Mode
+		Form    glossary.Entry
+		Error   string
+	}{terms.Entries(), terms.Mode, form, msg})
+}
+
+// Utilities ==================================================================
+
+// articleForm is the data rendered by the new_article and edit_article
+// templates
+type articleForm struct {
+	*article.Article
+	// Slug is the permalink as typed into the form, shadowing the Article's so
+	// a derived slug isn't turned into a custom one when the form is redisplayed
+	Slug string
+	// Original is the stored slug of the article being edited, and Version the
+	// article.Version the edit was made to
+	Original string
+	Version  string
+	// ChangeSummary is the editor's summary of their changes, if any
+	ChangeSummary string
+	Errors        article.ValidationErrors
+	// PreviewURL is the link sharing the article with reviewers, if it can
+	// be, carrying PreviewToken, and Reviews the comments they've left on it
+	PreviewURL   string
+	PreviewToken string
+	Reviews      []review.Comment
+}
+
+// applyArticleForm copies the optional fields of a submitted article form
+// into a
+func applyArticleForm(a *article.Article, r *http.Request) {
+	a.Author = authorFromForm(r)
+	a.PublishAt = publishAtFromForm(r)
+	a.Excerpt = r.FormValue("excerpt")
+	a.Description = r.FormValue("description")
+	a.Tags = tagsFromForm(r)
+	a.Meta = metaFromForm(r)
+	a.HeadHTML = r.FormValue("head_html")
+	a.FooterHTML = r.FormValue("footer_html")
+	a.Visibility = article.Visibility(r.FormValue("visibility"))
+	a.NoIndex = r.FormValue("noindex") != ""
+	a.NoFollow = r.FormValue("nofollow") != ""
+	if r.FormValue("remove_password") != "" {
+		a.SetPassword("")
+	} else if p := r.FormValue("article_password"); p != "" {
+		// an empty password field leaves any existing password in place
+		if err := a.SetPassword(p); err != nil {
+			logger(r).Error("setting an article's password", "slug", a.Slug, "error", err)
+		}
+	}
+}
+
+// changeFrom records the edit r makes to an article, from before, or nil if it
+// is new, to after, attributing it to the admin if r carries their credentials.
+// The editor's IP address is anonymised if the site limits how long it keeps
+// them.
+func changeFrom(r *http.Request, before, after *article.Article) *article.Change {
+	c := &article.Change{
+		IP:      clientIP(r),
+		Time:    time.Now().UTC(),
+		Summary: strings.TrimSpace(r.FormValue("summary")),
+	}
+	// revisions are never rewritten, so can't be anonymised once old enough
+	if siteConfig().IPRetentionDays > 0 {
+		c.IP = anonymizeIP(c.IP)
+	}
+	if (&requestInfo{r}).IsAdmin() {
+		c.By, _, _ = r.BasicAuth()
+	}
+	if before != nil {
+		c.Fields = article.ChangedFields(before, after)
+	}
+	return c
+}
+
+// renderArticleForm redisplays a submitted article form along with the
+// problems which stopped it being saved
+func renderArticleForm(w http.ResponseWriter, r *http.Request, tmpl string, form articleForm) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	renderTemplate(w, r, tmpl, form)
+}
+
+// authorFromForm builds an article.Author from the author fields of a
+// submitted article form
+func authorFromForm(r *http.Request) article.Author {
+	return article.Author{
+		Name:  r.FormValue("author_name"),
+		Email: r.FormValue("author_email"),
+		URL:   r.FormValue("author_url"),
+		Bio:   r.FormValue("author_bio"),
+	}
+}
+
+// relatedArticles returns a handful of listed articles related to a, logging
+// rather than failing on errors as related articles are merely a nicety
+func relatedArticles(a *article.Article) []*article.Article {
+	ix, err := related.Load()
+	if err != nil {
+		slog.Warn("loading related articles", "slug", a.Slug, "error", err)
+		return nil
+	}
+	articles, err := article.Listed()
+	if err != nil {
+		slog.Warn("loading related articles", "slug", a.Slug, "error", err)
+		return nil
+	}
+	return ix.Related(a, articles, 5)
+}
+
+// tagsFromForm splits the comma separated tags field of a submitted article
+// form, dropping any empty tags
+func tagsFromForm(r *http.Request) (tags []string) {
+	for _, t := range strings.Split(r.FormValue("tags"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return
+}
+
+// metaFromForm pairs up the meta_key and meta_value fields of a submitted
+// article form, dropping rows left blank
+func metaFromForm(r *http.Request) map[string]string {
+	r.ParseForm()
+	keys, values := r.Form["meta_key"], r.Form["meta_value"]
+	meta := map[string]string{}
+	for i, k := range keys {
+		var v string
+		if i < len(values) {
+			v = values[i]
+		}
+		k = strings.TrimSpace(k)
+		if k == "" && strings.TrimSpace(v) == "" {
+			continue
+		}
+		meta[k] = v
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	return meta
+}
+
+// publishAtLayout is the format of the datetime-local publish_at form field
+const publishAtLayout = "2006-01-02T15:04"
+
+// publishAtFromForm parses the optional publish_at field of a submitted
+// article form, returning the zero time (publish immediately) if it is empty
+// or malformed
+func publishAtFromForm(r *http.Request) time.Time {
+	t, err := time.ParseInLocation(publishAtLayout, r.FormValue("publish_at"), time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// publishScheduled periodically flips scheduled articles live once their
+// publish time has passed, so they appear without restarting gournal
+func publishScheduled(interval time.Duration) {
+	for range time.Tick(interval) {
+		published, err := article.PublishDue()
+		if err != nil {
+			slog.Error("publishing scheduled articles", "error", err)
+			continue
+		}
+		for _, a := range published {
+			slog.Info("published a scheduled article", "slug", a.Slug)
+		}
+	}
+}
+
+// how long a visitor stays able to read a protected article after entering
+// its password
+const unlockDuration = 30 * 24 * time.Hour
+
+// unlockCookie returns the name of the cookie unlocking the protected
+// Article a
+func unlockCookie(a *article.Article) string {
+	return "gournal_article_" + a.Slug
+}
+
+// unlockToken returns the signed value of a's unlock cookie. It covers the
+// password hash, so changing an article's password locks it again.
+func unlockToken(a *article.Article) string {
+	mac := hmac.New(sha256.New, siteConfig().Secret)
+	mac.Write([]byte(a.Slug))
+	mac.Write(a.PasswordHash)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unlocked reports whether the visitor making r may read a, i.e. a isn't
+// protected or they've already entered its password
+func unlocked(r *http.Request, a *article.Article) bool {
+	if !a.Protected() {
+		return true
+	}
+	c, err := r.Cookie(unlockCookie(a))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(c.Value), []byte(unlockToken(a)))
+}
+
+// readable reports whether the visitor making r may read a, as
+// ShowArticleHandler decides, otherwise responding as it would: a scheduled
+// article isn't found, and a protected one asks for its password. The admin
+// may read any article.
+func readable(w http.ResponseWriter, r *http.Request, a *article.Article) bool {
+	if (&requestInfo{r}).IsAdmin() {
+		return true
+	}
+	if !a.Published() {
+		renderError(w, r, "", http.StatusNotFound)
+		return false
+	}
+	if !unlocked(r, a) {
+		renderPasswordPrompt(w, r, a, "")
+		return false
+	}
+	return true
+}
+
+// renderPasswordPrompt asks for the password of the protected Article a in
+// place of its content, along with an optional error message
+func renderPasswordPrompt(w http.ResponseWriter, r *http.Request, a *article.Article, msg string) {
+	w.WriteHeader(http.StatusUnauthorized)
+	renderTemplate(w, r, "article_password", struct {
+		Title string
+		Slug  string
+		Error string
+	}{a.Title, a.Slug, msg})
+}
+
+// openStore returns the article.Store for the storage backend named by the
+// site configuration. A new database is first filled with any articles
+// already kept as files, so switching backend doesn't lose them. Articles are
+// cached in memory, and indexed for search if the site has a SearchIndex,
+// unless the backend may be shared with other instances.
+func openStore(cfg *config.Config) (article.Store, error) {
+	s, path, err := openBackend(cfg, cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	db, ok := s.(database)
+	if !ok {
+		return frontStore(cfg, s)
+	}
+
+	articles, err := db.List()
+	if err == nil && len(articles) == 0 {
+		slog.Info("importing articles", "from", article.Dir, "to", path)
+		var rep *article.ImportReport
+		rep, err = db.Import(&article.FileStore{Dir: article.Dir})
+		if err == nil {
+			slog.Info("imported articles", "created", rep.Created, "updated", rep.Updated, "skipped", rep.Skipped)
+		}
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	// other instances may share a PostgreSQL database or S3 bucket, and change
+	// articles without flushing this one's cache
+	if cfg.Storage == "postgres" || cfg.Storage == "s3" {
+		if cfg.SearchIndex != "" {
+			slog.Warn("ignoring the SearchIndex, as the backend may be shared", "storage", cfg.Storage)
+		}
+		return timeStore(db), nil
+	}
+	front, err := frontStore(cfg, db)
+	if err != nil {
+		db.Close()
+	}
+	return front, err
+}
+
+// A database is a storage backend which keeps articles other than as files,
+// so must be closed
+type database interface {
+	article.Importer
+	Close() error
+}
+
+// openBackend opens the storage backend named storage, e.g. "sqlite", with the
+// site configuration's settings for it, returning a description of where it
+// keeps articles for logging. Backends which are databases must be closed.
+func openBackend(cfg *config.Config, storage string) (article.Importer, string, error) {
+	var (
+		db   database
+		path string
+		err  error
+	)
+	switch storage {
+	case "", "file":
+		return &article.FileStore{Dir: article.Dir, Logger: slog.With("storage", "file")}, article.Dir, nil
+	case "git":
+		git, err := article.OpenGit(article.Dir, cfg.GitRemote)
+		if err != nil {
+			return nil, "", err
+		}
+		git.Logger = slog.With("storage", "git")
+		return git, article.Dir, nil
+	case "sqlite":
+		path = cfg.SQLitePath
+		if path == "" {
+			path = "./gournal.db"
+		}
+		db, err = article.OpenSQLite(path)
+	case "bolt":
+		path = cfg.BoltPath
+		if path == "" {
+			path = "./gournal.bolt"
+		}
+		db, err = article.OpenBolt(path)
+	case "postgres":
+		if cfg.PostgresURL == "" {
+			return nil, "", errors.New("the postgres storage backend needs a PostgresURL")
+		}
+		maxConns := cfg.PostgresMaxConns
+		if maxConns == 0 {
+			maxConns = 10
+		}
+		// don't log the password the URL may contain
+		path = "PostgreSQL"
+		db, err = article.OpenPostgres(cfg.PostgresURL, maxConns)
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, "", errors.New("the s3 storage backend needs an S3Endpoint and S3Bucket")
+		}
+		path = "s3://" + cfg.S3Bucket + "/" + cfg.S3Prefix
+		db, err = article.OpenS3(article.S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			Insecure:  cfg.S3Insecure,
+		})
+	default:
+		return nil, "", fmt.Errorf("unknown storage backend %q", storage)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return db, path, nil
+}
+
+// frontStore puts the search index, if the site has a SearchIndex, and a Cache,
+// serving stale lists if StaleCache is set, in front of s, timing the
+// operations on s itself for the metrics
+func frontStore(cfg *config.Config, s article.Store) (article.Store, error) {
+	s = timeStore(s)
+	if cfg.SearchIndex != "" {
+		index, err := article.OpenBleve(cfg.SearchIndex, s)
+		if err != nil {
+			return nil, fmt.Errorf("opening the search index: %v", err)
+		}
+		s = index
+	}
+	c := article.NewCache(s)
+	c.Stale = cfg.StaleCache
+	c.Logger = slog.With("storage", "cache")
+	return c, nil
+}
+
+// baseURL returns the absolute URL of the site without a trailing slash, from
+// its BaseURL setting or else the host r was made to
+func baseURL(r *http.Request) string {
+	if base := siteConfig().BaseURL; base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// notModified sets the ETag and Last-Modified headers of a page whose content
+// last changed at modified, and is otherwise identified by parts, such as the
+// version of the article shown. It reports whether the copy the client holds,
+// per its If-None-Match or If-Modified-Since header, is still current, having
+// responded 304 Not Modified.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time, parts ...string) bool {
+	site.RLock()
+	if site.changed.After(modified) {
+		modified = site.changed
+	}
+	site.RUnlock()
+	modified = modified.UTC().Truncate(time.Second)
+
+	// pages differ for the admin, and with the cookies remembering consent,
+	// unlocked articles and reading progress
+	h := sha256.New()
+	fmt.Fprintln(h, modified.Unix(), (&requestInfo{r}).IsAdmin(), r.Header.Get("Cookie"))
+	for _, p := range parts {
+		fmt.Fprintln(h, p)
+	}
+	etag := `W/"` + hex.EncodeToString(h.Sum(nil)[:12]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	w.Header().Add("Vary", "Cookie")
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			// compared weakly, ignoring W/ prefixes
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err == nil && !modified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// siteConfig returns the current site configuration
+func siteConfig() *config.Config {
+	site.RLock()
+	defer site.RUnlock()
+	return site.cfg
+}
+
+// siteConfigured reports whether the setup wizard has been completed
+func siteConfigured() bool {
+	site.RLock()
+	defer site.RUnlock()
+	return site.configured
+}
+
+// requireSetup redirects every page request to the setup wizard until the
+// site has been configured, still allowing static assets through
+func requireSetup(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !siteConfigured() && r.URL.Path != "/setup" && path.Ext(r.URL.Path) == "" {
+			http.Redirect(w, r, "/setup", http.StatusFound)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID matches the request IDs accepted from clients and proxies
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// preloadAssets asks browsers requesting pages to preload the theme's critical
+// assets with Link headers, sent ahead in a 103 Early Hints response too when
+// EarlyHints is set, so they're fetched while the page is still being made
+func preloadAssets(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && strings.Contains(r.Header.Get("Accept"), "text/html") && len(assets.Preload) > 0 {
+			for _, a := range assets.Preload {
+				w.Header().Add("Link", a.Link())
+			}
+			if siteConfig().EarlyHints {
+				w.WriteHeader(http.StatusEarlyHints)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// minifyHTML minifies the HTML pages served when MinifyHTML is set, see
+// minify.HTML, holding each back until it is complete to do so. Other
+// responses are passed straight through.
+func minifyHTML(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !siteConfig().MinifyHTML {
+			h.ServeHTTP(w, r)
+			return
+		}
+		mw := &minifyWriter{ResponseWriter: w}
+		h.ServeHTTP(mw, r)
+		if mw.buf == nil {
+			if !mw.decided && mw.status != 0 {
+				// a response without a body, such as 304 Not Modified
+				w.WriteHeader(mw.status)
+			}
+			return
+		}
+		b := mw.buf.Bytes()
+		w.Header().Del("Content-Length")
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		if mw.status != 0 {
+			w.WriteHeader(mw.status)
+		}
+		if err := minify.HTML(w, b); err != nil {
+			logger(r).Warn("minifying", "path", r.URL.Path, "error", err)
+		}
+	})
+}
+
+// minifyWriter holds back a response in buf if it is HTML, as given by its
+// Content-Type or, if that is unset, by sniffing it as net/http would
+type minifyWriter struct {
+	http.ResponseWriter
+	status  int
+	decided bool
+	buf     *bytes.Buffer
+}
+
+func (w *minifyWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 {
+		// informational, such as Early Hints, with the response to follow
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *minifyWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		ct := w.Header().Get("Content-Type")
+		if ct == "" {
+			ct = http.DetectContentType(b)
+		}
+		if strings.HasPrefix(ct, "text/html") {
+			w.buf = &bytes.Buffer{}
+		} else if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// gzipWriters are reused by compressResponses, as each holds sizeable buffers
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// compressResponses gzips responses for clients which accept it, if their
+// Content-Type is worth compressing, see compressible. Static files which are
+// already compressed, such as images and backups, are passed straight through,
+// as are range requests and small responses.
+func compressResponses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == "HEAD" || r.Header.Get("Range") != "" || !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether r's Accept-Encoding header accepts gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, q := enc, ""
+		if i := strings.Index(enc, ";"); i >= 0 {
+			name, q = enc[:i], strings.TrimSpace(enc[i+1:])
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		if strings.HasPrefix(q, "q=") {
+			if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// minCompressed is the Content-Length below which responses aren't worth
+// compressing
+const minCompressed = 512
+
+// compressible reports whether responses of the Content-Type ct, such as
+// pages, JSON, feeds, CSS and scripts, shrink when gzipped
+func compressible(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch mt {
+	case "application/json", "application/xml", "application/javascript":
+		return true
+	}
+	return strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "+xml") || strings.HasSuffix(mt, "+json")
+}
+
+// gzipWriter holds back the status of a response until its first Write, when
+// it decides from the Content-Type, set or sniffed as net/http would, whether
+// to gzip it. Close must be called once the response is complete.
+type gzipWriter struct {
+	http.ResponseWriter
+	status  int
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 {
+		// informational, such as Early Hints, with the response to follow
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decide(b)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide starts the response, gzipped if worthwhile, given the first bytes b
+// of its body
+func (w *gzipWriter) decide(b []byte) {
+	w.decided = true
+	header := w.Header()
+	ct := header.Get("Content-Type")
+	if ct == "" {
+		// sniffed now, as the compressed body would be misread
+		ct = http.DetectContentType(b)
+		header.Set("Content-Type", ct)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	small := err == nil && length < minCompressed
+	switch {
+	case w.status == http.StatusNoContent, w.status == http.StatusPartialContent, w.status == http.StatusNotModified:
+	case header.Get("Content-Encoding") != "", small, !compressible(ct):
+	default:
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+// Close finishes the gzipped body, or sends the status of a response without
+// one, such as 304 Not Modified
+func (w *gzipWriter) Close() error {
+	if w.gz == nil {
+		if !w.decided && w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+		return nil
+	}
+	err := w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+	return err
+}
+
+// requestIDKey is the key under which tagRequests keeps a request's ID in its
+// context
+type requestIDKey struct{}
+
+// tagRequests gives each request an ID, kept in its context and sent back in
+// the X-Request-ID header, to correlate what is logged about it with what the
+// visitor saw. An ID already given by a proxy in front of gournal, in the same
+// header, is kept. The header is set on the request too, so it's passed on
+// with the request, e.g. to the error tracker.
+func tagRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			id = hex.EncodeToString(b)
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID tagRequests gave r, or an empty string if r didn't
+// pass through it, e.g. when archiving the site
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// An errorPage is the data of the page shown when a request fails
+type errorPage struct {
+	Status  int
+	Message string
+}
+
+// StatusText returns the name of the page's HTTP status, e.g. "Not Found"
+func (p errorPage) StatusText() string {
+	return http.StatusText(p.Status)
+}
+
+// renderError replies to r with the status code and its page, rendered through
+// the layout so it looks like part of the site: 404.html, 500.html for server
+// errors, which are logged along with msg, or else error.html showing msg.
+// Each quotes the request's ID so it can be reported.
+func renderError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	tmpl := "error"
+	switch {
+	case code == http.StatusNotFound:
+		tmpl = "404"
+	case code >= 500:
+		tmpl = "500"
+		logger(r).Error("serving a request failed", "method", r.Method, "path", r.URL.Path, "status", code, "error", msg)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	renderTemplate(w, r, tmpl, errorPage{code, msg})
+}
+
+// NotFoundHandler answers requests matching no route, with the 404 page or,
+// under /api/, a JSON error
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		apiErrorf(w, r, http.StatusNotFound, "no such endpoint %s %s", r.Method, r.URL.Path)
+		return
+	}
+	renderError(w, r, "", http.StatusNotFound)
+}
+
+// assetServer serves the theme's assets from fs, with the 404 page for those
+// which don't exist
+func assetServer(fs http.FileSystem) http.Handler {
+	files := http.FileServer(fs)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := fs.Open(path.Clean("/" + r.URL.Path))
+		if os.IsNotExist(err) {
+			renderError(w, r, "", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			f.Close()
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// recoverPanics recovers from panics while serving requests, logging them with
+// their stack traces and reporting them to the error tracker, if any, and
+// serves the error page rather than dropping the connection
+func recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// deliberately aborting the response, not a bug
+				panic(v)
+			}
+			logger(r).Error("panic serving a request", "method", r.Method, "url", r.URL.String(), "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if reporter != nil {
+				// skipping this function, leaving runtime.gopanic at the top
+				stack := report.Stack(1)
+				go func() {
+					id, err := reporter.Panic(v, stack, r)
+					if err != nil {
+						logger(r).Error("reporting a panic", "error", err)
+						return
+					}
+					logger(r).Info("reported a panic", "event", id)
+				}()
+			}
+			if sw.status != 0 {
+				// too late to replace what has been sent
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			renderTemplate(w, r, "500", errorPage{Status: http.StatusInternalServerError})
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter records the status, bar any informational ones such as Early
+// Hints, and size of the response written through it
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// logRequests logs the method, path, status, size and duration of every
+// response, as sent, once it is complete
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			status := sw.status
+			if status == 0 {
+				// nothing written, which net/http sends as 200
+				status = http.StatusOK
+			}
+			logger(r).Info("served", "method", r.Method, "uri", r.URL.RequestURI(), "status", status, "bytes", sw.size, "duration", time.Since(start).Round(time.Microsecond))
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+// unlimitedWrites matches the paths of the requests to make changes which
+// only set cookies, such as those remembering reading progress, so aren't
+// limited by limitWrites
+var unlimitedWrites = regexp.MustCompile(`^/(consent|articles/[^/]+/progress)$`)
+
+// limitWrites refuses requests to make changes, by POST, PUT, PATCH or DELETE,
+// with 429 Too Many Requests once their sender has made too many, see
+// writeLimiter, unless they carry the admin's credentials
+func limitWrites(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST", "PUT", "PATCH", "DELETE":
+		default:
+			h.ServeHTTP(w, r)
+			return
+		}
+		if unlimitedWrites.MatchString(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		// the admin's password is only checked once the limit is reached, as
+		// checking it is slow
+		if !writeLimiter.Allow(ip) && !(&requestInfo{r}).IsAdmin() {
+			seconds := int(writeLimiter.Wait(ip)/time.Second) + 1
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			msg := fmt.Sprintf("too many changes from %s, try again in %d seconds", ip, seconds)
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				apiErrorf(w, r, http.StatusTooManyRequests, "%s", msg)
+			} else {
+				renderError(w, r, msg, http.StatusTooManyRequests)
+			}
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// the cookie holding the token which forms must carry back, proving they were
+// sent from the site's own pages, see requireCSRF
+const csrfCookie = "gournal_csrf"
+
+// csrfToken returns the token the forms on the page answering r must carry,
+// giving the visitor a new one in their cookie if they have none yet
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 43 {
+		return c.Value
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	c := &http.Cookie{
+		Name:     csrfCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(b),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, c)
+	// so the token is found again while rendering the rest of the page
+	r.AddCookie(c)
+	return c.Value
+}
+
+// requireCSRF rejects a form sent to h unless its csrf_token field matches the
+// visitor's token cookie, which another site can neither read nor set, so it
+// can't trick a visitor's browser into creating, changing or deleting articles
+func requireCSRF(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(csrfCookie)
+		if err != nil || !hmac.Equal([]byte(c.Value), []byte(r.PostFormValue("csrf_token"))) {
+			renderError(w, r, "the form has expired or was sent from another site, please reload the page and try again", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// validateSetup returns a message describing the first problem with the
+// submitted setup wizard, or an empty string if there is none
+func validateSetup(cfg *config.Config, password string) string {
+	switch {
+	case cfg.SiteTitle == "":
+		return "Please enter a site title"
+	case cfg.Admin.Username == "":
+		return "Please choose an admin username"
+	case len(password) < 8:
+		return "The admin password must be at least 8 characters"
+	case cfg.Storage == "postgres" && cfg.PostgresURL == "":
+		return "Please enter the URL of the PostgreSQL database"
+	case cfg.Storage == "s3" && (cfg.S3Endpoint == "" || cfg.S3Bucket == ""):
+		return "Please enter the endpoint and bucket of the S3 storage"
+	}
+	for _, s := range config.Storages {
+		if cfg.Storage == s {
+			return ""
+		}
+	}
+	return "Please choose a storage backend"
+}
+
+// requestInfo exposes a safe subset of the request being rendered to
+// templates as {{ request }}, e.g. to highlight the current page in a nav bar
+// with {{ if request.Active "/authors" }}
+type requestInfo struct {
+	r *http.Request
+}
+
+// ID returns the ID of the request, for quoting when reporting a problem
+func (ri *requestInfo) ID() string {
+	return requestID(ri.r)
+}
+
+// Path returns the path of the current page, e.g. "/articles/hello-world"
+func (ri *requestInfo) Path() string {
+	return ri.r.URL.Path
+}
+
+// URL returns the absolute URL of the current page, e.g. for share links,
+// based on the site's BaseURL if it has one
+func (ri *requestInfo) URL() string {
+	u := baseURL(ri.r) + ri.r.URL.Path
+	if ri.r.URL.RawQuery != "" {
+		u += "?" + ri.r.URL.RawQuery
+	}
+	return u
+}
+
+// Query returns the first value of the query parameter name, or an empty
+// string if there is none
+func (ri *requestInfo) Query(name string) string {
+	return ri.r.URL.Query().Get(name)
+}
+
+// Active reports whether the current page is path or lies beneath it
+func (ri *requestInfo) Active(path string) bool {
+	p := ri.r.URL.Path
+	return p == path || path != "/" && strings.HasPrefix(p, strings.TrimSuffix(path, "/")+"/")
+}
+
+// IsAdmin reports whether the request carries the admin's credentials, using
+// HTTP basic authentication
+func (ri *requestInfo) IsAdmin() bool {
+	username, password, ok := ri.r.BasicAuth()
+	admin := siteConfig().Admin
+	return ok && siteConfigured() && username == admin.Username && admin.CheckPassword(password)
+}
+
+// requireAdmin reports whether r carries the admin's credentials, otherwise
+// asking for them
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if (&requestInfo{r}).IsAdmin() {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="gournal", charset="UTF-8"`)
+	renderError(w, r, "the admin's username and password are required", http.StatusUnauthorized)
+	return false
+}
+
+// Language returns the visitor's preferred language from the Accept-Language
+// header as a BCP 47 tag such as "en-GB", or an empty string if they sent none
+func (ri *requestInfo) Language() string {
+	tags, _, err := language.ParseAcceptLanguage(ri.r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+	return tags[0].String()
+}
 
 // renderTemplate is a utility function to simplify rendering a nested template
-// tmpl with data
-func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
-	t := template.Must(template.ParseFiles("templates/"+tmpl+".html", "templates/layout.html"))
+// tmpl with data, in response to r
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	csrf := csrfToken(w, r)
+	t := template.Must(template.New(tmpl).Funcs(template.FuncMap{
+		"site":            siteConfig,
+		"join":            strings.Join,
+		"consentRequired": consent.Required,
+		"consentFeatures": consent.Optional,
+		"snippet":         article.SnippetPolicy.Clean,
+		"glossary":        terms.Annotate,
+		"typeset":         typeset.HTML,
+		"typesetTitle":    typeset.Title,
+		"permalink":       article.Permalink,
+		"theme":           func() map[string]interface{} { return assets.Values(siteConfig().Theme) },
+		"request":         func() *requestInfo { return &requestInfo{r} },
+		"feeds":           func() []feedLink { return feedLinks(r) },
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type='hidden' name='csrf_token' value='` + csrf + `' />`)
+		},
+		"breadcrumbs": func(crumbs ...string) (*breadcrumb.Trail, error) {
+			return breadcrumb.New(baseURL(r), siteConfig().SiteTitle, crumbs...)
+		},
+	}).ParseFiles(theme.Template(tmpl+".html"), theme.Template("layout.html")))
 	/*
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -153,6 +3027,9 @@ func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
 	*/
 	err := t.ExecuteTemplate(w, "layout", data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		// not the error page, which may fail to render the same way
+		logger(r).Error("rendering a template", "template", tmpl, "method", r.Method, "path", r.URL.Path, "error", err)
+		templateErrors.WithLabelValues(tmpl).Inc()
+		http.Error(w, err.Error()+"\nRequest ID: "+requestID(r), http.StatusInternalServerError)
 	}
 }
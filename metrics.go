@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/firegoby/gournal/article"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics ====================================================================
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gournal_http_requests_total",
+		Help: "Requests served, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gournal_http_request_duration_seconds",
+		Help:    "Time taken to serve requests, by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	storageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gournal_storage_operation_duration_seconds",
+		Help:    "Time taken by operations on the storage backend, by operation and whether they failed.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+	}, []string{"op", "result"})
+	templateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gournal_template_render_errors_total",
+		Help: "Pages whose templates failed to render, by template.",
+	}, []string{"template"})
+	articlesDesc = prometheus.NewDesc("gournal_articles", "Articles stored, by whether they're published or scheduled.", []string{"state"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration, storageDuration, templateErrors, articleCollector{})
+}
+
+// MetricsHandler exposes the site's metrics to the admin, for Prometheus to
+// scrape with their credentials
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	promhttp.Handler().ServeHTTP(w, r)
+}
+
+// measureRequests counts the requests h serves, and times them, by the route
+// they match, see nameRoutes
+func measureRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := routeOf(r)
+			requestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+			requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+// timeStore times the operations on the storage backend s for the metrics
+func timeStore(s article.Store) article.Store {
+	return &article.TimedStore{Store: s, Observe: func(op string, d time.Duration, err error) {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		storageDuration.WithLabelValues(op, result).Observe(d.Seconds())
+	}}
+}
+
+// articleCollector counts the articles as they're scraped
+type articleCollector struct{}
+
+// Describe implements prometheus.Collector
+func (articleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- articlesDesc
+}
+
+// Collect implements prometheus.Collector
+func (articleCollector) Collect(ch chan<- prometheus.Metric) {
+	entries, err := article.List()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(articlesDesc, err)
+		return
+	}
+	var published, scheduled int
+	now := time.Now()
+	for _, e := range entries {
+		if e.PublishAt.After(now) {
+			scheduled++
+		} else {
+			published++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(articlesDesc, prometheus.GaugeValue, float64(published), "published")
+	ch <- prometheus.MustNewConstMetric(articlesDesc, prometheus.GaugeValue, float64(scheduled), "scheduled")
+}
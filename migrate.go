@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/firegoby/gournal/article"
+	"github.com/firegoby/gournal/config"
+)
+
+// runMigrate implements the `gournal migrate` command, copying every article,
+// revision, trashed article and redirect from the storage backend -from to
+// -to, e.g. from file to sqlite, with the settings for each in the config
+// file, then checking the two hold the same, see article.Migrate
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "the storage `backend` to copy from, e.g. file")
+	to := fs.String("to", "", "the storage `backend` to copy to, e.g. sqlite, which must hold no articles yet")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		fatal("migrate: set both -from and -to")
+	}
+	if *from == *to {
+		fatal("migrate: -from and -to must be different backends")
+	}
+
+	cfg, err := config.Load()
+	if os.IsNotExist(err) {
+		cfg = &config.Config{}
+	} else if err != nil {
+		fatal("migrate: loading the configuration", "config", config.File, "error", err)
+	}
+	src, _, err := openBackend(cfg, *from)
+	if err != nil {
+		fatal("migrate: opening a backend", "storage", *from, "error", err)
+	}
+	defer closeBackend(src)
+	dst, path, err := openBackend(cfg, *to)
+	if err != nil {
+		fatal("migrate: opening a backend", "storage", *to, "error", err)
+	}
+	defer closeBackend(dst)
+
+	sum, err := article.Migrate(dst, src)
+	if err != nil {
+		closeBackend(src)
+		closeBackend(dst)
+		fatal("migrate: migrating", "from", *from, "to", *to, "error", err)
+	}
+	fmt.Printf("Migrated %v to %s\n", sum, path)
+	if cfg.Storage != *to {
+		fmt.Printf("Set \"Storage\": %q in %s to serve the site from it\n", *to, config.File)
+	}
+}
+
+// runImport implements the `gournal import` command, copying every article,
+// revision, trashed article and redirect from the storage backend -from to
+// -to, with the settings for each in the config file, like `gournal migrate`
+// but into a backend which may already hold articles, e.g. one migrated to
+// earlier, updating those which changed since, see article.Importer
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	from := fs.String("from", "", "the storage `backend` to copy from, e.g. file")
+	to := fs.String("to", "", "the storage `backend` to copy to, e.g. sqlite")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		fatal("import: set both -from and -to")
+	}
+	if *from == *to {
+		fatal("import: -from and -to must be different backends")
+	}
+
+	cfg, err := config.Load()
+	if os.IsNotExist(err) {
+		cfg = &config.Config{}
+	} else if err != nil {
+		fatal("import: loading the configuration", "config", config.File, "error", err)
+	}
+	src, _, err := openBackend(cfg, *from)
+	if err != nil {
+		fatal("import: opening a backend", "storage", *from, "error", err)
+	}
+	defer closeBackend(src)
+	dst, path, err := openBackend(cfg, *to)
+	if err != nil {
+		fatal("import: opening a backend", "storage", *to, "error", err)
+	}
+	defer closeBackend(dst)
+
+	rep, err := dst.Import(src)
+	if err != nil {
+		closeBackend(src)
+		closeBackend(dst)
+		fatal("import: importing", "from", *from, "to", *to, "error", err)
+	}
+	fmt.Printf("Imported into %s: %d created, %d updated and %d skipped as unchanged\n", path, rep.Created, rep.Updated, rep.Skipped)
+}
+
+// closeBackend closes s if it's a database
+func closeBackend(s article.Store) {
+	if c, ok := s.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("closing a backend", "error", err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package minify shrinks the HTML pages gournal serves by collapsing the
+// whitespace between words and tags and stripping comments, leaving the text
+// of elements where whitespace matters, such as <pre> and <code>, alone.
+package minify
+
+import (
+	"bytes"
+	"io"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// preserved lists the elements whose text is left as written
+var preserved = map[atom.Atom]bool{
+	atom.Code: true, atom.Pre: true, atom.Script: true, atom.Style: true,
+	atom.Textarea: true,
+}
+
+// HTML writes the HTML src to w minified. Conditional comments, which old
+// versions of Internet Explorer act on, are kept.
+func HTML(w io.Writer, src []byte) error {
+	z := html.NewTokenizer(bytes.NewReader(src))
+	var buf bytes.Buffer
+	// how many preserved elements the tokenizer is within
+	depth := 0
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return z.Err()
+			}
+			_, err := w.Write(buf.Bytes())
+			return err
+		case html.CommentToken:
+			if raw := z.Raw(); bytes.HasPrefix(raw, []byte("<!--[if")) {
+				buf.Write(raw)
+			}
+		case html.TextToken:
+			if depth > 0 {
+				buf.Write(z.Raw())
+				break
+			}
+			text := collapse(z.Raw())
+			if b := buf.Bytes(); len(b) > 0 && isSpace(b[len(b)-1]) && len(text) > 0 && isSpace(text[0]) {
+				// the whitespace either side of a stripped comment
+				text = text[1:]
+			}
+			buf.Write(text)
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			if a := atom.Lookup(name); preserved[a] {
+				if tt == html.StartTagToken {
+					depth++
+				} else if depth > 0 {
+					depth--
+				}
+			}
+			buf.Write(z.Raw())
+		default:
+			buf.Write(z.Raw())
+		}
+	}
+}
+
+// collapse replaces each run of whitespace in text with a single space, or a
+// newline if the run held one, to keep the source readable
+func collapse(text []byte) []byte {
+	res := make([]byte, 0, len(text))
+	for i := 0; i < len(text); {
+		if !isSpace(text[i]) {
+			res = append(res, text[i])
+			i++
+			continue
+		}
+		space := byte(' ')
+		for ; i < len(text) && isSpace(text[i]); i++ {
+			if text[i] == '\n' {
+				space = '\n'
+			}
+		}
+		res = append(res, space)
+	}
+	return res
+}
+
+// isSpace reports whether c is HTML whitespace
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/firegoby/gournal/mirror"
+)
+
+// runMirror implements the `gournal mirror` command, archiving the site
+// running at -url to a directory browsable offline and, with -warc, a WARC
+// file, e.g. from cron
+func runMirror(args []string) {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	site := fs.String("url", "http://localhost"+addr, "the `URL` of the running site to archive")
+	dir := fs.String("dir", "site-mirror", "save the pages and assets to `dir`, or nowhere if empty")
+	warc := fs.String("warc", "", "also write them to the WARC `file`, e.g. site.warc.gz")
+	max := fs.Int("max", mirror.MaxURLs, "fetch at most this many URLs")
+	fs.Parse(args)
+
+	base, err := url.Parse(*site)
+	if err != nil || base.Host == "" {
+		fatal("mirror: -url must be an absolute URL", "url", *site)
+	}
+	if *dir == "" && *warc == "" {
+		fatal("mirror: set -dir, -warc or both")
+	}
+	m := &mirror.Mirror{Base: base, Dir: *dir, Max: *max}
+	n, err := runArchive(m, *warc)
+	if err != nil {
+		fatal("mirror: archiving the site", "url", *site, "error", err)
+	}
+	fmt.Printf("Archived %d pages and assets of %s\n", n, base)
+}
+
+// runArchive runs m, writing a WARC file to file too unless it's empty
+func runArchive(m *mirror.Mirror, file string) (int, error) {
+	if file == "" {
+		return m.Run()
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, err
+	}
+	m.WARC, err = mirror.NewWARC(f, filepath.Base(file), "gournal")
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	n, err := m.Run()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// archiveScheduled periodically archives the site served by h as a WARC file
+// in dir, named after when it was made, fetching its pages from h directly
+// rather than over the network
+func archiveScheduled(h http.Handler, dir string, interval time.Duration) {
+	for range time.Tick(interval) {
+		base, err := url.Parse(siteConfig().BaseURL)
+		if err != nil || base.Host == "" {
+			base = &url.URL{Scheme: "http", Host: "localhost"}
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			slog.Error("archiving the site", "dir", dir, "error", err)
+			continue
+		}
+		file := filepath.Join(dir, strings.Replace(base.Host, ":", "_", -1)+"-"+time.Now().UTC().Format("20060102T150405Z")+".warc.gz")
+		m := &mirror.Mirror{Base: base, Client: &http.Client{Transport: handlerTransport{h}}}
+		n, err := runArchive(m, file)
+		if err != nil {
+			slog.Error("archiving the site", "file", file, "error", err)
+			continue
+		}
+		slog.Info("archived the site", "pages", n, "file", file)
+	}
+}
+
+// handlerTransport answers requests with a Handler rather than over the
+// network
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
@@ -0,0 +1,213 @@
+// Package mirror crawls a site for archival, saving every page and asset it
+// can reach as a wget-style mirror, a directory of files which can be browsed
+// offline, and as a WARC file for submission to web archives.
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MaxURLs is the default for the most URLs a Mirror fetches, so a site whose
+// links go on without end, e.g. through calendars, is still archived in time
+const MaxURLs = 10000
+
+// skip matches the paths of pages only of use to the admin, which aren't
+// archived, or followed
+var skip = regexp.MustCompile(`^/(articles/new|articles/[^/]+/(edit|revisions|profile)(/.*)?|admin/.*|backup(/.*)?|metrics|trash|setup|annotations|contact/messages.*|glossary)$`)
+
+// links match the URLs pages, feeds and stylesheets link to
+var links = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\s(?:href|src|poster)\s*=\s*["']([^"']+)["']`),
+	regexp.MustCompile(`(?i)\ssrcset\s*=\s*["']([^"']+)["']`),
+	regexp.MustCompile(`(?i)url\(\s*["']?([^"')]+)["']?\s*\)`),
+	regexp.MustCompile(`(?i)<loc>\s*([^<\s]+)\s*</loc>`),
+}
+
+// A Mirror crawls the site at Base, from its home page and sitemap, following
+// the links of every page, feed and stylesheet on the same host, but not
+// those of forms. It saves those answered 200 OK to Dir, if set, and WARC, if
+// set.
+type Mirror struct {
+	Base   *url.URL
+	Client *http.Client
+	Dir    string
+	WARC   *WARC
+	// the most URLs to fetch (default MaxURLs)
+	Max int
+}
+
+// Run crawls the site, returning the number of pages and assets saved
+func (m *Mirror) Run() (n int, err error) {
+	client, max := m.Client, m.Max
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if max == 0 {
+		max = MaxURLs
+	}
+
+	queue := []*url.URL{m.Base.ResolveReference(&url.URL{Path: "/"}), m.Base.ResolveReference(&url.URL{Path: "/sitemap.xml"})}
+	seen := map[string]bool{}
+	for _, u := range queue {
+		seen[u.String()] = true
+	}
+	for i := 0; i < len(queue) && i < max; i++ {
+		u := queue[i]
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return n, err
+		}
+		req.Header.Set("User-Agent", "gournal-mirror")
+		resp, err := client.Do(req)
+		if err != nil {
+			return n, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return n, fmt.Errorf("mirror: %s: %v", u, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+
+		ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if crawlable(ct) {
+			for _, link := range m.links(u, body) {
+				if !seen[link.String()] {
+					seen[link.String()] = true
+					queue = append(queue, link)
+				}
+			}
+		}
+		if m.WARC != nil {
+			if err := m.WARC.Record(req, resp, body); err != nil {
+				return n, err
+			}
+		}
+		if m.Dir != "" {
+			if ct == "text/html" {
+				body = m.localize(u, body)
+			}
+			file := filepath.Join(m.Dir, filepath.FromSlash(File(u, ct)))
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				return n, err
+			}
+			if err := ioutil.WriteFile(file, body, 0644); err != nil {
+				return n, err
+			}
+		}
+		n++
+	}
+	return n, nil
+}
+
+// crawlable reports whether responses of the media type ct link to others
+func crawlable(ct string) bool {
+	switch ct {
+	case "text/html", "text/css", "application/xml", "text/xml", "application/rss+xml", "application/atom+xml":
+		return true
+	}
+	return false
+}
+
+// links returns the URLs on the site the response to u, holding body, links to
+func (m *Mirror) links(u *url.URL, body []byte) (res []*url.URL) {
+	for _, re := range links {
+		for _, match := range re.FindAllSubmatch(body, -1) {
+			refs := []string{string(match[1])}
+			if bytes.Contains(bytes.ToLower(match[0]), []byte("srcset")) {
+				refs = srcset(refs[0])
+			}
+			for _, ref := range refs {
+				if link := m.resolve(u, ref); link != nil {
+					res = append(res, link)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// resolve returns the URL ref, linked to from u, if it is on the site and
+// worth archiving, or else nil
+func (m *Mirror) resolve(u *url.URL, ref string) *url.URL {
+	ref = strings.Replace(ref, "&amp;", "&", -1)
+	r, err := url.Parse(ref)
+	if err != nil {
+		return nil
+	}
+	link := u.ResolveReference(r)
+	link.Fragment = ""
+	if link.Host != m.Base.Host || (link.Scheme != "http" && link.Scheme != "https") || skip.MatchString(link.Path) {
+		return nil
+	}
+	link.Scheme = m.Base.Scheme
+	return link
+}
+
+// srcset returns the URLs of the images listed in a srcset attribute
+func srcset(s string) (res []string) {
+	for _, candidate := range strings.Split(s, ",") {
+		if f := strings.Fields(candidate); len(f) > 0 {
+			res = append(res, f[0])
+		}
+	}
+	return res
+}
+
+// localize rewrites the links of the page at u, holding body, to the site's
+// other pages and assets as links to the files they're saved to, relative to
+// the page's own
+func (m *Mirror) localize(u *url.URL, body []byte) []byte {
+	from := path.Dir("/" + File(u, "text/html"))
+	re := links[0]
+	return re.ReplaceAllFunc(body, func(attr []byte) []byte {
+		match := re.FindSubmatchIndex(attr)
+		ref := string(attr[match[2]:match[3]])
+		link := m.resolve(u, ref)
+		if link == nil {
+			return attr
+		}
+		ct := "text/html"
+		if path.Ext(link.Path) != "" {
+			ct = ""
+		}
+		rel, err := filepath.Rel(from, "/"+File(link, ct))
+		if err != nil {
+			return attr
+		}
+		return append(append(append([]byte{}, attr[:match[2]]...), filepath.ToSlash(rel)...), attr[match[3]:]...)
+	})
+}
+
+// File returns the path, relative to a mirror's directory, the response to u
+// of media type ct is saved to: pages get a .html extension, so a browser
+// opens them from disk, with index.html for directories, and the query, if
+// any, is kept after an @, as wget does
+func File(u *url.URL, ct string) string {
+	p := strings.TrimPrefix(u.Path, "/")
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += "index"
+		if ct == "" {
+			ct = "text/html"
+		}
+	}
+	if u.RawQuery != "" {
+		p += "@" + strings.Replace(u.RawQuery, "/", "%2F", -1)
+	}
+	if ct == "text/html" && path.Ext(u.Path) != ".html" {
+		p += ".html"
+	}
+	return p
+}
@@ -0,0 +1,116 @@
+package mirror
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// A WARC writes the requests and responses made crawling a site as a WARC 1.1
+// file (ISO 28500), each record gzipped separately, as web archives such as
+// the Internet Archive expect of .warc.gz files
+type WARC struct {
+	w io.Writer
+}
+
+// NewWARC returns a WARC writing to w, having written the warcinfo record
+// naming the file and the software making it
+func NewWARC(w io.Writer, filename, software string) (*WARC, error) {
+	wa := &WARC{w: w}
+	info := fmt.Sprintf("software: %s\r\nformat: WARC File Format 1.1\r\nconformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n", software)
+	err := wa.write(http.Header{
+		"Warc-Type":     {"warcinfo"},
+		"Warc-Filename": {filename},
+		"Content-Type":  {"application/warc-fields"},
+	}, []byte(info))
+	return wa, err
+}
+
+// Record writes the request req, and the response resp to it, whose body has
+// already been read into body
+func (wa *WARC) Record(req *http.Request, resp *http.Response, body []byte) error {
+	reqBlock, err := httputil.DumpRequest(req, false)
+	if err != nil {
+		return err
+	}
+	// the body is dumped as it was received, the client having decompressed
+	// it if need be
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Del("Content-Encoding")
+	respBlock, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return err
+	}
+
+	respID := recordID()
+	err = wa.write(http.Header{
+		"Warc-Type":           {"response"},
+		"Warc-Record-Id":      {respID},
+		"Warc-Target-Uri":     {req.URL.String()},
+		"Warc-Payload-Digest": {digest(body)},
+		"Content-Type":        {"application/http; msgtype=response"},
+	}, respBlock)
+	if err != nil {
+		return err
+	}
+	return wa.write(http.Header{
+		"Warc-Type":          {"request"},
+		"Warc-Target-Uri":    {req.URL.String()},
+		"Warc-Concurrent-To": {respID},
+		"Content-Type":       {"application/http; msgtype=request"},
+	}, reqBlock)
+}
+
+// write writes a record with the named fields, plus those every record has,
+// and block as its content, as a gzip member of its own
+func (wa *WARC) write(fields http.Header, block []byte) error {
+	if fields.Get("Warc-Record-Id") == "" {
+		fields.Set("Warc-Record-Id", recordID())
+	}
+	fields.Set("Warc-Date", time.Now().UTC().Format(time.RFC3339))
+	fields.Set("Warc-Block-Digest", digest(block))
+	fields.Set("Content-Length", fmt.Sprint(len(block)))
+
+	gz := gzip.NewWriter(wa.w)
+	io.WriteString(gz, "WARC/1.1\r\n")
+	// the field names are canonicalised as WARC-Type, etc.
+	for name, values := range fields {
+		if len(name) > 5 && name[:5] == "Warc-" {
+			name = "WARC-" + name[5:]
+		}
+		for _, v := range values {
+			fmt.Fprintf(gz, "%s: %s\r\n", name, v)
+		}
+	}
+	io.WriteString(gz, "\r\n")
+	gz.Write(block)
+	io.WriteString(gz, "\r\n\r\n")
+	return gz.Close()
+}
+
+// recordID returns a new WARC-Record-ID, a random UUID URN
+func recordID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("mirror: cannot generate record ID: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// digest returns the SHA-1 digest of b, in base 32, as web archives record it
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/firegoby/gournal/annotation"
+	"github.com/firegoby/gournal/article"
+	"github.com/firegoby/mux"
+)
+
+// Render Profiles ============================================================
+
+// A renderStage records the time taken, and memory allocated, by a stage of
+// rendering an article
+type renderStage struct {
+	Name     string
+	Duration time.Duration
+	// the bytes and number of objects allocated
+	Bytes  uint64
+	Allocs uint64
+}
+
+// A renderProfile breaks rendering an article's page down into stages, with
+// measures of the article which commonly make it slow, such as its size and
+// how many images and code blocks it has
+type renderProfile struct {
+	Article       *article.Article
+	Stages        []renderStage
+	Total         renderStage
+	Words         int
+	BodyBytes     int
+	HTMLBytes     int
+	PageBytes     int
+	Images        int
+	CodeBlocks    int
+	IncludeErrors []*article.IncludeError
+	// the heap in use once the page was rendered
+	HeapBytes uint64
+}
+
+// ProfileArticleHandler shows the admin how long each stage of rendering an
+// article's page takes, and how much memory it allocates: loading it,
+// expanding its includes, converting its Markdown, including the variants of
+// its images, sanitizing the HTML, marking up glossary terms, typesetting,
+// finding related articles, and rendering the page's template, which runs the
+// stages from Markdown to typesetting again. Memory is measured for the whole process, so
+// is only telling while the site is quiet.
+func ProfileArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	p := &renderProfile{}
+
+	var a *article.Article
+	err := p.stage("Load", func() (err error) {
+		a, err = article.Load(mux.Vars(r)["title"])
+		return
+	})
+	if os.IsNotExist(err) {
+		renderError(w, r, "", http.StatusNotFound)
+		return
+	} else if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.Article, p.Words, p.BodyBytes = a, a.WordCount(), len(a.Body)
+
+	var body string
+	p.stage("Includes", func() error {
+		body, p.IncludeErrors = a.ExpandIncludes()
+		return nil
+	})
+	var buf bytes.Buffer
+	err = p.stage("Markdown", func() error {
+		return article.Renderer.Convert([]byte(body), &buf)
+	})
+	var html template.HTML
+	if err == nil {
+		p.stage("Sanitizing", func() error {
+			html = template.HTML(article.Sanitizer.SanitizeBytes(buf.Bytes()))
+			return nil
+		})
+		err = p.stage("Glossary", func() (err error) {
+			html, err = terms.Annotate(html)
+			return
+		})
+	}
+	if err == nil {
+		err = p.stage("Typography", func() (err error) {
+			html, err = typeset.HTML(html)
+			return
+		})
+	}
+	if err != nil {
+		renderError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.HTMLBytes = len(html)
+	p.Images = strings.Count(string(html), "<img")
+	p.CodeBlocks = strings.Count(string(html), "<pre")
+
+	var related []*article.Article
+	p.stage("Related articles", func() error {
+		related = relatedArticles(a)
+		return nil
+	})
+	page := httptest.NewRecorder()
+	p.stage("Template", func() error {
+		mode := annotationMode(a)
+		var highlights []annotation.Annotation
+		if mode == annotation.Native {
+			highlights = annotations.Approved(a.ID)
+		}
+		renderTemplate(page, r, "show_article", showArticlePage{a, related, "", false, 0, mode, highlights})
+		return nil
+	})
+	p.PageBytes = page.Body.Len()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	p.HeapBytes = mem.HeapAlloc
+	renderTemplate(w, r, "profile", p)
+}
+
+// stage runs fn, a stage of rendering the article, recording it as name
+func (p *renderProfile) stage(name string, fn func() error) error {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	err := fn()
+	d := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	s := renderStage{
+		Name:     name,
+		Duration: d,
+		Bytes:    after.TotalAlloc - before.TotalAlloc,
+		Allocs:   after.Mallocs - before.Mallocs,
+	}
+	p.Stages = append(p.Stages, s)
+	p.Total.Duration += s.Duration
+	p.Total.Bytes += s.Bytes
+	p.Total.Allocs += s.Allocs
+	return err
+}
@@ -0,0 +1,74 @@
+// annotate.js lets readers highlight a passage of an article by selecting it,
+// offering a form to add a note and their name, which is posted to gournal and
+// shown with the article once a moderator approves it.
+(function () {
+    var body = document.getElementById('article-body');
+    if (!body || !body.getAttribute('data-annotations')) {
+        return;
+    }
+    var url = body.getAttribute('data-annotations');
+
+    var form = document.createElement('form');
+    form.className = 'annotate';
+    form.style.display = 'none';
+    form.innerHTML = '<blockquote></blockquote>' +
+        '<textarea name="note" class="short" placeholder="note (optional)&hellip;"></textarea>' +
+        '<input type="text" name="name" placeholder="your name (optional)" />' +
+        '<p class="secondary"></p>' +
+        '<button type="submit">Highlight</button> ' +
+        '<button type="button" class="secondary">Cancel</button>';
+    document.body.appendChild(form);
+    var quote = form.querySelector('blockquote');
+    var note = form.querySelector('textarea');
+    var name = form.querySelector('input');
+    var status = form.querySelector('p');
+
+    function hide() {
+        form.style.display = 'none';
+    }
+
+    // the text selected within the article body, if any
+    function selected() {
+        var sel = window.getSelection();
+        if (!sel || sel.isCollapsed || !body.contains(sel.anchorNode) || !body.contains(sel.focusNode)) {
+            return '';
+        }
+        return sel.toString().replace(/\s+/g, ' ').trim();
+    }
+
+    body.addEventListener('mouseup', function (e) {
+        var text = selected();
+        if (!text) {
+            return;
+        }
+        quote.textContent = text;
+        status.textContent = '';
+        note.value = '';
+        form.style.left = Math.min(e.pageX, document.documentElement.clientWidth - 340) + 'px';
+        form.style.top = (e.pageY + 10) + 'px';
+        form.style.display = 'block';
+    });
+
+    form.querySelector('button.secondary').onclick = hide;
+
+    form.onsubmit = function (e) {
+        e.preventDefault();
+        var data = new URLSearchParams({
+            quote: quote.textContent,
+            note: note.value,
+            name: name.value
+        });
+        fetch(url, {method: 'POST', body: data, credentials: 'same-origin'}).then(function (res) {
+            if (res.ok) {
+                status.textContent = 'Thanks, your highlight will appear once it has been approved.';
+                setTimeout(hide, 2500);
+                return;
+            }
+            return res.text().then(function (msg) {
+                status.textContent = msg;
+            });
+        }, function () {
+            status.textContent = "Your highlight couldn't be saved, please try again.";
+        });
+    };
+})();
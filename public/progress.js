@@ -0,0 +1,56 @@
+// progress.js remembers how far a reader has got through a long article, by
+// posting their position to gournal as they scroll, and takes them back there
+// when they follow the "Continue where you left off" link. Positions are in
+// thousandths of the article body, measured at the bottom of the window.
+(function () {
+    var body = document.getElementById('article-body');
+    if (!body || !body.getAttribute('data-progress')) {
+        return;
+    }
+    var url = body.getAttribute('data-progress');
+    var sent = -1;
+    var timer = null;
+
+    function position() {
+        var rect = body.getBoundingClientRect();
+        var read = (window.innerHeight - rect.top) / rect.height;
+        return Math.round(Math.max(0, Math.min(1, read)) * 1000);
+    }
+
+    function send() {
+        timer = null;
+        var pos = position();
+        // don't bother gournal with every small scroll
+        if (Math.abs(pos - sent) < 20) {
+            return;
+        }
+        sent = pos;
+        var data = new URLSearchParams({position: pos});
+        if (navigator.sendBeacon) {
+            navigator.sendBeacon(url, data);
+        } else {
+            fetch(url, {method: 'POST', body: data, credentials: 'same-origin'});
+        }
+    }
+
+    window.addEventListener('scroll', function () {
+        if (!timer) {
+            timer = setTimeout(send, 2000);
+        }
+    });
+    document.addEventListener('visibilitychange', function () {
+        if (document.visibilityState === 'hidden') {
+            send();
+        }
+    });
+
+    var resume = document.getElementById('resume');
+    if (resume) {
+        resume.onclick = function (e) {
+            e.preventDefault();
+            var rect = body.getBoundingClientRect();
+            var bottom = window.pageYOffset + rect.top + rect.height * resume.getAttribute('data-position') / 1000;
+            window.scrollTo(0, Math.max(0, bottom - window.innerHeight));
+        };
+    }
+})();
@@ -0,0 +1,77 @@
+// review.js lets reviewers following a draft's preview link comment on its
+// paragraphs, by clicking one, offering a form to add a comment and their
+// name, which is posted to gournal and shown to the author in the editor.
+(function () {
+    var body = document.getElementById('article-body');
+    if (!body || !body.getAttribute('data-reviews')) {
+        return;
+    }
+    var url = body.getAttribute('data-reviews');
+    var token = body.getAttribute('data-token');
+
+    var form = document.createElement('form');
+    form.className = 'annotate';
+    form.style.display = 'none';
+    form.innerHTML = '<blockquote></blockquote>' +
+        '<textarea name="note" class="short" placeholder="comment&hellip;"></textarea>' +
+        '<input type="text" name="name" placeholder="your name (optional)" />' +
+        '<p class="secondary"></p>' +
+        '<button type="submit">Comment</button> ' +
+        '<button type="button" class="secondary">Cancel</button>';
+    document.body.appendChild(form);
+    var quote = form.querySelector('blockquote');
+    var note = form.querySelector('textarea');
+    var name = form.querySelector('input');
+    var status = form.querySelector('p');
+    var paragraph = -1;
+
+    function hide() {
+        form.style.display = 'none';
+    }
+
+    // the paragraphs are the blocks directly within the article body, numbered
+    // in order as the author's editor lists the comments on them
+    var blocks = body.children;
+    body.addEventListener('click', function (e) {
+        var block = e.target;
+        while (block && block.parentNode !== body) {
+            block = block.parentNode;
+        }
+        if (!block || e.target.closest('a')) {
+            return;
+        }
+        paragraph = Array.prototype.indexOf.call(blocks, block);
+        quote.textContent = block.textContent.replace(/\s+/g, ' ').trim().slice(0, 200);
+        status.textContent = '';
+        note.value = '';
+        form.style.left = Math.min(e.pageX, document.documentElement.clientWidth - 340) + 'px';
+        form.style.top = (e.pageY + 10) + 'px';
+        form.style.display = 'block';
+        note.focus();
+    });
+
+    form.querySelector('button.secondary').onclick = hide;
+
+    form.onsubmit = function (e) {
+        e.preventDefault();
+        var data = new URLSearchParams({
+            token: token,
+            paragraph: paragraph,
+            quote: quote.textContent,
+            note: note.value,
+            name: name.value
+        });
+        fetch(url, {method: 'POST', body: data, credentials: 'same-origin'}).then(function (res) {
+            if (res.ok) {
+                status.textContent = 'Thanks, your comment has been sent to the author.';
+                setTimeout(hide, 2500);
+                return;
+            }
+            return res.text().then(function (msg) {
+                status.textContent = msg;
+            });
+        }, function () {
+            status.textContent = "Your comment couldn't be saved, please try again.";
+        });
+    };
+})();
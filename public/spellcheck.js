@@ -0,0 +1,58 @@
+// spellcheck.js wires the "Check Spelling" button of the article editor to
+// gournal's /spellcheck endpoint, listing suspected problems beneath the form
+(function () {
+    var button = document.getElementById('spellcheck');
+    var results = document.getElementById('spellcheck-results');
+    var body = document.querySelector('textarea[name=body]');
+    if (!button || !results || !body) {
+        return;
+    }
+
+    function post(url, data, done) {
+        var xhr = new XMLHttpRequest();
+        xhr.open('POST', url);
+        xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+        xhr.onload = function () { done(xhr); };
+        xhr.send(data);
+    }
+
+    function check() {
+        results.textContent = 'Checking…';
+        post('/spellcheck', 'text=' + encodeURIComponent(body.value), function (xhr) {
+            results.textContent = '';
+            if (xhr.status !== 200) {
+                results.textContent = 'Spell check failed: ' + xhr.responseText;
+                return;
+            }
+            var matches = JSON.parse(xhr.responseText);
+            if (!matches.length) {
+                results.textContent = 'No problems found.';
+                return;
+            }
+            matches.forEach(function (m) {
+                var li = document.createElement('li');
+                li.textContent = (m.Word ? '“' + m.Word + '”: ' : '') + m.Message;
+                if (m.Replacements && m.Replacements.length) {
+                    li.textContent += ' (' + m.Replacements.slice(0, 3).join(', ') + ')';
+                }
+                if (m.Word) {
+                    var add = document.createElement('a');
+                    add.href = '#';
+                    add.textContent = ' add to dictionary';
+                    add.onclick = function (e) {
+                        e.preventDefault();
+                        post('/spellcheck/dictionary', 'word=' + encodeURIComponent(m.Word) +
+                            '&csrf_token=' + encodeURIComponent(body.form.elements.csrf_token.value), check);
+                    };
+                    li.appendChild(add);
+                }
+                results.appendChild(li);
+            });
+        });
+    }
+
+    button.onclick = function (e) {
+        e.preventDefault();
+        check();
+    };
+})();
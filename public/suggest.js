@@ -0,0 +1,51 @@
+// suggest.js wires the "Suggest Metadata" button of the article editor to
+// gournal's /articles/suggest endpoint. Suggestions are only copied into the
+// form when the author chooses to use them, and still need saving.
+(function () {
+    var button = document.getElementById('suggest');
+    var panel = document.getElementById('suggestions');
+    var form = button && button.form;
+    if (!button || !panel || !form) {
+        return;
+    }
+
+    function offer(label, field, value) {
+        if (!value) {
+            return;
+        }
+        var p = document.createElement('p');
+        p.className = 'secondary';
+        p.textContent = label + ': ' + value + ' ';
+        var use = document.createElement('a');
+        use.href = '#';
+        use.textContent = 'use this';
+        use.onclick = function (e) {
+            e.preventDefault();
+            form.elements[field].value = value;
+        };
+        p.appendChild(use);
+        panel.appendChild(p);
+    }
+
+    button.onclick = function (e) {
+        e.preventDefault();
+        panel.textContent = 'Thinking…';
+        var xhr = new XMLHttpRequest();
+        xhr.open('POST', '/articles/suggest');
+        xhr.setRequestHeader('Content-Type', 'application/x-www-form-urlencoded');
+        xhr.onload = function () {
+            panel.textContent = '';
+            if (xhr.status !== 200) {
+                panel.textContent = 'Suggestion failed: ' + xhr.responseText;
+                return;
+            }
+            var s = JSON.parse(xhr.responseText);
+            offer('Excerpt', 'excerpt', s.Excerpt);
+            offer('Description', 'description', s.Description);
+            offer('Tags', 'tags', (s.Tags || []).join(', '));
+        };
+        xhr.send('title=' + encodeURIComponent(form.elements.title.value) +
+            '&body=' + encodeURIComponent(form.elements.body.value) +
+            '&csrf_token=' + encodeURIComponent(form.elements.csrf_token.value));
+    };
+})();
@@ -0,0 +1,85 @@
+// Package ratelimit limits how often a client, identified by a key such as
+// their IP address, may do something.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// A Limiter allows each key Burst attempts at once, replenished at one per
+// Interval, as a token bucket.
+type Limiter struct {
+	Burst    int
+	Interval time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// bucket holds the attempts a key has left as of when it was last updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// New returns a Limiter allowing burst attempts at once, replenished at one
+// per interval
+func New(burst int, interval time.Duration) *Limiter {
+	return &Limiter{Burst: burst, Interval: interval, buckets: map[string]*bucket{}}
+}
+
+// Allow reports whether key may make an attempt now, counting it if so
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.updated)) / float64(l.Interval)
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait returns how long key must wait before its next attempt is allowed
+func (l *Limiter) Wait(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		return 0
+	}
+	tokens := b.tokens + float64(time.Since(b.updated))/float64(l.Interval)
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) * float64(l.Interval))
+}
+
+// sweep forgets the keys whose buckets have refilled, at most once per
+// Interval, so the Limiter doesn't grow with every key it has seen
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.Interval {
+		return
+	}
+	l.swept = now
+	full := time.Duration(l.Burst) * l.Interval
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package related ranks the articles most related to a given article, by
+// shared tags or, when an embeddings Index has been built, by the cosine
+// similarity of their embedding vectors.
+package related
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/firegoby/gournal/article"
+)
+
+// File returns the location on disk of the embeddings Index, in article.Dir
+func File() string {
+	return article.Dir + ".embeddings.json"
+}
+
+// An Embedder turns texts into embedding vectors, one per text.
+type Embedder interface {
+	Embed(texts []string) ([][]float64, error)
+}
+
+// OpenAIEmbedder is an Embedder backed by an OpenAI-compatible /embeddings
+// endpoint, such as OpenAI itself or a local model server.
+type OpenAIEmbedder struct {
+	URL   string
+	Key   string
+	Model string
+	HTTP  *http.Client
+}
+
+// Embed implements the Embedder interface
+func (e *OpenAIEmbedder) Embed(texts []string) ([][]float64, error) {
+	b, err := json.Marshal(map[string]interface{}{"model": e.Model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest("POST", strings.TrimRight(e.URL, "/")+"/embeddings", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if e.Key != "" {
+		r.Header.Set("Authorization", "Bearer "+e.Key)
+	}
+
+	client := e.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("related: embeddings endpoint responded %s", resp.Status)
+	}
+
+	var res struct {
+		Data []struct {
+			Index     int
+			Embedding []float64
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Data) != len(texts) {
+		return nil, errors.New("related: embeddings endpoint returned the wrong number of vectors")
+	}
+	vectors := make([][]float64, len(texts))
+	for _, d := range res.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, errors.New("related: embeddings endpoint returned an invalid index")
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+// An Index holds an embedding vector for each article, keyed by ID so that
+// vectors survive renames. Indexes built before articles had IDs are keyed by
+// slug.
+type Index struct {
+	Vectors map[string][]float64
+}
+
+// vector returns the embedding vector of a, reporting false if it has none
+func (ix *Index) vector(a *article.Article) ([]float64, bool) {
+	if v, ok := ix.Vectors[a.ID]; ok && a.ID != "" {
+		return v, true
+	}
+	v, ok := ix.Vectors[a.Slug]
+	return v, ok
+}
+
+// Load reads the Index from File, returning an empty Index if none has been
+// built yet
+func Load() (*Index, error) {
+	ix := &Index{Vectors: map[string][]float64{}}
+	b, err := ioutil.ReadFile(File())
+	if os.IsNotExist(err) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, ix)
+	return ix, err
+}
+
+// Save writes the Index to File
+func (ix *Index) Save() error {
+	b, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(File(), b, 0600)
+}
+
+// Rebuild replaces the Index with freshly computed vectors for articles,
+// embedding them batchSize at a time
+func (ix *Index) Rebuild(e Embedder, articles []*article.Article, batchSize int) error {
+	vectors := map[string][]float64{}
+	for start := 0; start < len(articles); start += batchSize {
+		end := start + batchSize
+		if end > len(articles) {
+			end = len(articles)
+		}
+		texts := make([]string, 0, end-start)
+		for _, a := range articles[start:end] {
+			texts = append(texts, a.Title+"\n\n"+a.Body)
+		}
+		res, err := e.Embed(texts)
+		if err != nil {
+			return err
+		}
+		for i, a := range articles[start:end] {
+			key := a.ID
+			if key == "" {
+				key = a.Slug
+			}
+			vectors[key] = res[i]
+		}
+	}
+	ix.Vectors = vectors
+	return nil
+}
+
+// Related returns up to n of articles most related to a, ranked by cosine
+// similarity when both have vectors in the Index, falling back to the number
+// of shared tags otherwise
+func (ix *Index) Related(a *article.Article, articles []*article.Article, n int) []*article.Article {
+	type scored struct {
+		a     *article.Article
+		score float64
+	}
+	var candidates []scored
+	v, embedded := ix.vector(a)
+	for _, other := range articles {
+		if other.Slug == a.Slug {
+			continue
+		}
+		if w, ok := ix.vector(other); embedded && ok {
+			// similarities lie in [-1, 1], rank them above any tag matches
+			candidates = append(candidates, scored{other, 1000 + Cosine(v, w)})
+		} else if shared := sharedTags(a, other); shared > 0 {
+			candidates = append(candidates, scored{other, float64(shared)})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	var res []*article.Article
+	for i := 0; i < len(candidates) && i < n; i++ {
+		res = append(res, candidates[i].a)
+	}
+	return res
+}
+
+// Cosine returns the cosine similarity of the vectors a and b
+func Cosine(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// sharedTags counts the tags a and b have in common, ignoring case
+func sharedTags(a, b *article.Article) (n int) {
+	for _, t := range a.Tags {
+		for _, u := range b.Tags {
+			if strings.EqualFold(t, u) {
+				n++
+				break
+			}
+		}
+	}
+	return
+}
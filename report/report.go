@@ -0,0 +1,171 @@
+// Package report sends the panics gournal recovers from, with their stack
+// traces, to an error tracker speaking Sentry's protocol, such as Sentry
+// itself or GlitchTip.
+package report
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// the request headers left out of reports, as they may hold credentials
+var privateHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+}
+
+// A Reporter sends events to the project identified by a Sentry DSN, of the
+// form https://key@host/project, with Client, or http.DefaultClient with a
+// timeout if it is nil.
+type Reporter struct {
+	Client *http.Client
+
+	store string // the URL events are posted to
+	key   string
+}
+
+// New returns a Reporter for dsn
+func New(dsn string) (*Reporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("report: the DSN is invalid: %v", err)
+	}
+	project := u.Path[strings.LastIndex(u.Path, "/")+1:]
+	if u.User == nil || u.User.Username() == "" || project == "" || u.Host == "" {
+		return nil, fmt.Errorf("report: the DSN %q isn't of the form https://key@host/project", u.Redacted())
+	}
+	prefix := strings.TrimSuffix(u.Path, "/"+project)
+	return &Reporter{
+		store: u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/store/",
+		key:   u.User.Username(),
+	}, nil
+}
+
+// A Frame is one call in a stack trace, in Sentry's format.
+type Frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+// Stack returns the stack trace of its caller's goroutine, oldest call first
+// as Sentry expects, skipping skip calls above its caller
+func Stack(skip int) []Frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var res []Frame
+	for {
+		f, more := frames.Next()
+		module, function := splitFunction(f.Function)
+		res = append(res, Frame{Function: function, Module: module, Filename: f.File, Lineno: f.Line})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
+
+// splitFunction splits a qualified function name such as
+// "github.com/a/b.(*T).M" into its package and function
+func splitFunction(name string) (string, string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+2+dot:]
+}
+
+// Panic reports a panic with value, recovered while serving r, at stack,
+// returning the ID of the event
+func (rep *Reporter) Panic(value interface{}, stack []Frame, r *http.Request) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	event := map[string]interface{}{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"level":     "fatal",
+		"platform":  "go",
+		"logger":    "gournal",
+		"exception": map[string]interface{}{
+			"values": []interface{}{map[string]interface{}{
+				"type":       fmt.Sprintf("%T", value),
+				"value":      fmt.Sprint(value),
+				"stacktrace": map[string]interface{}{"frames": stack},
+			}},
+		},
+	}
+	if host, err := os.Hostname(); err == nil {
+		event["server_name"] = host
+	}
+	if r != nil {
+		headers := map[string]string{}
+		for k, v := range r.Header {
+			if !privateHeaders[k] {
+				headers[k] = strings.Join(v, ", ")
+			}
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		event["request"] = map[string]interface{}{
+			"url":          scheme + "://" + r.Host + r.URL.Path,
+			"method":       r.Method,
+			"query_string": r.URL.RawQuery,
+			"headers":      headers,
+		}
+		// tagged, so the event can be found by the ID logged with the panic
+		if id := r.Header.Get("X-Request-ID"); id != "" {
+			event["tags"] = map[string]string{"request_id": id}
+		}
+	}
+	return event["event_id"].(string), rep.send(event)
+}
+
+// send posts event to the Reporter's project
+func (rep *Reporter) send(event map[string]interface{}) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", rep.store, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=gournal/1.0, sentry_key="+rep.key)
+
+	client := rep.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("report: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("report: the error tracker responded %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
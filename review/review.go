@@ -0,0 +1,157 @@
+// Package review keeps the comments reviewers leave on drafts shared with them
+// by preview links, each anchored to a paragraph, which are kept apart from
+// the highlights readers make and only shown to the author, in the editor.
+package review
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// the limits, in characters, on the parts of a Comment
+const (
+	MaxQuoteLength = 200
+	MaxNoteLength  = 2000
+	MaxNameLength  = 100
+)
+
+// A Comment is a reviewer's Note, signed Name, on the Paragraph numbered from
+// 0 of the draft with the ID Article. Quote holds the paragraph's opening
+// words, so the author can find it once the draft has been edited.
+type Comment struct {
+	ID        string
+	Article   string
+	Paragraph int
+	Quote     string
+	Note      string
+	Name      string `json:",omitempty"`
+	Created   time.Time
+}
+
+// Number returns the number of the Comment's Paragraph counting from 1, as
+// shown to the author
+func (c Comment) Number() int {
+	return c.Paragraph + 1
+}
+
+// A Store is a set of Comments, persisted as JSON in a file.
+type Store struct {
+	sync.RWMutex
+
+	path string
+	// in the order they were made
+	list []*Comment
+}
+
+// Open loads the Store kept at path, which need not exist yet
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.list); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Add validates c and stores it, returning it as stored. A Quote too long is
+// cut short rather than refused, as it is taken from the draft, not typed.
+func (s *Store) Add(c Comment) (*Comment, error) {
+	c.Quote = strings.Join(strings.Fields(c.Quote), " ")
+	if utf8.RuneCountInString(c.Quote) > MaxQuoteLength {
+		c.Quote = string([]rune(c.Quote)[:MaxQuoteLength]) + "…"
+	}
+	c.Note = strings.TrimSpace(c.Note)
+	c.Name = strings.TrimSpace(c.Name)
+	switch {
+	case c.Article == "":
+		return nil, fmt.Errorf("review: an article is required")
+	case c.Paragraph < 0:
+		return nil, fmt.Errorf("review: choose a paragraph to comment on")
+	case c.Note == "":
+		return nil, fmt.Errorf("review: write a comment")
+	case utf8.RuneCountInString(c.Note) > MaxNoteLength:
+		return nil, fmt.Errorf("review: comments may be at most %d characters", MaxNoteLength)
+	case utf8.RuneCountInString(c.Name) > MaxNameLength:
+		return nil, fmt.Errorf("review: names may be at most %d characters", MaxNameLength)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	c.ID = hex.EncodeToString(id)
+	c.Created = time.Now().UTC()
+
+	s.Lock()
+	defer s.Unlock()
+	s.list = append(s.list, &c)
+	if err := s.save(); err != nil {
+		s.list = s.list[:len(s.list)-1]
+		return nil, err
+	}
+	return &c, nil
+}
+
+// For returns the Comments on the draft with the ID article, in the order of
+// its paragraphs, and then the order they were made
+func (s *Store) For(article string) []Comment {
+	s.RLock()
+	defer s.RUnlock()
+	var res []Comment
+	for _, c := range s.list {
+		if c.Article == article {
+			res = append(res, *c)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Paragraph < res[j].Paragraph })
+	return res
+}
+
+// Get returns the Comment identified by id, or false if there's none
+func (s *Store) Get(id string) (Comment, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, c := range s.list {
+		if c.ID == id {
+			return *c, true
+		}
+	}
+	return Comment{}, false
+}
+
+// Remove deletes the Comment identified by id, e.g. once it's been dealt with
+func (s *Store) Remove(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	for i, c := range s.list {
+		if c.ID == id {
+			s.list = append(s.list[:i], s.list[i+1:]...)
+			return s.save()
+		}
+	}
+	return fmt.Errorf("review: no comment %q", id)
+}
+
+// save writes the Store to its path
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, b, 0600)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/firegoby/gournal/annotation"
+	"github.com/firegoby/gournal/article"
+	"github.com/firegoby/gournal/theme"
+)
+
+// lorem is the vocabulary used to generate seed articles
+var lorem = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing
+elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua enim ad
+minim veniam quis nostrud exercitation ullamco laboris nisi aliquip ex ea
+commodo consequat duis aute irure in reprehenderit voluptate velit esse cillum
+fugiat nulla pariatur excepteur sint occaecat cupidatat non proident sunt culpa
+qui officia deserunt mollit anim id est laborum`)
+
+// seedAuthors are the names seed articles are attributed to
+var seedAuthors = []string{"Ada", "Grace", "Ken", "Rob", "Barbara", "Edsger"}
+
+// seedReaders are the names seed comments are left by
+var seedReaders = []string{"Alan", "Donald", "Frances", "John", "Margaret", "Niklaus", "Radia", "Tony"}
+
+// the most distinct tags seedTags can be trusted to find quickly
+const seedMaxTags = 1000
+
+// the size, in pixels, of the seed images, wide enough to have variants
+const seedImageWidth, seedImageHeight = 1600, 900
+
+// runSeed implements the `gournal seed` command, generating lorem ipsum
+// articles in article.Dir, tagged and illustrated, with readers' comments, for
+// theme development and performance testing. The images are written to the
+// theme's public directory, and the comments kept as approved annotations,
+// shown when the site's Annotations are "native".
+func runSeed(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	posts := fs.Int("posts", 50, "number of articles to generate")
+	authors := fs.Int("authors", 3, "number of distinct authors to attribute articles to")
+	days := fs.Int("days", 365, "spread article dates over this many past days")
+	tags := fs.Int("tags", 10, "number of distinct tags to tag articles with, 1-4 each")
+	comments := fs.Int("comments", 3, "most comments to leave on each article")
+	imgs := fs.Int("images", 5, "number of distinct images to illustrate articles with, 0 for none")
+	fs.Parse(args)
+
+	if *authors < 1 || *authors > len(seedAuthors) {
+		fatal("seed: -authors must be between 1 and the number of sample authors", "authors", *authors, "max", len(seedAuthors))
+	}
+	if *tags < 0 || *tags > seedMaxTags {
+		fatal("seed: -tags must be between 0 and the number of sample tags", "tags", *tags, "max", seedMaxTags)
+	}
+	if *comments < 0 || *imgs < 0 {
+		fatal("seed: -comments and -images can't be negative")
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	pool := seedTags(rnd, *tags)
+	images, err := seedImages(rnd, *imgs)
+	if err != nil {
+		fatal("seed: generating images", "dir", theme.Public, "error", err)
+	}
+	annotations, err := annotation.Open(article.Dir + ".annotations.json")
+	if err != nil {
+		fatal("seed: opening the annotations", "error", err)
+	}
+
+	seen := map[string]bool{}
+	n := 0
+	for i := 0; i < *posts; i++ {
+		title := seedTitle(rnd)
+		for seen[article.Slugify(title)] || seedExists(article.Slugify(title)) {
+			title = seedTitle(rnd)
+		}
+		seen[article.Slugify(title)] = true
+
+		body := seedBody(rnd)
+		// illustrate two in three articles
+		if len(images) > 0 && rnd.Intn(3) > 0 {
+			body = fmt.Sprintf("![%s](%s)\n\n%s", title, images[rnd.Intn(len(images))], body)
+		}
+		a, err := article.New(title, body, "")
+		if err != nil {
+			fatal("seed: generating an article", "error", err)
+		}
+		a.Author = article.Author{Name: seedAuthors[rnd.Intn(*authors)]}
+		a.Tags = seedPick(rnd, pool)
+		err = a.Save()
+		if err != nil {
+			fatal("seed: saving an article", "slug", a.Slug, "error", err)
+		}
+
+		// backdate the article so listings have a realistic spread of dates
+		date := time.Now().Add(-time.Duration(rnd.Int63n(int64(*days)*24)) * time.Hour)
+		err = article.DefaultStore.Touch(a.Slug, date)
+		if err != nil {
+			fatal("seed: dating an article", "slug", a.Slug, "error", err)
+		}
+
+		for j := rnd.Intn(*comments + 1); j > 0; j-- {
+			c, err := annotations.Add(annotation.Annotation{
+				Article: a.ID,
+				Quote:   seedQuote(rnd, body),
+				Note:    seedSentence(rnd),
+				Name:    seedReaders[rnd.Intn(len(seedReaders))],
+			})
+			if err == nil {
+				err = annotations.Approve(c.ID)
+			}
+			if err != nil {
+				fatal("seed: commenting on an article", "slug", a.Slug, "error", err)
+			}
+			n++
+		}
+	}
+	fmt.Printf("Seeded %d articles, with %d tags, %d images and %d comments, in %s\n", *posts, len(pool), len(images), n, article.Dir)
+}
+
+// seedExists reports whether an article is already stored under slug
+func seedExists(slug string) bool {
+	_, err := article.Load(slug)
+	return err == nil
+}
+
+// seedTitle returns a random title of 3-8 capitalised lorem ipsum words
+func seedTitle(rnd *rand.Rand) string {
+	words := seedWords(rnd, 3+rnd.Intn(6))
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// seedBody returns 1-6 random paragraphs of lorem ipsum sentences
+func seedBody(rnd *rand.Rand) string {
+	paragraphs := make([]string, 1+rnd.Intn(6))
+	for i := range paragraphs {
+		sentences := make([]string, 2+rnd.Intn(5))
+		for j := range sentences {
+			sentences[j] = seedSentence(rnd)
+		}
+		paragraphs[i] = strings.Join(sentences, " ")
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// seedSentence returns a random sentence of 6-17 lorem ipsum words
+func seedSentence(rnd *rand.Rand) string {
+	s := strings.Join(seedWords(rnd, 6+rnd.Intn(12)), " ")
+	return strings.ToUpper(s[:1]) + s[1:] + "."
+}
+
+// seedQuote returns a random sentence of body, for a comment to highlight
+func seedQuote(rnd *rand.Rand, body string) string {
+	var sentences []string
+	for _, p := range strings.Split(body, "\n\n") {
+		if strings.HasPrefix(p, "![") {
+			continue
+		}
+		for _, s := range strings.SplitAfter(p, ". ") {
+			sentences = append(sentences, strings.TrimSpace(s))
+		}
+	}
+	return sentences[rnd.Intn(len(sentences))]
+}
+
+// seedTags returns n distinct tags, of one lorem ipsum word, or two once
+// there are more tags than words
+func seedTags(rnd *rand.Rand, n int) []string {
+	seen := map[string]bool{}
+	var res []string
+	for len(res) < n {
+		tag := lorem[rnd.Intn(len(lorem))]
+		if len(seen) >= len(lorem)/2 {
+			tag += "-" + lorem[rnd.Intn(len(lorem))]
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+// seedPick returns 1-4 distinct tags from pool, favouring those earliest in
+// it, so some tags are much more common than others, as on real sites
+func seedPick(rnd *rand.Rand, pool []string) []string {
+	if len(pool) == 0 {
+		return nil
+	}
+	picked := map[string]bool{}
+	var res []string
+	for i := 1 + rnd.Intn(4); i > 0 && len(res) < len(pool); i-- {
+		tag := pool[rnd.Intn(1+rnd.Intn(len(pool)))]
+		if !picked[tag] {
+			picked[tag] = true
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+// seedImages writes n placeholder PNGs, each a gradient between two random
+// colours, to seed/ in the theme's public directory, returning the paths
+// they're served at
+func seedImages(rnd *rand.Rand, n int) ([]string, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	dir := filepath.Join(theme.Public, "seed")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var res []string
+	for i := 0; i < n; i++ {
+		from := color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255}
+		to := color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255}
+		img := image.NewRGBA(image.Rect(0, 0, seedImageWidth, seedImageHeight))
+		for x := 0; x < seedImageWidth; x++ {
+			c := color.RGBA{seedMix(from.R, to.R, x), seedMix(from.G, to.G, x), seedMix(from.B, to.B, x), 255}
+			for y := 0; y < seedImageHeight; y++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+		name := fmt.Sprintf("seed-%d.png", i+1)
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		err = png.Encode(f, img)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, "/seed/"+name)
+	}
+	return res, nil
+}
+
+// seedMix returns the channel x pixels across a gradient from a to b
+func seedMix(a, b uint8, x int) uint8 {
+	return uint8((int(a)*(seedImageWidth-x) + int(b)*x) / seedImageWidth)
+}
+
+// seedWords returns n random words from lorem
+func seedWords(rnd *rand.Rand, n int) []string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = lorem[rnd.Intn(len(lorem))]
+	}
+	return words
+}
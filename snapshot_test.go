@@ -0,0 +1,11 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/firegoby/gournal/gournaltest"
+)
+
+func TestSnapshot(t *testing.T) {
+	gournaltest.Snapshot(t, "testdata/golden")
+}
@@ -0,0 +1,156 @@
+// Package snippet vets the custom HTML site owners and authors inject into
+// the <head> and before the </body> of pages, such as site verification tags
+// or embeds, according to a configurable Policy.
+package snippet
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A Policy decides which injected HTML is allowed.
+type Policy string
+
+const (
+	// Trusted allows any HTML, for sites whose authors are all trusted
+	Trusted Policy = "trusted"
+	// Restricted allows only <meta>, <link>, <noscript> and external <script>
+	// elements, without event handler attributes or javascript: URLs
+	Restricted Policy = "restricted"
+	// Disabled allows no injected HTML at all
+	Disabled Policy = "disabled"
+)
+
+// Policies lists every Policy a site may choose
+var Policies = []Policy{Trusted, Restricted, Disabled}
+
+// ParsePolicy returns the Policy named s, treating an empty name as Restricted
+func ParsePolicy(s string) (Policy, error) {
+	if s == "" {
+		return Restricted, nil
+	}
+	for _, p := range Policies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("snippet: unknown policy %q", s)
+}
+
+// restrictedElements are the elements allowed by the Restricted Policy
+var restrictedElements = map[atom.Atom]bool{
+	atom.Meta:     true,
+	atom.Link:     true,
+	atom.Script:   true,
+	atom.Noscript: true,
+}
+
+// Check returns an error describing the first part of s which the Policy
+// doesn't allow, or nil if it's all allowed
+func (p Policy) Check(s string) error {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	switch p {
+	case Trusted:
+		return nil
+	case Disabled:
+		return fmt.Errorf("isn't allowed on this site")
+	}
+
+	nodes, err := parse(s)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if err := checkNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clean returns the parts of s which the Policy allows, ready to be written
+// into a page unescaped
+func (p Policy) Clean(s string) template.HTML {
+	switch p {
+	case Trusted:
+		return template.HTML(s)
+	case Disabled:
+		return ""
+	}
+
+	nodes, err := parse(s)
+	if err != nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		if checkNode(n) == nil {
+			html.Render(&buf, n)
+		}
+	}
+	return template.HTML(buf.String())
+}
+
+// parse parses s as a fragment of an HTML <body>, so both head and body
+// elements are kept in place
+func parse(s string) ([]*html.Node, error) {
+	return html.ParseFragment(strings.NewReader(s), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+}
+
+// checkNode applies the Restricted Policy to n and its children
+func checkNode(n *html.Node) error {
+	switch n.Type {
+	case html.TextNode:
+		if strings.TrimSpace(n.Data) != "" && (n.Parent == nil || n.Parent.DataAtom != atom.Noscript) {
+			return fmt.Errorf("may only contain tags, not text")
+		}
+		return nil
+	case html.CommentNode:
+		return nil
+	case html.ElementNode:
+	default:
+		return fmt.Errorf("may only contain tags")
+	}
+
+	if !restrictedElements[n.DataAtom] {
+		return fmt.Errorf("may not contain <%s> tags", n.Data)
+	}
+	var src bool
+	for _, a := range n.Attr {
+		key, val := strings.ToLower(a.Key), strings.ToLower(strings.TrimSpace(a.Val))
+		if strings.HasPrefix(key, "on") {
+			return fmt.Errorf("may not use the %s attribute", a.Key)
+		}
+		if strings.HasPrefix(val, "javascript:") || strings.HasPrefix(val, "data:") {
+			return fmt.Errorf("may not use %s URLs", val[:strings.Index(val, ":")])
+		}
+		if key == "src" {
+			src = true
+		}
+	}
+	if n.DataAtom == atom.Script {
+		if !src {
+			return fmt.Errorf("may only load scripts from a src URL")
+		}
+		if n.FirstChild != nil {
+			return fmt.Errorf("may not contain inline script")
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if err := checkNode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
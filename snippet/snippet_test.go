@@ -0,0 +1,67 @@
+package snippet
+
+import (
+	"strings"
+	"testing"
+)
+
+// snippets which could run script if written into a page as they are
+var unsafeSnippets = []string{
+	"<script>alert(1)</script>",
+	`<script src="https://example.com/a.js">alert(1)</script>`,
+	`<img src="x" onerror="alert(1)">`,
+	`<meta name="x" content="y" onload="alert(1)">`,
+	`<link rel="stylesheet" href="javascript:alert(1)">`,
+	`<script src="data:text/javascript,alert(1)"></script>`,
+	`<iframe src="https://example.com"></iframe>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<div onclick="alert(1)">click</div>`,
+}
+
+func TestRestrictedRefusesScript(t *testing.T) {
+	for _, s := range unsafeSnippets {
+		if err := Restricted.Check(s); err == nil {
+			t.Errorf("Restricted.Check(%q) allowed it", s)
+		}
+	}
+}
+
+func TestRestrictedCleansScript(t *testing.T) {
+	for _, s := range unsafeSnippets {
+		clean := string(Restricted.Clean(s))
+		for _, bad := range []string{"alert(1)", "onerror", "onload", "onclick", "javascript:", "data:", "<iframe"} {
+			if strings.Contains(strings.ToLower(clean), bad) {
+				t.Errorf("Restricted.Clean(%q) = %q, which contains %q", s, clean, bad)
+			}
+		}
+		if err := Restricted.Check(clean); err != nil {
+			t.Errorf("Restricted.Clean(%q) = %q, which Check refuses: %v", s, clean, err)
+		}
+	}
+}
+
+func TestRestrictedKeepsSafeSnippets(t *testing.T) {
+	for _, s := range []string{
+		`<meta name="google-site-verification" content="abc123">`,
+		`<link rel="me" href="https://example.com/@me">`,
+		`<script async src="https://analytics.example.com/script.js"></script>`,
+	} {
+		if err := Restricted.Check(s); err != nil {
+			t.Errorf("Restricted.Check(%q): %v", s, err)
+		}
+		if clean := Restricted.Clean(s); clean == "" {
+			t.Errorf("Restricted.Clean(%q) dropped it", s)
+		}
+	}
+}
+
+func TestDisabledDropsEverything(t *testing.T) {
+	for _, s := range unsafeSnippets {
+		if Disabled.Check(s) == nil {
+			t.Errorf("Disabled.Check(%q) allowed it", s)
+		}
+		if clean := Disabled.Clean(s); clean != "" {
+			t.Errorf("Disabled.Clean(%q) = %q", s, clean)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package spellcheck provides optional server-side spelling and grammar
+// checking of article drafts through a pluggable Checker, filtered by a
+// per-site Dictionary of accepted words.
+package spellcheck
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Match is a single suspected problem in checked text.
+type Match struct {
+	Message      string
+	Offset       int
+	Length       int
+	Word         string
+	Replacements []string
+}
+
+// A Checker finds spelling and grammar problems in text written in lang, an
+// IETF language tag such as "en-GB".
+type Checker interface {
+	Check(text, lang string) ([]Match, error)
+}
+
+// LanguageTool is a Checker backed by a LanguageTool server, e.g.
+// "https://api.languagetool.org" or a self-hosted instance.
+type LanguageTool struct {
+	URL    string
+	Client *http.Client
+}
+
+// Check implements the Checker interface using LanguageTool's /v2/check API
+func (lt *LanguageTool) Check(text, lang string) ([]Match, error) {
+	client := lt.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.PostForm(strings.TrimRight(lt.URL, "/")+"/v2/check", url.Values{
+		"text":     {text},
+		"language": {lang},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("spellcheck: languagetool responded %s", resp.Status)
+	}
+
+	var res struct {
+		Matches []struct {
+			Message      string
+			Offset       int
+			Length       int
+			Replacements []struct{ Value string }
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, len(res.Matches))
+	runes := []rune(text)
+	for _, m := range res.Matches {
+		match := Match{Message: m.Message, Offset: m.Offset, Length: m.Length}
+		if m.Offset >= 0 && m.Offset+m.Length <= len(runes) {
+			match.Word = string(runes[m.Offset : m.Offset+m.Length])
+		}
+		for _, r := range m.Replacements {
+			match.Replacements = append(match.Replacements, r.Value)
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// A Dictionary is a set of words accepted by the site, persisted one word per
+// line in a text file.
+type Dictionary struct {
+	sync.RWMutex
+	path  string
+	words map[string]bool
+}
+
+// OpenDictionary loads the Dictionary stored at path, which need not exist yet
+func OpenDictionary(path string) (*Dictionary, error) {
+	d := &Dictionary{path: path, words: map[string]bool{}}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if w := strings.TrimSpace(s.Text()); w != "" {
+			d.words[strings.ToLower(w)] = true
+		}
+	}
+	return d, s.Err()
+}
+
+// Contains reports whether word has been added to the Dictionary
+func (d *Dictionary) Contains(word string) bool {
+	d.RLock()
+	defer d.RUnlock()
+	return d.words[strings.ToLower(word)]
+}
+
+// Add accepts word and persists the Dictionary
+func (d *Dictionary) Add(word string) error {
+	word = strings.ToLower(strings.TrimSpace(word))
+	if word == "" || strings.ContainsAny(word, " \t\r\n") {
+		return fmt.Errorf("spellcheck: invalid dictionary word %q", word)
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.words[word] = true
+	return d.save()
+}
+
+// save writes the Dictionary to its path, sorted alphabetically
+func (d *Dictionary) save() error {
+	words := make([]string, 0, len(d.words))
+	for w := range d.words {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	return ioutil.WriteFile(d.path, []byte(strings.Join(words, "\n")+"\n"), 0600)
+}
+
+// Filter returns the matches whose word isn't in the Dictionary
+func (d *Dictionary) Filter(matches []Match) []Match {
+	res := matches[:0]
+	for _, m := range matches {
+		if m.Word == "" || !d.Contains(m.Word) {
+			res = append(res, m)
+		}
+	}
+	return res
+}
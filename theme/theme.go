@@ -0,0 +1,237 @@
+// Package theme reads the manifest of the site's theme, the templates and
+// public assets its pages are made of. The manifest describes the theme, lists
+// the assets browsers should start fetching before they've read a page, such
+// as its CSS and fonts, and declares the options the site's admin may set to
+// customise it. Any of the theme's files may be shadowed by one of the same
+// name in Overrides, so a site can customise a single template or stylesheet
+// and still take updates to the rest of the theme.
+package theme
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Templates is the directory holding the theme's templates and its manifest
+var Templates = "./templates/"
+
+// Public is the directory holding the theme's public assets, served as they
+// are
+var Public = "./public/"
+
+// File is the name of the theme's manifest in Templates
+const File = "theme.toml"
+
+// Overrides is the directory holding the site's own versions of the theme's
+// files, at the same paths, e.g. overrides/templates/layout.html in place of
+// templates/layout.html, or overrides/public/styles.css of public/styles.css
+const Overrides = "./overrides/"
+
+// the Types of Option
+const (
+	// Color options hold a CSS hex color, e.g. #44b or #4444bb
+	Color = "color"
+	// Length options hold a CSS length, e.g. 40em or 720px
+	Length = "length"
+	// Bool options are either "true" or "false"
+	Bool = "bool"
+	// Choice options hold one of their Choices
+	Choice = "choice"
+	// Text options hold a line of text
+	Text = "text"
+)
+
+// the longest value a Text option may hold
+const maxText = 200
+
+var (
+	validColor  = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	validLength = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(px|em|rem|ch|vw|%)$`)
+)
+
+// An Asset is a file critical to rendering pages, served at Href and loaded
+// as As, e.g. "style" or "font", optionally of the MIME Type given. Fonts are
+// always fetched anonymously, as CORS requests, so CrossOrigin is implied.
+type Asset struct {
+	Href        string `toml:"href"`
+	As          string `toml:"as"`
+	Type        string `toml:"type"`
+	CrossOrigin bool   `toml:"crossorigin"`
+}
+
+// An Option is a setting of the theme, identified by Name in templates and
+// described to the admin by Label, holding a value of the given Type, or
+// Default until one is set.
+type Option struct {
+	Name    string   `toml:"name"`
+	Label   string   `toml:"label"`
+	Type    string   `toml:"type"`
+	Default string   `toml:"default"`
+	Choices []string `toml:"choices"`
+}
+
+// A Manifest describes a theme.
+type Manifest struct {
+	Name        string   `toml:"name"`
+	Version     string   `toml:"version"`
+	Author      string   `toml:"author"`
+	Description string   `toml:"description"`
+	Preload     []Asset  `toml:"preload"`
+	Options     []Option `toml:"option"`
+}
+
+// Load reads the Manifest at path, which is empty if the file doesn't exist
+func Load(path string) (*Manifest, error) {
+	m := &Manifest{}
+	_, err := toml.DecodeFile(path, m)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("theme: %s: %v", path, err)
+	}
+	for _, a := range m.Preload {
+		if a.Href == "" || a.As == "" {
+			return nil, fmt.Errorf("theme: %s: every asset to preload needs an href and as", path)
+		}
+	}
+	seen := map[string]bool{}
+	for _, o := range m.Options {
+		switch {
+		case o.Name == "" || seen[o.Name]:
+			return nil, fmt.Errorf("theme: %s: every option needs a name of its own", path)
+		case o.Type == Choice && len(o.Choices) == 0:
+			return nil, fmt.Errorf("theme: %s: the option %s has no choices", path, o.Name)
+		}
+		if err := o.Validate(o.Default); err != nil {
+			return nil, fmt.Errorf("theme: %s: the default of %v", path, err)
+		}
+		seen[o.Name] = true
+	}
+	return m, nil
+}
+
+// Link returns the value of a Link header asking browsers to preload the
+// Asset, e.g. </styles.css>; rel=preload; as=style
+func (a Asset) Link() string {
+	link := []string{"<" + a.Href + ">", "rel=preload", "as=" + a.As}
+	if a.Type != "" {
+		link = append(link, `type="`+a.Type+`"`)
+	}
+	if a.CrossOrigin || a.As == "font" {
+		link = append(link, "crossorigin")
+	}
+	return strings.Join(link, "; ")
+}
+
+// Validate returns an error if value isn't one the Option may hold
+func (o Option) Validate(value string) error {
+	ok := true
+	switch o.Type {
+	case Color:
+		ok = validColor.MatchString(value)
+	case Length:
+		ok = validLength.MatchString(value)
+	case Bool:
+		ok = value == "true" || value == "false"
+	case Choice:
+		ok = false
+		for _, c := range o.Choices {
+			ok = ok || c == value
+		}
+	case Text:
+		ok = len(value) <= maxText && !strings.ContainsAny(value, "\r\n")
+	default:
+		return fmt.Errorf("%s: unknown type %q", o.Name, o.Type)
+	}
+	if !ok {
+		return fmt.Errorf("%s: %q isn't a valid %s", o.Name, value, o.Type)
+	}
+	return nil
+}
+
+// Validate returns an error describing the first of values, keyed by Option
+// Name, which isn't one its Option may hold, or which has no Option
+func (m *Manifest) Validate(values map[string]string) error {
+	for name, value := range values {
+		o, ok := m.option(name)
+		if !ok {
+			return fmt.Errorf("theme: there is no option %s", name)
+		}
+		if err := o.Validate(value); err != nil {
+			return fmt.Errorf("theme: %v", err)
+		}
+	}
+	return nil
+}
+
+// Values returns the value of every Option, keyed by Name, taken from set or,
+// where it isn't set or isn't valid, the Option's Default. Bool options'
+// values are bools, so templates can test them with if; the rest are strings.
+func (m *Manifest) Values(set map[string]string) map[string]interface{} {
+	res := map[string]interface{}{}
+	for _, o := range m.Options {
+		value, ok := set[o.Name]
+		if !ok || o.Validate(value) != nil {
+			value = o.Default
+		}
+		if o.Type == Bool {
+			res[o.Name] = value == "true"
+		} else {
+			res[o.Name] = value
+		}
+	}
+	return res
+}
+
+// option returns the Option called name, reporting false if there is none
+func (m *Manifest) option(name string) (Option, bool) {
+	for _, o := range m.Options {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return Option{}, false
+}
+
+// Template returns the path of the site's override of the theme's template
+// file name, such as layout.html or File, if it has one, otherwise its path in
+// Templates
+func Template(name string) string {
+	override := filepath.Join(Overrides, "templates", name)
+	if fi, err := os.Stat(override); err == nil && fi.Mode().IsRegular() {
+		return override
+	}
+	return filepath.Join(Templates, name)
+}
+
+// Assets returns a http.FileSystem serving the theme's files in Public, or
+// the site's overrides of them
+func Assets() http.FileSystem {
+	return overlay{http.Dir(filepath.Join(Overrides, "public")), http.Dir(Public)}
+}
+
+// overlay is a http.FileSystem serving files from over, falling back to
+// under for those it doesn't have
+type overlay struct {
+	over, under http.FileSystem
+}
+
+// Open opens the file name from over, or from under if it doesn't exist there
+func (o overlay) Open(name string) (http.File, error) {
+	f, err := o.over.Open(name)
+	if err == nil {
+		// directories are listed from under
+		if fi, err := f.Stat(); err == nil && !fi.IsDir() {
+			return f, nil
+		}
+		f.Close()
+	}
+	return o.under.Open(name)
+}
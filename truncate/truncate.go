@@ -0,0 +1,153 @@
+// Package truncate shortens HTML, such as a rendered article, to a summary:
+// either still as HTML, cut at a word boundary without breaking its tags or
+// entities, or as plain text, for places such as meta descriptions.
+package truncate
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Ellipsis is appended to whatever is cut short
+const Ellipsis = "…"
+
+// void lists the elements which have no end tag
+var void = map[atom.Atom]bool{
+	atom.Area: true, atom.Base: true, atom.Br: true, atom.Col: true,
+	atom.Embed: true, atom.Hr: true, atom.Img: true, atom.Input: true,
+	atom.Link: true, atom.Meta: true, atom.Source: true, atom.Track: true,
+	atom.Wbr: true,
+}
+
+// block lists the elements whose text doesn't run on into their neighbours'
+var block = map[atom.Atom]bool{
+	atom.Address: true, atom.Blockquote: true, atom.Br: true, atom.Dd: true,
+	atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Figcaption: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true,
+	atom.H6: true, atom.Hr: true, atom.Li: true, atom.Ol: true, atom.P: true,
+	atom.Pre: true, atom.Td: true, atom.Th: true, atom.Tr: true, atom.Ul: true,
+}
+
+// HTML returns s cut short after its first words words, followed by an
+// Ellipsis and the end tags of any elements left open, and reports whether it
+// was cut. s is returned unchanged if it has no more words than that.
+func HTML(s string, words int) (string, bool) {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var (
+		buf  bytes.Buffer
+		open []atom.Atom
+		n    int
+		// where the last word allowed ended, and the elements open there
+		full     = -1
+		fullOpen []atom.Atom
+	)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// the end of s, reached without cutting it
+			return s, false
+		case html.TextToken:
+			text := string(z.Text())
+			prefix, cut := cutWords(text, words-n)
+			if !cut {
+				buf.WriteString(html.EscapeString(text))
+				if n += len(strings.Fields(text)); n >= words && full < 0 {
+					space := len(text) - len(strings.TrimRightFunc(text, unicode.IsSpace))
+					full, fullOpen = buf.Len()-space, append([]atom.Atom(nil), open...)
+				}
+				continue
+			}
+			if strings.TrimSpace(prefix) == "" && full >= 0 {
+				// end with the last word rather than an empty element
+				buf.Truncate(full)
+				open = fullOpen
+			} else {
+				buf.WriteString(html.EscapeString(prefix))
+			}
+			buf.WriteString(Ellipsis)
+			for i := len(open) - 1; i >= 0; i-- {
+				buf.WriteString("</" + open[i].String() + ">")
+			}
+			return buf.String(), true
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if a := atom.Lookup(name); !void[a] {
+				open = append(open, a)
+			}
+			buf.Write(z.Raw())
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == a {
+					open = open[:i]
+					break
+				}
+			}
+			buf.Write(z.Raw())
+		default:
+			buf.Write(z.Raw())
+		}
+	}
+}
+
+// cutWords returns text up to the end of its first n words, without the space
+// after them, and reports whether anything but space was cut from it
+func cutWords(text string, n int) (string, bool) {
+	inWord := false
+	for i, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			inWord = false
+		case !inWord:
+			if n == 0 {
+				return strings.TrimRightFunc(text[:i], unicode.IsSpace), true
+			}
+			inWord = true
+			n--
+		}
+	}
+	return text, false
+}
+
+// Text returns the text of the HTML s, with its whitespace collapsed, cut at a
+// word boundary to at most max characters, including the Ellipsis appended if
+// it was cut
+func Text(s string, max int) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var buf bytes.Buffer
+	for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+		switch tt {
+		case html.TextToken:
+			buf.Write(z.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			if name, _ := z.TagName(); block[atom.Lookup(name)] {
+				buf.WriteByte(' ')
+			}
+		}
+	}
+	text := strings.Join(strings.Fields(buf.String()), " ")
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	// leave room for the Ellipsis, preferring to cut before a word which
+	// doesn't fit
+	limit := max - utf8.RuneCountInString(Ellipsis)
+	if limit < 0 {
+		limit = 0
+	}
+	runes := []rune(text)
+	cut := string(runes[:limit])
+	if limit < len(runes) && runes[limit] != ' ' {
+		if i := strings.LastIndex(cut, " "); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.TrimRight(cut, " ,;:-–—") + Ellipsis
+}
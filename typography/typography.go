@@ -0,0 +1,233 @@
+// Package typography typesets rendered HTML according to the conventions of a
+// language: curly quotes and apostrophes, dashes and ellipses in place of
+// their typewriter approximations, non-breaking spaces tying short words to
+// the word after them, and no headings or titles ending on a lone word.
+package typography
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	nbsp       = "\u00a0"
+	narrowNbsp = "\u202f"
+)
+
+// A Locale holds the typographic conventions of a language.
+type Locale struct {
+	// Name identifies the Locale, e.g. "en"
+	Name string
+	// Quotes holds the opening and closing double quotes, then the opening
+	// and closing single quotes
+	Quotes [4]string
+	// Dash replaces a hyphen with a space either side, including the spaces
+	Dash string
+	// ShortWord is the length of the longest word tied to the next one, so
+	// it doesn't end a line
+	ShortWord int
+	// SpaceBefore lists the punctuation preceded by a narrow non-breaking
+	// space rather than a normal one
+	SpaceBefore string
+}
+
+// Locales lists the Locales text may be typeset for
+var Locales = []*Locale{
+	{Name: "en", Quotes: [4]string{"“", "”", "‘", "’"}, Dash: nbsp + "– ", ShortWord: 1},
+	{Name: "de", Quotes: [4]string{"„", "“", "‚", "‘"}, Dash: nbsp + "– ", ShortWord: 1},
+	{Name: "fr", Quotes: [4]string{"«" + narrowNbsp, narrowNbsp + "»", "‹" + narrowNbsp, narrowNbsp + "›"}, Dash: nbsp + "– ", ShortWord: 2, SpaceBefore: ";:!?"},
+	{Name: "pl", Quotes: [4]string{"„", "”", "‚", "’"}, Dash: nbsp + "– ", ShortWord: 1},
+}
+
+// ParseLocale returns the Locale named s, e.g. "fr", or nil, leaving text
+// alone, if s is empty or "off"
+func ParseLocale(s string) (*Locale, error) {
+	if s == "" || s == "off" {
+		return nil, nil
+	}
+	for _, l := range Locales {
+		if l.Name == s {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("typography: unknown locale %q", s)
+}
+
+// skipped lists the elements whose text is left as written
+var skipped = map[atom.Atom]bool{
+	atom.Code: true, atom.Kbd: true, atom.Pre: true, atom.Samp: true,
+	atom.Script: true, atom.Style: true, atom.Textarea: true, atom.Var: true,
+}
+
+// headings lists the elements which shouldn't end on a lone word
+var headings = map[atom.Atom]bool{
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true,
+	atom.H6: true,
+}
+
+// inline lists the elements whose text runs on into their neighbours', so
+// quotes and words are matched across them
+var inline = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.B: true, atom.Cite: true, atom.Dfn: true,
+	atom.Em: true, atom.I: true, atom.Mark: true, atom.Q: true, atom.S: true,
+	atom.Small: true, atom.Span: true, atom.Strong: true, atom.Sub: true,
+	atom.Sup: true, atom.Time: true, atom.U: true,
+}
+
+// HTML typesets the text of the HTML h for the Locale, leaving code alone, or
+// returns h unchanged if the Locale is nil
+func (l *Locale) HTML(h template.HTML) (template.HTML, error) {
+	if l == nil {
+		return h, nil
+	}
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(string(h)), body)
+	if err != nil {
+		return "", err
+	}
+	t := &typesetter{Locale: l, prev: ' '}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		t.node(n)
+		if err := html.Render(&buf, n); err != nil {
+			return "", err
+		}
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// Title typesets the plain text s, such as an article's title, for the Locale,
+// tying its last two words together so it doesn't end on a lone word, or
+// returns s unchanged if the Locale is nil
+func (l *Locale) Title(s string) string {
+	if l == nil {
+		return s
+	}
+	t := &typesetter{Locale: l, prev: ' '}
+	return unwidow(t.text(s))
+}
+
+// unwidow replaces the last space in s with a non-breaking one, unless s is
+// only two words long so tying them would leave nothing to break
+func unwidow(s string) string {
+	s = strings.TrimRightFunc(s, unicode.IsSpace)
+	i := strings.LastIndex(s, " ")
+	if i < 0 || !strings.Contains(s[:i], " ") {
+		return s
+	}
+	return s[:i] + nbsp + s[i+1:]
+}
+
+// A typesetter typesets text in turn, remembering the character before it
+type typesetter struct {
+	*Locale
+	prev rune
+	// the length in letters of the word ending at prev, or -1 if it isn't
+	// just letters
+	word int
+}
+
+// node typesets the text beneath n
+func (t *typesetter) node(n *html.Node) {
+	switch {
+	case n.Type == html.TextNode:
+		n.Data = t.text(n.Data)
+		return
+	case n.Type != html.ElementNode:
+		return
+	case skipped[n.DataAtom]:
+		// quotes after code close rather than open
+		t.prev, t.word = 'x', -1
+		return
+	}
+	if !inline[n.DataAtom] {
+		t.prev, t.word = ' ', 0
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		t.node(c)
+	}
+	if headings[n.DataAtom] {
+		if last := n.LastChild; last != nil && last.Type == html.TextNode {
+			last.Data = unwidow(last.Data)
+		}
+	}
+	if !inline[n.DataAtom] {
+		t.prev, t.word = ' ', 0
+	}
+}
+
+// text typesets s, which follows the text already typeset
+func (t *typesetter) text(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		next, _ := utf8.DecodeRuneInString(s[i+size:])
+		out := string(r)
+		switch {
+		case r == '.' && strings.HasPrefix(s[i:], "..."):
+			out, size = "…", 3
+		case r == '-' && strings.HasPrefix(s[i:], "---"):
+			out, size = "—", 3
+		case r == '-' && strings.HasPrefix(s[i:], "--"):
+			out, size = "–", 2
+		case r == '-' && (t.prev == ' ' || t.prev == '\u00a0') && next == ' ':
+			// replace the spaces either side too
+			trimmed := strings.TrimSuffix(strings.TrimSuffix(buf.String(), " "), nbsp)
+			buf.Reset()
+			buf.WriteString(trimmed)
+			out, size = t.Dash, 2
+		case r == '"':
+			out = t.quote(0, next)
+		case r == '\'':
+			if unicode.IsLetter(t.prev) || unicode.IsDigit(t.prev) {
+				// an apostrophe, or a closing quote, which are alike
+				// in English but not always elsewhere
+				out = "’"
+				if !unicode.IsLetter(next) {
+					out = t.Quotes[3]
+				}
+			} else {
+				out = t.quote(2, next)
+			}
+		case strings.ContainsRune(t.SpaceBefore, r) && t.prev == ' ':
+			trimmed := strings.TrimSuffix(buf.String(), " ")
+			buf.Reset()
+			buf.WriteString(trimmed)
+			out = narrowNbsp + string(r)
+		case r == ' ' && t.word > 0 && t.word <= t.ShortWord:
+			out = nbsp
+		}
+		buf.WriteString(out)
+
+		last, _ := utf8.DecodeLastRuneInString(out)
+		switch {
+		case last == '\u00a0' || last == '\u202f':
+			t.prev, t.word = last, 0
+		case unicode.IsSpace(last):
+			t.prev, t.word = ' ', 0
+		case unicode.IsLetter(last) && t.word >= 0:
+			t.prev, t.word = last, t.word+1
+		default:
+			t.prev, t.word = last, -1
+		}
+		i += size
+	}
+	return buf.String()
+}
+
+// quote returns the opening or closing quote, starting at Quotes[i], which a
+// straight quote between prev and next stands for
+func (t *typesetter) quote(i int, next rune) string {
+	opens := t.prev == ' ' || strings.ContainsRune("([{“‘„‚«‹—–\u00a0\u202f", t.prev)
+	if opens && !unicode.IsSpace(next) {
+		return t.Quotes[i]
+	}
+	return t.Quotes[i+1]
+}
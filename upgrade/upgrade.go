@@ -0,0 +1,215 @@
+// Package upgrade lets an HTTP server replace itself with a new version of its
+// binary without dropping requests, tableflip-style: the listening socket is
+// handed to a fresh process started from the binary, and once that process
+// is ready to serve the old one stops accepting connections, finishes those it
+// has and exits. Upgrades aren't supported on Windows.
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the environment variable telling a process started by Upgrade that it has
+// inherited the listener and readiness pipe as its first extra files
+const envInherit = "GOURNAL_UPGRADE"
+
+// Timeout is how long Upgrade waits for the new process to be ready
+var Timeout = time.Minute
+
+// DrainTimeout is how long Serve waits, after an Upgrade, for the requests on
+// connections already accepted to be served
+var DrainTimeout = 30 * time.Second
+
+// An Upgrader holds a server's listener, inherited from the process it
+// replaced if there was one.
+type Upgrader struct {
+	// PIDFile, if set, records the PID of the process serving, so process
+	// supervisors such as systemd can follow upgrades
+	PIDFile string
+
+	ln net.Listener
+	// tells the process being replaced that this one is ready
+	ready *os.File
+	// whether an Upgrade has handed the listener over
+	upgraded chan struct{}
+}
+
+// New returns an Upgrader listening on the TCP address addr, or on the
+// listener inherited from the process being replaced
+func New(addr string) (*Upgrader, error) {
+	if os.Getenv(envInherit) == "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return &Upgrader{ln: ln, upgraded: make(chan struct{})}, nil
+	}
+
+	os.Unsetenv(envInherit)
+	f := os.NewFile(3, "listener")
+	ln, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: inheriting the listener: %v", err)
+	}
+	return &Upgrader{ln: ln, ready: os.NewFile(4, "ready"), upgraded: make(chan struct{})}, nil
+}
+
+// Listener returns the listener to serve on
+func (u *Upgrader) Listener() net.Listener {
+	return u.ln
+}
+
+// Serve tells any process being replaced that this one is Ready, then serves
+// srv on the listener until an Upgrade hands it over, over TLS if srv has a
+// TLSConfig holding its certificates. It then serves the
+// requests on the connections it had already accepted, for up to
+// DrainTimeout, and returns nil. http.Server.Shutdown isn't used as it drops
+// accepted connections which haven't sent their request yet.
+func (u *Upgrader) Serve(srv *http.Server) error {
+	var conns sync.WaitGroup
+	hook := srv.ConnState
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			conns.Add(1)
+		case http.StateHijacked, http.StateClosed:
+			conns.Done()
+		}
+		if hook != nil {
+			hook(c, state)
+		}
+	}
+	if err := u.Ready(); err != nil {
+		return err
+	}
+
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ServeTLS(u.ln, "", "")
+	} else {
+		err = srv.Serve(u.ln)
+	}
+	select {
+	case <-u.upgraded:
+	default:
+		return err
+	}
+	// every connection accepted was counted before Serve returned, so all
+	// that's left is to let them finish
+	srv.SetKeepAlivesEnabled(false)
+	drained := make(chan struct{})
+	go func() {
+		conns.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(DrainTimeout):
+		srv.Close()
+	}
+	return nil
+}
+
+// Ready records that this process is serving, in the PIDFile if set, and
+// tells the process it is replacing, if any, to finish up
+func (u *Upgrader) Ready() error {
+	if u.PIDFile != "" {
+		err := ioutil.WriteFile(u.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if u.ready == nil {
+		return nil
+	}
+	_, err := u.ready.Write([]byte{1})
+	if cerr := u.ready.Close(); err == nil {
+		err = cerr
+	}
+	u.ready = nil
+	return err
+}
+
+// Upgrade starts a new process from the current binary, with the same
+// arguments, handing it the listener, and waits for it to be Ready. If it
+// succeeds this process stops accepting connections, and Serve returns once
+// those accepted are done with; if it fails this process carries on serving.
+func (u *Upgrader) Upgrade() error {
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if _, err := Start(cmd, u.ln); err != nil {
+		return err
+	}
+	close(u.upgraded)
+	return u.ln.Close()
+}
+
+// Start starts cmd, a process serving with an Upgrader, handing it ln to
+// serve on, and waits for it to be Ready, returning a channel receiving the
+// result of cmd.Wait once it exits. cmd is killed if it isn't ready within
+// Timeout. Besides Upgrade, it lets a server be started on a listener of the
+// caller's choosing, such as one on a free port for tests.
+func Start(cmd *exec.Cmd, ln net.Listener) (exited <-chan error, err error) {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, errors.New("upgrade: only TCP listeners can be handed over")
+	}
+	lf, err := tl.File()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: %v", err)
+	}
+	defer lf.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, envInherit+"=1")
+	cmd.ExtraFiles = []*os.File{lf, w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: starting %s: %v", cmd.Path, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	readied := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := r.Read(b)
+		readied <- err
+	}()
+	select {
+	case err := <-readied:
+		if err != nil {
+			// the pipe closed without a word, e.g. because the process died
+			cmd.Process.Kill()
+			return nil, fmt.Errorf("upgrade: the new process didn't become ready: %v", err)
+		}
+		return done, nil
+	case err := <-done:
+		return nil, fmt.Errorf("upgrade: the new process exited before it was ready: %v", err)
+	case <-time.After(Timeout):
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("upgrade: the new process wasn't ready within %v", Timeout)
+	}
+}
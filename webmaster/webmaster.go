@@ -0,0 +1,187 @@
+// Package webmaster checks what search engines see of a site: that its
+// sitemap can be fetched and lists the site's own URLs, and that its
+// robots.txt is well formed, lets the site be crawled and points at the
+// sitemap.
+package webmaster
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the most of a response read when checking it
+const maxBody = 10 << 20
+
+// A Check is the outcome of checking one thing about a site. It passes unless
+// there are Problems; Notes are informational.
+type Check struct {
+	Name     string
+	URL      string
+	Problems []string
+	Notes    []string
+}
+
+// OK reports whether the Check passed
+func (c *Check) OK() bool {
+	return len(c.Problems) == 0
+}
+
+func (c *Check) problem(format string, args ...interface{}) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+func (c *Check) note(format string, args ...interface{}) {
+	c.Notes = append(c.Notes, fmt.Sprintf(format, args...))
+}
+
+// A Checker fetches a site's sitemap and robots.txt with Client, or
+// http.DefaultClient with a timeout if it is nil.
+type Checker struct {
+	Client *http.Client
+}
+
+// Run checks the site at base, an absolute URL without a trailing slash, as
+// search engines would see it
+func (ch *Checker) Run(base string) []*Check {
+	return []*Check{ch.Sitemap(base), ch.Robots(base)}
+}
+
+// Sitemap checks that base/sitemap.xml can be fetched, is a valid sitemap and
+// only lists URLs beneath base
+func (ch *Checker) Sitemap(base string) *Check {
+	c := &Check{Name: "Sitemap", URL: base + "/sitemap.xml"}
+	body, status, err := ch.get(c.URL)
+	switch {
+	case err != nil:
+		c.problem("couldn't be fetched: %v", err)
+		return c
+	case status != http.StatusOK:
+		c.problem("responded %d %s rather than 200 OK", status, http.StatusText(status))
+		return c
+	}
+
+	var urlset struct {
+		XMLName xml.Name `xml:"urlset"`
+		URLs    []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal(body, &urlset); err != nil {
+		c.problem("isn't a valid sitemap: %v", err)
+		return c
+	}
+	if len(urlset.URLs) == 0 {
+		c.problem("lists no URLs")
+	}
+	foreign := 0
+	for _, u := range urlset.URLs {
+		if u.Loc != base && !strings.HasPrefix(u.Loc, base+"/") {
+			if foreign == 0 {
+				c.problem("lists %s, which isn't beneath %s, so search engines will ignore it; check BaseURL", u.Loc, base)
+			}
+			foreign++
+		}
+	}
+	if foreign > 1 {
+		c.problem("lists %d URLs in all which aren't beneath %s", foreign, base)
+	}
+	c.note("lists %d URLs", len(urlset.URLs))
+	return c
+}
+
+// Robots checks that base/robots.txt, if there is one, is made up of known
+// directives, doesn't stop every crawler crawling the whole site and points
+// at the sitemap
+func (ch *Checker) Robots(base string) *Check {
+	c := &Check{Name: "robots.txt", URL: base + "/robots.txt"}
+	body, status, err := ch.get(c.URL)
+	switch {
+	case err != nil:
+		c.problem("couldn't be fetched: %v", err)
+		return c
+	case status == http.StatusNotFound:
+		c.note("there is none, so crawlers may crawl everything, but they won't find the sitemap unless it's submitted to them")
+		return c
+	case status != http.StatusOK:
+		// crawlers treat server errors as forbidding all crawling
+		c.problem("responded %d %s rather than 200 OK or 404 Not Found", status, http.StatusText(status))
+		return c
+	}
+
+	sitemap := base + "/sitemap.xml"
+	var agents []string
+	grouped := false // whether the last line was a rule, ending the group's agents
+	listed, blocked := false, false
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			c.problem("line %d isn't a directive: %q", n, line)
+			continue
+		}
+		field, value := strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])
+		switch field {
+		case "user-agent":
+			if grouped {
+				agents, grouped = nil, false
+			}
+			agents = append(agents, value)
+		case "disallow", "allow", "crawl-delay":
+			if agents == nil {
+				c.problem("line %d, %s, isn't preceded by a User-agent", n, field)
+			}
+			grouped = true
+			if field == "disallow" && value == "/" {
+				for _, agent := range agents {
+					if agent == "*" {
+						blocked = true
+					}
+				}
+			}
+		case "sitemap":
+			if value == sitemap {
+				listed = true
+			} else {
+				c.problem("line %d points at the sitemap %s rather than %s", n, value, sitemap)
+			}
+		default:
+			c.problem("line %d has the unknown directive %q", n, line[:i])
+		}
+	}
+	if blocked {
+		c.problem("disallows every crawler from the whole site")
+	}
+	if !listed {
+		c.problem("doesn't point crawlers at the sitemap; add \"Sitemap: %s\"", sitemap)
+	}
+	return c
+}
+
+// get fetches url, returning at most maxBody of its body and its status
+func (ch *Checker) get(url string) ([]byte, int, error) {
+	client := ch.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBody))
+	return b, res.StatusCode, err
+}